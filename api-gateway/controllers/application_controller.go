@@ -5,29 +5,60 @@ package controllers
 import (
 	"api-gateway/models"
 	"api-gateway/repositorys"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"service-shared/database"
+	sharedhttp "service-shared/http"
 	sharedmodels "service-shared/shared-models"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	//IdempotencyKeyHeader is the header clients use to safely retry a create application request
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+
+	maxNameFilterLength = 100
+
+	maxCallbackURLLength = 2048
+
+	maxBatchSize = 500
+	// Allows 500 items with long names and callback URLs, while stopping a client from making the gateway buffer any size of body
+	maxBatchBodyBytes = 4 << 20
 )
 
 /*
 LoanAppController is an HTTP controller which manages receiving
 HTTP requests to the API. It hands of responsibilities for DB
-interaction or message queue interaction to database.Repository
-and repositorys.PublishQueue respectively.
+interaction to database.Repository. Messages for the create
+application queue are written to the outbox by the repository,
+and published by a repositorys.OutboxRelay. Changes to the status
+of an application made by the gateway are published to eventQueue.
 */
 type LoanAppController struct {
-	repository   database.Repository
-	messageQueue repositorys.PublishQueue
+	repository database.Repository
+	eventQueue repositorys.EventQueue
 }
 
 //NewLoanAppController returns a LoanAppController struct
-func NewLoanAppController(repository database.Repository, messageQueue repositorys.PublishQueue) *LoanAppController {
-	return &LoanAppController{repository: repository, messageQueue: messageQueue}
+func NewLoanAppController(repository database.Repository, eventQueue repositorys.EventQueue) *LoanAppController {
+	return &LoanAppController{repository: repository, eventQueue: eventQueue}
 }
 
 // The following error structs are used to build nicer API documentation via swagger
@@ -50,6 +81,12 @@ type HTTPNotFoundError struct {
 	Message string `json:"error" example:"status not found"`
 }
 
+// HTTPConflictError is returned when a request conflicts with an earlier one
+type HTTPConflictError struct {
+	Code    int    `json:"code" example:"409"`
+	Message string `json:"error" example:"status conflict"`
+}
+
 //GetApplication godoc
 //@Summary Gets a loan application
 //@Tags applications
@@ -68,7 +105,7 @@ func (controller LoanAppController) GetApplication(ginCtx *gin.Context) {
 		return
 	}
 
-	statusResponse, err := controller.repository.GetApplication(applicationID)
+	statusResponse, err := controller.repository.GetApplication(ginCtx.Request.Context(), applicationID)
 	if err != nil {
 		if errors.Is(err, database.InternalError) {
 			newInternalError(ginCtx, http.StatusInternalServerError, err)
@@ -83,43 +120,393 @@ func (controller LoanAppController) GetApplication(ginCtx *gin.Context) {
 	ginCtx.IndentedJSON(http.StatusOK, clientResponse)
 }
 
+//GetApplicationHistory godoc
+//@Summary Gets the history of a loan application
+//@Tags applications
+//@Description Gets the full lifecycle of a loan application for support staff. This includes the ID of
+//@Description the application with the bank, when it was created, submitted and decided, and each change to its status.
+//@Produce json
+//@Param application_id path string true "Loan Application ID"
+//@Success 200 {object} models.ApplicationHistoryResponse "Application history retrieved"
+//@Failure 404 {object} HTTPNotFoundError "When an application ID is not found"
+//@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs"
+//@Router /api/application/{application_id}/history [get]
+func (controller LoanAppController) GetApplicationHistory(ginCtx *gin.Context) {
+	applicationID := ginCtx.Param("application_id")
+
+	entry, err := controller.repository.GetApplication(ginCtx.Request.Context(), applicationID)
+	if err != nil {
+		if errors.Is(err, database.InternalError) {
+			newInternalError(ginCtx, http.StatusInternalServerError, err)
+			return
+		}
+
+		newNotFoundError(ginCtx, http.StatusNotFound, err)
+		return
+	}
+
+	ginCtx.IndentedJSON(http.StatusOK, dbEntryToHistoryResp(entry))
+}
+
+//WithdrawApplication godoc
+//@Summary Withdraws a loan application
+//@Tags applications
+//@Description Withdraws a loan application which has not been decided. An application which has not been sent to the bank
+//@Description is not sent, and an application which has been sent is no longer polled. Withdrawing an application which
+//@Description has already been withdrawn returns it unchanged, so a request can be safely retried.
+//@Produce json
+//@Param application_id path string true "Loan Application ID"
+//@Success 200 {object} models.ClientApplicationView "Application withdrawn"
+//@Failure 404 {object} HTTPNotFoundError "When an application ID is not found"
+//@Failure 409 {object} HTTPConflictError "When the application has already been decided"
+//@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs"
+//@Router /api/application/{application_id}/cancel [post]
+func (controller LoanAppController) WithdrawApplication(ginCtx *gin.Context) {
+	applicationID := ginCtx.Param("application_id")
+
+	change := sharedmodels.StatusChange{
+		Status: sharedmodels.Withdrawn,
+		Source: sharedmodels.APIGatewayService,
+		Reason: "Withdrawn by the applicant",
+		At:     time.Now().UTC(),
+	}
+	err := controller.repository.UpdateApplicationStatus(ginCtx.Request.Context(), applicationID, change)
+	var transitionErr *sharedmodels.TransitionError
+	switch {
+	case err == nil:
+		controller.publishStatusChanged(ginCtx.Request.Context(), applicationID, change)
+	case errors.As(err, &transitionErr) && transitionErr.From == sharedmodels.Withdrawn:
+		// Withdrawn by an earlier request
+	case errors.As(err, &transitionErr):
+		newConflictError(ginCtx, http.StatusConflict, err)
+		return
+	case errors.Is(err, database.InternalError):
+		newInternalError(ginCtx, http.StatusInternalServerError, err)
+		return
+	default:
+		newNotFoundError(ginCtx, http.StatusNotFound, err)
+		return
+	}
+
+	entry, err := controller.repository.GetApplication(ginCtx.Request.Context(), applicationID)
+	if err != nil {
+		newInternalError(ginCtx, http.StatusInternalServerError, database.InternalError)
+		return
+	}
+
+	ginCtx.IndentedJSON(http.StatusOK, dbEntryToClientView(entry))
+}
+
+/*
+publishStatusChanged tells the clients waiting on an application that the gateway has changed its status.
+The event is published in the trace of ctx, so that the services which receive it continue the trace of the request.
+
+The event is best effort. Clients can still read the status from the API gateway, so failing
+to publish it is logged rather than returned.
+*/
+func (controller LoanAppController) publishStatusChanged(ctx context.Context, applicationID string, change sharedmodels.StatusChange) {
+	err := controller.eventQueue.PublishStatusChanged(ctx, sharedmodels.StatusChangedEvent{
+		ApplicationID: applicationID,
+		Status:        change.Status,
+		Reason:        change.Reason,
+		At:            change.At,
+	})
+	if err != nil {
+		log.Printf("Failed to publish status changed event for application %s : %s\n", applicationID, err)
+	}
+}
+
 //GetApplicationsWithStatus godoc
 //@Summary Gets all loans with status
 //@Tags applications
-//@Description Gets all loans based on a provided status
+//@Description Gets a page of the loans with a provided status, sorted by creation time.
+//@Description If there are more loans, the response includes a next_page_token. Pass it as the page_token
+//@Description parameter, along with the same status and sort, to get the next page.
 //@Produce json
-//@Param status query string true "Status [pending, completed, rejected]"
+//@Param status query string true "Status [queued, submitted, polling, pending, completed, rejected, timed_out, failed, withdrawn]"
+//@Param limit query int false "The maximum number of loans to return, from 1 to 500" default(50)
+//@Param page_token query string false "The next_page_token returned with the previous page"
+//@Param sort query string false "Sort by creation time [asc, desc]" default(asc)
 //@Success 200 {object} models.GetAppsWithStatusResponse "Applications retrieved"
-//@Failure 400 {object} HTTPBadRequestError "When the status parameter is not provided or is not a valid value"
+//@Failure 400 {object} HTTPBadRequestError "When the status parameter is not provided, or a parameter is not a valid value"
 //@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs"
 //@Router /api/applications-with-status [get]
 func (controller LoanAppController) GetApplicationsWithStatus(ginCtx *gin.Context) {
 	status := strings.ToLower(ginCtx.Query("status"))
 	if !sharedmodels.Status(status).IsValid() {
-		newBadRequest(ginCtx, http.StatusBadRequest, errors.New(fmt.Sprintf("The status parameter is required and must be one of [%s %s %s]",
-			sharedmodels.Pending, sharedmodels.Completed, sharedmodels.Rejected)))
+		newBadRequest(ginCtx, http.StatusBadRequest, errors.New(fmt.Sprintf("The status parameter is required and must be one of %s",
+			sharedmodels.Statuses)))
+		return
+	}
+
+	page, err := getPageRequest(ginCtx)
+	if err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, err)
+		return
+	}
+
+	applications, nextPageToken, err := controller.repository.GetApplicationsWithStatus(ginCtx.Request.Context(), sharedmodels.Status(status), page)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidPageToken) {
+			newBadRequest(ginCtx, http.StatusBadRequest, errors.New("The page_token parameter is not valid"))
+			return
+		}
+
+		newInternalError(ginCtx, http.StatusInternalServerError, err)
 		return
 	}
 
-	applications, err := controller.repository.GetApplicationsWithStatus(sharedmodels.Status(status))
+	clientResponse := models.GetAppsWithStatusResponse{
+		ApplicationsWithStatus: dbEntryToClientResp(applications),
+		NextPageToken:          nextPageToken,
+	}
+	ginCtx.IndentedJSON(http.StatusOK, clientResponse)
+}
+
+//getPageRequest reads the limit, page_token and sort query parameters, applying their defaults.
+func getPageRequest(ginCtx *gin.Context) (sharedmodels.PageRequest, error) {
+	page := sharedmodels.PageRequest{
+		Limit:     defaultPageLimit,
+		PageToken: ginCtx.Query("page_token"),
+		Sort:      sharedmodels.SortOrder(strings.ToLower(ginCtx.DefaultQuery("sort", string(sharedmodels.Oldest)))),
+	}
+
+	if limit, ok := ginCtx.GetQuery("limit"); ok {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxPageLimit {
+			return page, errors.New(fmt.Sprintf("The limit parameter must be a number from 1 to %d", maxPageLimit))
+		}
+		page.Limit = parsed
+	}
+
+	if !page.Sort.IsValid() {
+		return page, errors.New(fmt.Sprintf("The sort parameter must be one of [%s %s]", sharedmodels.Oldest, sharedmodels.Newest))
+	}
+
+	return page, nil
+}
+
+//SearchApplications godoc
+//@Summary Searches for loan applications
+//@Tags applications
+//@Description Gets a page of the loans matching every filter provided, sorted by creation time. At least one filter is required.
+//@Description If there are more loans, the response includes a next_page_token. Pass it as the page_token
+//@Description parameter, along with the same filters and sort, to get the next page.
+//@Produce json
+//@Param last_name query string false "Last name, which must match exactly"
+//@Param first_name_prefix query string false "The start of the first name"
+//@Param created_from query string false "Only loans created at or after this RFC 3339 time, eg 2022-07-01T00:00:00Z"
+//@Param created_to query string false "Only loans created before this RFC 3339 time"
+//@Param status query []string false "Statuses [queued, submitted, polling, pending, completed, rejected, timed_out, failed, withdrawn]" collectionFormat(multi)
+//@Param limit query int false "The maximum number of loans to return, from 1 to 500" default(50)
+//@Param page_token query string false "The next_page_token returned with the previous page"
+//@Param sort query string false "Sort by creation time [asc, desc]" default(asc)
+//@Success 200 {object} models.SearchApplicationsResponse "Applications retrieved"
+//@Failure 400 {object} HTTPBadRequestError "When no filter is provided, or a parameter is not a valid value"
+//@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs"
+//@Router /api/applications [get]
+func (controller LoanAppController) SearchApplications(ginCtx *gin.Context) {
+	filter, err := getApplicationFilter(ginCtx)
 	if err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := getPageRequest(ginCtx)
+	if err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, err)
+		return
+	}
+
+	applications, nextPageToken, err := controller.repository.SearchApplications(ginCtx.Request.Context(), filter, page)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidPageToken) {
+			newBadRequest(ginCtx, http.StatusBadRequest, errors.New("The page_token parameter is not valid"))
+			return
+		}
+
 		newInternalError(ginCtx, http.StatusInternalServerError, err)
 		return
 	}
 
-	clientResponse := models.GetAppsWithStatusResponse{ApplicationsWithStatus: dbEntryToClientResp(applications)}
+	clientResponse := models.SearchApplicationsResponse{
+		Applications:  dbEntryToClientResp(applications),
+		NextPageToken: nextPageToken,
+	}
 	ginCtx.IndentedJSON(http.StatusOK, clientResponse)
 }
 
+//ExportApplications godoc
+//@Summary Exports loan applications
+//@Tags applications
+//@Description Streams every loan matching the filters provided, oldest first, as CSV or newline delimited JSON.
+//@Description Each row has the same fields as models.ClientApplicationView, and a CSV export starts with a header row.
+//@Description Without any filters, every loan is exported.
+//@Description If an error occurs once the export has started, it is cut short, so the last row may be incomplete.
+//@Produce text/csv
+//@Produce application/x-ndjson
+//@Param format query string true "Format [csv, ndjson]"
+//@Param status query []string false "Statuses [queued, submitted, polling, pending, completed, rejected, timed_out, failed, withdrawn]" collectionFormat(multi)
+//@Param from query string false "Only loans created at or after this RFC 3339 time, eg 2022-07-01T00:00:00Z"
+//@Param to query string false "Only loans created before this RFC 3339 time"
+//@Success 200 {array} models.ClientApplicationView "Applications exported"
+//@Failure 400 {object} HTTPBadRequestError "When the format parameter is not provided, or a parameter is not a valid value"
+//@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs before the export starts"
+//@Router /api/applications/export [get]
+func (controller LoanAppController) ExportApplications(ginCtx *gin.Context) {
+	format := strings.ToLower(ginCtx.Query("format"))
+	newExportWriter, ok := exportFormats[format]
+	if !ok {
+		newBadRequest(ginCtx, http.StatusBadRequest, errors.New(fmt.Sprintf("The format parameter is required and must be one of [%s %s]",
+			csvFormat, ndjsonFormat)))
+		return
+	}
+
+	var filter sharedmodels.ApplicationFilter
+	var err error
+	if filter.Statuses, err = getStatusesQuery(ginCtx); err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, err)
+		return
+	}
+	if filter.CreatedFrom, filter.CreatedTo, err = getTimeRangeQuery(ginCtx, "from", "to"); err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, err)
+		return
+	}
+
+	// The response is only started once the first application has been read, so that an
+	// error finding the applications can still be returned as an error response
+	export := newExportWriter(ginCtx.Writer)
+	started := false
+	start := func() error {
+		started = true
+		ginCtx.Header("Content-Type", export.contentType())
+		ginCtx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"applications.%s\"", format))
+		ginCtx.Status(http.StatusOK)
+		return export.start()
+	}
+
+	err = controller.repository.ExportApplications(ginCtx.Request.Context(), filter, func(entry sharedmodels.ApplicationEntry) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return export.write(dbEntryToClientView(&entry))
+	})
+	if err == nil && !started {
+		// Nothing matched, which is still a valid export
+		err = start()
+	}
+	if err != nil {
+		if !started {
+			newInternalError(ginCtx, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("Export of applications failed after it started, the response is incomplete : %s\n", err)
+		return
+	}
+
+	if err = export.flush(); err != nil {
+		log.Printf("Failed to write the end of an export of applications : %s\n", err)
+	}
+}
+
+/*
+getApplicationFilter reads the search filters from the query parameters. A status may be repeated,
+or several given separated by commas.
+
+An error is returned if no filter is provided, or a filter is not valid.
+*/
+func getApplicationFilter(ginCtx *gin.Context) (sharedmodels.ApplicationFilter, error) {
+	filter := sharedmodels.ApplicationFilter{
+		LastName:        strings.TrimSpace(ginCtx.Query("last_name")),
+		FirstNamePrefix: strings.TrimSpace(ginCtx.Query("first_name_prefix")),
+	}
+	if len(filter.LastName) > maxNameFilterLength || len(filter.FirstNamePrefix) > maxNameFilterLength {
+		return filter, errors.New(fmt.Sprintf("The last_name and first_name_prefix parameters must be at most %d characters",
+			maxNameFilterLength))
+	}
+
+	var err error
+	if filter.Statuses, err = getStatusesQuery(ginCtx); err != nil {
+		return filter, err
+	}
+	if filter.CreatedFrom, filter.CreatedTo, err = getTimeRangeQuery(ginCtx, "created_from", "created_to"); err != nil {
+		return filter, err
+	}
+
+	if filter.IsEmpty() {
+		return filter, errors.New("At least one of the last_name, first_name_prefix, created_from, created_to or status parameters is required")
+	}
+
+	return filter, nil
+}
+
+//getStatusesQuery reads the status query parameter. It may be repeated, or several statuses given separated by commas.
+func getStatusesQuery(ginCtx *gin.Context) ([]sharedmodels.Status, error) {
+	var result []sharedmodels.Status
+	for _, statuses := range ginCtx.QueryArray("status") {
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if !sharedmodels.Status(status).IsValid() {
+				return nil, errors.New(fmt.Sprintf("Each status must be one of %s", sharedmodels.Statuses))
+			}
+			result = append(result, sharedmodels.Status(status))
+		}
+	}
+
+	return result, nil
+}
+
+//getTimeRangeQuery reads a time range from the query parameters fromName and toName. Either may be omitted.
+func getTimeRangeQuery(ginCtx *gin.Context, fromName, toName string) (*time.Time, *time.Time, error) {
+	from, err := getTimeQuery(ginCtx, fromName)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := getTimeQuery(ginCtx, toName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, errors.New(fmt.Sprintf("The %s parameter must be before %s", fromName, toName))
+	}
+
+	return from, to, nil
+}
+
+//getTimeQuery reads an RFC 3339 time from the query parameter name. It returns nil if the parameter is not provided.
+func getTimeQuery(ginCtx *gin.Context, name string) (*time.Time, error) {
+	value := ginCtx.Query(name)
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The %s parameter must be an RFC 3339 time, eg 2022-07-01T00:00:00Z", name))
+	}
+
+	parsed = parsed.UTC()
+	return &parsed, nil
+}
+
 //CreateApplication godoc
 //@Summary Create a loan application
 //@Tags applications
-//@Description Creates a new loan application
+//@Description Creates a new loan application.
+//@Description A request sent with an Idempotency-Key header can be safely retried. A retry with the same key
+//@Description and body returns the application created by the first request, rather than creating another.
+//@Description If a callback_url is given, a signed webhook is posted to it once the application is completed or rejected.
+//@Description The loan amount and annual income are in minor units of an ISO 4217 currency, and the date of birth is in the form YYYY-MM-DD.
 //@Accept json
 //@Param application body models.CreateApplicationRequest true "Create loan application"
+//@Param Idempotency-Key header string false "A unique key, of up to 255 characters, identifying this request"
 //@Produce json
 //@Success 201 {object} models.CreateApplicationResponse "Loan application created"
-//@Failure 400 {object} HTTPBadRequestError "When the request body is malformed"
+//@Failure 400 {object} HTTPBadRequestError "When the request body, loan details, callback URL or idempotency key are invalid"
+//@Failure 409 {object} HTTPConflictError "When the idempotency key was used for a different request"
 //@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs"
 //@Router /api/application [post]
 func (controller LoanAppController) CreateApplication(ginCtx *gin.Context) {
@@ -128,42 +515,212 @@ func (controller LoanAppController) CreateApplication(ginCtx *gin.Context) {
 		newBadRequest(ginCtx, http.StatusBadRequest, err)
 		return
 	}
+	if err := validateCallbackURL(createRequest.CallbackURL); err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, err)
+		return
+	}
+
+	var idempotencyKey *sharedmodels.IdempotencyKeyEntry
+	if key := ginCtx.GetHeader(IdempotencyKeyHeader); len(key) > 0 {
+		if len(key) > maxIdempotencyKeyLength {
+			newBadRequest(ginCtx, http.StatusBadRequest, errors.New(fmt.Sprintf("The %s header must be at most %d characters",
+				IdempotencyKeyHeader, maxIdempotencyKeyLength)))
+			return
+		}
 
-	// Add to the DB
-	applicationID, err := controller.repository.CreateApplication(createRequest.FirstName, createRequest.LastName)
+		idempotencyKey = &sharedmodels.IdempotencyKeyEntry{Key: key, RequestHash: hashRequest(createRequest)}
+		if controller.replayIdempotentRequest(ginCtx, createRequest, idempotencyKey) {
+			return
+		}
+	}
+
+	// Add to the DB. The message for the create application queue is written
+	// to the outbox in the same transaction, the outbox relay will publish it.
+	applicationID, err := controller.repository.CreateApplication(ginCtx.Request.Context(), createRequest.NewApplication(), idempotencyKey)
 	if err != nil {
-		newInternalError(ginCtx, http.StatusInternalServerError, err)
+		// A concurrent request with the same key was stored first
+		if errors.Is(err, database.ErrIdempotencyKeyExists) && controller.replayIdempotentRequest(ginCtx, createRequest, idempotencyKey) {
+			return
+		}
+
+		newInternalError(ginCtx, http.StatusInternalServerError, database.InternalError)
 		return
 	}
 
-	// Push message onto the message queue
-	loanApplication := sharedmodels.CreateLoanMessage{
-		ApplicationID: applicationID,
-		FirstName:     createRequest.FirstName,
-		LastName:      createRequest.LastName,
+	ginCtx.IndentedJSON(http.StatusCreated, createRequestToResp(applicationID, sharedmodels.Queued, createRequest))
+}
+
+/*
+validateCallbackURL returns an error unless callbackURL is empty, or an absolute http or https URL of a public host.
+
+A host which is an IP address must be public, and a host name must be fully qualified, so that a callback cannot name
+localhost or another container, such as mongo. This is a courtesy to the client only, as a host name may still resolve
+to an internal address. The webhook dispatcher checks each address that it connects to.
+*/
+func validateCallbackURL(callbackURL string) error {
+	if len(callbackURL) == 0 {
+		return nil
+	}
+	if len(callbackURL) > maxCallbackURLLength {
+		return errors.New(fmt.Sprintf("The callback_url must be at most %d characters", maxCallbackURLLength))
+	}
+
+	parsed, err := url.ParseRequestURI(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Hostname()) == 0 {
+		return errors.New("The callback_url must be an absolute http or https URL")
 	}
 
-	queueErr := controller.messageQueue.PublishLoanRequest(loanApplication)
-	if queueErr != nil {
-		// We failed to publish the loan application to the create queue ...
-		// Unfortunately, according to RabbitMQ docs, this does not indicate whether
-		// the MQ server has received this msg.
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		if !sharedhttp.IsPublicIP(ip) {
+			return errors.New("The callback_url must be a public address")
+		}
+	} else if !strings.Contains(host, ".") || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("The callback_url must be a public address")
+	}
+
+	return nil
+}
 
-		// Remove the entry from the DB and respond with an internal error
-		controller.repository.RemoveApplication(applicationID)
-		fmt.Println(fmt.Sprintf("Encountered an error publishing to the queue %s", queueErr))
-		newInternalError(ginCtx, http.StatusInternalServerError, queueErr)
+//CreateApplications godoc
+//@Summary Create a batch of loan applications
+//@Tags applications
+//@Description Creates a loan application for each item of an array of up to 500 requests.
+//@Description Each item is validated on its own. The valid items are created together, and the invalid items are
+//@Description reported in the results, by their index in the array. Either every valid item is created, or none are.
+//@Description 201 is returned when every item was created, 207 when only some were, and 422 when no item was valid.
+//@Description Batches are not idempotent, the Idempotency-Key header is not supported.
+//@Accept json
+//@Param applications body []models.CreateApplicationRequest true "Create loan applications"
+//@Produce json
+//@Success 201 {object} models.BatchCreateApplicationsResponse "Every loan application created"
+//@Success 207 {object} models.BatchCreateApplicationsResponse "Some loan applications created"
+//@Failure 400 {object} HTTPBadRequestError "When the request body is not an array of 1 to 500 items"
+//@Failure 413 {object} HTTPBadRequestError "When the request body is larger than 4 MiB"
+//@Failure 422 {object} models.BatchCreateApplicationsResponse "When no item is valid"
+//@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs, no application is created"
+//@Router /api/applications/batch [post]
+func (controller LoanAppController) CreateApplications(ginCtx *gin.Context) {
+	// The body is read before it is decoded, so that a body which is too large can be told apart from one which is malformed
+	ginCtx.Request.Body = http.MaxBytesReader(ginCtx.Writer, ginCtx.Request.Body, maxBatchBodyBytes)
+	body, err := io.ReadAll(ginCtx.Request.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		newBadRequest(ginCtx, http.StatusRequestEntityTooLarge, errors.New(fmt.Sprintf("The request body must not be larger than %d bytes", maxBatchBodyBytes)))
+		return
+	}
+	if err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, errors.New("The request body could not be read"))
 		return
 	}
 
-	fmt.Printf("Sent message %#v to queue\n", loanApplication)
-	clientResponse := models.CreateApplicationResponse{
-		ApplicationID: applicationID,
-		Status:        sharedmodels.Pending,
-		FirstName:     createRequest.FirstName,
-		LastName:      createRequest.LastName,
+	// Items are decoded one at a time, so that a malformed item does not fail the whole batch
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		newBadRequest(ginCtx, http.StatusBadRequest, errors.New("The request body must be a JSON array of applications"))
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatchSize {
+		newBadRequest(ginCtx, http.StatusBadRequest, errors.New(fmt.Sprintf("A batch must have between 1 and %d applications", maxBatchSize)))
+		return
 	}
-	ginCtx.IndentedJSON(http.StatusCreated, clientResponse)
+
+	// Purposefully init to empty so that clients don't get 'nil' in JSON response
+	response := models.BatchCreateApplicationsResponse{Results: []models.BatchCreateApplicationResult{}}
+	var applications []sharedmodels.NewApplication
+	var createdIndexes []int
+	for i, item := range items {
+		createRequest, err := decodeBatchItem(item)
+		if err != nil {
+			response.Results = append(response.Results, models.BatchCreateApplicationResult{Index: i, Error: err.Error()})
+			response.Failed++
+			continue
+		}
+
+		response.Results = append(response.Results, models.BatchCreateApplicationResult{Index: i})
+		applications = append(applications, createRequest.NewApplication())
+		createdIndexes = append(createdIndexes, i)
+	}
+	if len(applications) == 0 {
+		ginCtx.IndentedJSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	// Add to the DB. A message for the create application queue is written to
+	// the outbox for each application, the outbox relay will publish them.
+	applicationIDs, err := controller.repository.CreateApplications(ginCtx.Request.Context(), applications)
+	if err != nil {
+		newInternalError(ginCtx, http.StatusInternalServerError, database.InternalError)
+		return
+	}
+
+	for i, index := range createdIndexes {
+		response.Results[index].ApplicationID = applicationIDs[i]
+		response.Results[index].Status = sharedmodels.Queued
+	}
+	response.Created = len(applicationIDs)
+
+	status := http.StatusCreated
+	if response.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	ginCtx.IndentedJSON(status, response)
+}
+
+//decodeBatchItem decodes and validates an item of a batch, in the same way as a single CreateApplicationRequest
+func decodeBatchItem(item json.RawMessage) (models.CreateApplicationRequest, error) {
+	var createRequest models.CreateApplicationRequest
+	if err := json.Unmarshal(item, &createRequest); err != nil {
+		return createRequest, err
+	}
+	if err := binding.Validator.ValidateStruct(&createRequest); err != nil {
+		return createRequest, err
+	}
+
+	return createRequest, validateCallbackURL(createRequest.CallbackURL)
+}
+
+/*
+replayIdempotentRequest responds to a create request whose idempotency key has already been used.
+If the earlier request had the same body, the application it created is returned, as it is now, otherwise 409.
+The application is read again, as its status has most likely moved on from queued since the key was stored.
+
+Returns false, without responding, if the key has not been used.
+*/
+func (controller LoanAppController) replayIdempotentRequest(ginCtx *gin.Context, createRequest models.CreateApplicationRequest,
+	idempotencyKey *sharedmodels.IdempotencyKeyEntry) bool {
+	existing, err := controller.repository.GetIdempotencyKey(ginCtx.Request.Context(), idempotencyKey.Key)
+	if err != nil {
+		if errors.Is(err, database.ErrIdempotencyKeyNotFound) {
+			return false
+		}
+
+		newInternalError(ginCtx, http.StatusInternalServerError, err)
+		return true
+	}
+
+	if existing.RequestHash != idempotencyKey.RequestHash {
+		newConflictError(ginCtx, http.StatusConflict, errors.New(fmt.Sprintf("The %s %s was already used for a different request",
+			IdempotencyKeyHeader, idempotencyKey.Key)))
+		return true
+	}
+
+	application, err := controller.repository.GetApplication(ginCtx.Request.Context(), existing.ApplicationID)
+	if err != nil {
+		newInternalError(ginCtx, http.StatusInternalServerError, err)
+		return true
+	}
+
+	ginCtx.IndentedJSON(http.StatusCreated, dbEntryToCreateResp(application))
+	return true
+}
+
+//hashRequest returns a hex encoded sha256 hash of the request. The request is re-marshalled, so that
+//requests differing only in formatting or field order have the same hash.
+func hashRequest(createRequest models.CreateApplicationRequest) string {
+	body, _ := json.Marshal(createRequest)
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
 }
 
 // Helper funcs for converting db entry type to a client friendly view
@@ -184,6 +741,55 @@ func dbEntryToClientView(dbEntry *sharedmodels.ApplicationEntry) models.ClientAp
 		Status:        dbEntry.Status,
 		FirstName:     dbEntry.FirstName,
 		LastName:      dbEntry.LastName,
+		CallbackURL:   dbEntry.CallbackURL,
+	}
+}
+
+func createRequestToResp(applicationID string, status sharedmodels.Status, createRequest models.CreateApplicationRequest) models.CreateApplicationResponse {
+	return models.CreateApplicationResponse{
+		ApplicationID: applicationID,
+		Status:        status,
+		FirstName:     createRequest.FirstName,
+		LastName:      createRequest.LastName,
+		CallbackURL:   createRequest.CallbackURL,
+		LoanDetails:   createRequest.LoanDetails,
+	}
+}
+
+func dbEntryToCreateResp(dbEntry *sharedmodels.ApplicationEntry) models.CreateApplicationResponse {
+	return models.CreateApplicationResponse{
+		ApplicationID: dbEntry.ID.Hex(),
+		Status:        dbEntry.Status,
+		FirstName:     dbEntry.FirstName,
+		LastName:      dbEntry.LastName,
+		CallbackURL:   dbEntry.CallbackURL,
+		LoanDetails:   dbEntry.LoanDetails,
+	}
+}
+
+func dbEntryToHistoryResp(dbEntry *sharedmodels.ApplicationEntry) models.ApplicationHistoryResponse {
+	// Purposefully init to empty so that clients don't get 'nil' in JSON response
+	history := []models.StatusChangeView{}
+	for _, change := range dbEntry.History {
+		history = append(history, models.StatusChangeView{
+			Status: change.Status,
+			Source: change.Source,
+			Reason: change.Reason,
+			At:     change.At,
+		})
+	}
+
+	return models.ApplicationHistoryResponse{
+		ApplicationID:     dbEntry.ID.Hex(),
+		BankApplicationID: dbEntry.BankApplicationID,
+		Status:            dbEntry.Status,
+		FirstName:         dbEntry.FirstName,
+		LastName:          dbEntry.LastName,
+		CreatedAt:         dbEntry.CreatedAt,
+		SubmittedAt:       dbEntry.SubmittedAt,
+		DecidedAt:         dbEntry.DecidedAt,
+		History:           history,
+		LoanDetails:       dbEntry.LoanDetails,
 	}
 }
 
@@ -210,3 +816,11 @@ func newNotFoundError(ctx *gin.Context, status int, err error) {
 	}
 	ctx.JSON(status, er)
 }
+
+func newConflictError(ctx *gin.Context, status int, err error) {
+	er := HTTPConflictError{
+		Code:    status,
+		Message: err.Error(),
+	}
+	ctx.JSON(status, er)
+}
@@ -4,11 +4,14 @@ import (
 	mocks "api-gateway/mocks/repositorys"
 	"api-gateway/models"
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/assert/v2"
+	"github.com/go-playground/validator/v10"
 	assert2 "github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -20,6 +23,8 @@ import (
 	sharedmodels "service-shared/shared-models"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 const (
@@ -28,6 +33,10 @@ const (
 )
 
 func SetUpRouter() *gin.Engine {
+	// Register the custom validators, as main does
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		sharedmodels.RegisterValidations(v)
+	}
 	router := gin.Default()
 	return router
 }
@@ -35,10 +44,9 @@ func SetUpRouter() *gin.Engine {
 func TestGetApplicationRequiresApplicationID(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 
 	// Setup router
 	router := SetUpRouter()
@@ -59,12 +67,11 @@ func TestGetApplicationRequiresApplicationID(t *testing.T) {
 func TestGetApplicationDbInternalError(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
-	repository.On("GetApplication", applicationID).Return(nil, database.InternalError)
+	repository.On("GetApplication", mock.Anything, applicationID).Return(nil, database.InternalError)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/application", controller.GetApplication)
@@ -79,12 +86,11 @@ func TestGetApplicationDbInternalError(t *testing.T) {
 func TestGetApplicationDoesNotExist(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
-	repository.On("GetApplication", applicationID).Return(nil, errors.New(""))
+	repository.On("GetApplication", mock.Anything, applicationID).Return(nil, errors.New(""))
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/application", controller.GetApplication)
@@ -99,7 +105,6 @@ func TestGetApplicationDoesNotExist(t *testing.T) {
 func TestGetApplicationExists(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
 	dbEntry := &sharedmodels.ApplicationEntry{
 		ID:        primitive.ObjectID{},
@@ -108,10 +113,10 @@ func TestGetApplicationExists(t *testing.T) {
 		LastName:  "Last",
 	}
 
-	repository.On("GetApplication", applicationID).Return(dbEntry, nil)
+	repository.On("GetApplication", mock.Anything, applicationID).Return(dbEntry, nil)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/application", controller.GetApplication)
@@ -130,13 +135,180 @@ func TestGetApplicationExists(t *testing.T) {
 	assert.Equal(t, expectedClientView, actualClientView)
 }
 
+func TestGetApplicationHistoryDoesNotExist(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("GetApplication", mock.Anything, applicationID).Return(nil, errors.New(""))
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/application/:application_id/history", controller.GetApplicationHistory)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/application/%s/history", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
+func TestGetApplicationHistoryExists(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	createdAt := time.Date(2022, 7, 13, 10, 0, 0, 0, time.UTC)
+	decidedAt := createdAt.Add(time.Minute)
+	dbEntry := &sharedmodels.ApplicationEntry{
+		ID:                primitive.ObjectID{},
+		Status:            sharedmodels.Completed,
+		FirstName:         "First",
+		LastName:          "Last",
+		BankApplicationID: "bankID",
+		CreatedAt:         &createdAt,
+		SubmittedAt:       &createdAt,
+		DecidedAt:         &decidedAt,
+		LoanDetails:       getLoanDetails(),
+		History: []sharedmodels.StatusChange{
+			{Status: sharedmodels.Queued, Source: sharedmodels.APIGatewayService, At: createdAt},
+			{Status: sharedmodels.Completed, Source: sharedmodels.PollApplicationService, Reason: "Decided", At: decidedAt},
+		},
+	}
+
+	repository.On("GetApplication", mock.Anything, applicationID).Return(dbEntry, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router, with the route for getting an application to check that the routes do not conflict
+	router := SetUpRouter()
+	router.GET("/api/application", controller.GetApplication)
+	router.GET("/api/application/:application_id/history", controller.GetApplicationHistory)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/application/%s/history", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	var actualHistory models.ApplicationHistoryResponse
+	json.Unmarshal(responseData, &actualHistory)
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, dbEntryToHistoryResp(dbEntry), actualHistory)
+	assert.Equal(t, "bankID", actualHistory.BankApplicationID)
+	assert.Equal(t, getLoanDetails(), actualHistory.LoanDetails)
+	assert.Equal(t, 2, len(actualHistory.History))
+	assert.Equal(t, sharedmodels.PollApplicationService, actualHistory.History[1].Source)
+}
+
+func TestWithdrawApplication(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	eventQueue := new(mocks.EventQueue)
+	objID := primitive.NewObjectID()
+	repository.On("UpdateApplicationStatus", mock.Anything, objID.Hex(), mock.Anything).Return(nil)
+	repository.On("GetApplication", mock.Anything, objID.Hex()).Return(&sharedmodels.ApplicationEntry{
+		ID: objID, Status: sharedmodels.Withdrawn, FirstName: "First", LastName: "Last",
+	}, nil)
+	eventQueue.On("PublishStatusChanged", mock.Anything, mock.Anything).Return(nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, eventQueue)
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application/:application_id/cancel", controller.WithdrawApplication)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/application/%s/cancel", objID.Hex()), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	var response models.ClientApplicationView
+	json.Unmarshal(respRecorder.Body.Bytes(), &response)
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, sharedmodels.Withdrawn, response.Status)
+	repository.AssertCalled(t, "UpdateApplicationStatus", mock.Anything, objID.Hex(), mock.MatchedBy(func(change sharedmodels.StatusChange) bool {
+		return change.Status == sharedmodels.Withdrawn && change.Source == sharedmodels.APIGatewayService
+	}))
+	eventQueue.AssertCalled(t, "PublishStatusChanged", mock.Anything, mock.MatchedBy(func(event sharedmodels.StatusChangedEvent) bool {
+		return event.ApplicationID == objID.Hex() && event.Status == sharedmodels.Withdrawn
+	}))
+}
+
+func TestWithdrawApplicationAlreadyWithdrawn(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	eventQueue := new(mocks.EventQueue)
+	repository.On("UpdateApplicationStatus", mock.Anything, applicationID, mock.Anything).Return(
+		&sharedmodels.TransitionError{ApplicationID: applicationID, From: sharedmodels.Withdrawn, To: sharedmodels.Withdrawn})
+	repository.On("GetApplication", mock.Anything, applicationID).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Withdrawn}, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, eventQueue)
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application/:application_id/cancel", controller.WithdrawApplication)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/application/%s/cancel", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	// A retried request succeeds, without publishing the change again
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	eventQueue.AssertNotCalled(t, "PublishStatusChanged", mock.Anything, mock.Anything)
+}
+
+func TestWithdrawApplicationAlreadyDecided(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	eventQueue := new(mocks.EventQueue)
+	repository.On("UpdateApplicationStatus", mock.Anything, applicationID, mock.Anything).Return(
+		&sharedmodels.TransitionError{ApplicationID: applicationID, From: sharedmodels.Completed, To: sharedmodels.Withdrawn})
+
+	// Create real controller
+	controller := NewLoanAppController(repository, eventQueue)
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application/:application_id/cancel", controller.WithdrawApplication)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/application/%s/cancel", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusConflict, respRecorder.Code)
+	eventQueue.AssertNotCalled(t, "PublishStatusChanged", mock.Anything, mock.Anything)
+}
+
+func TestWithdrawApplicationErrors(t *testing.T) {
+	errorCodes := map[error]int{
+		errors.New("The application_id TestID does not exist"): http.StatusNotFound,
+		database.InternalError:                                 http.StatusInternalServerError,
+	}
+
+	for err, code := range errorCodes {
+		// Create mocks
+		repository := new(sharedmocks.Repository)
+		repository.On("UpdateApplicationStatus", mock.Anything, applicationID, mock.Anything).Return(err)
+
+		// Create real controller
+		controller := NewLoanAppController(repository, new(mocks.EventQueue))
+		// Setup router
+		router := SetUpRouter()
+		router.POST("/api/application/:application_id/cancel", controller.WithdrawApplication)
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/application/%s/cancel", applicationID), nil)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(t, code, respRecorder.Code)
+	}
+}
+
 func TestGetApplicationWithStatusRequiresStatus(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
@@ -151,10 +323,9 @@ func TestGetApplicationWithStatusRequiresStatus(t *testing.T) {
 func TestGetApplicationWithStatusInvalidStatus(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
@@ -170,12 +341,11 @@ func TestGetApplicationWithStatusInternalDbError(t *testing.T) {
 	status := sharedmodels.Pending
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
-	repository.On("GetApplicationsWithStatus", status).Return(nil, database.InternalError)
+	repository.On("GetApplicationsWithStatus", mock.Anything, status, mock.Anything).Return(nil, "", database.InternalError)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
@@ -191,7 +361,6 @@ func TestGetApplicationWithStatusSuccess(t *testing.T) {
 	status := sharedmodels.Pending
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
 	var entries []sharedmodels.ApplicationEntry
 	dbEntry := sharedmodels.ApplicationEntry{
@@ -202,10 +371,10 @@ func TestGetApplicationWithStatusSuccess(t *testing.T) {
 	}
 	entries = append(entries, dbEntry)
 
-	repository.On("GetApplicationsWithStatus", status).Return(entries, nil)
+	repository.On("GetApplicationsWithStatus", mock.Anything, status, getDefaultPageRequest()).Return(entries, "", nil)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
@@ -225,78 +394,377 @@ func TestGetApplicationWithStatusSuccess(t *testing.T) {
 
 }
 
-func TestCreateApplicationInvalidBodyJSON(t *testing.T) {
+func TestGetApplicationWithStatusPage(t *testing.T) {
+	status := sharedmodels.Completed
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
+
+	entries := []sharedmodels.ApplicationEntry{{ID: primitive.NewObjectID(), Status: status, FirstName: "First", LastName: "Last"}}
+	page := sharedmodels.PageRequest{Limit: 1, PageToken: "token", Sort: sharedmodels.Newest}
+	repository.On("GetApplicationsWithStatus", mock.Anything, status, page).Return(entries, "next", nil)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
-	router.POST("/api/application", controller.CreateApplication)
+	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
 
-	requestBody := "{invalidjson,"
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/applications-with-status?status=%s&limit=1&page_token=token&sort=desc", status), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
 
-	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer([]byte(requestBody)))
+	var actualClientView models.GetAppsWithStatusResponse
+	json.Unmarshal(responseData, &actualClientView)
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, 1, len(actualClientView.ApplicationsWithStatus))
+	assert.Equal(t, "next", actualClientView.NextPageToken)
+}
+
+func TestGetApplicationWithStatusInvalidPageParameters(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
+
+	for _, query := range []string{"limit=0", "limit=501", "limit=ten", "sort=name"} {
+		req, _ := http.NewRequest("GET", "/api/applications-with-status?status=completed&"+query, nil)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+	}
+	repository.AssertNotCalled(t, "GetApplicationsWithStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetApplicationWithStatusInvalidPageToken(t *testing.T) {
+	status := sharedmodels.Completed
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("GetApplicationsWithStatus", mock.Anything, status, mock.Anything).Return(nil, "", database.ErrInvalidPageToken)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/applications-with-status?status=%s&page_token=bad", status), nil)
 	respRecorder := httptest.NewRecorder()
 	router.ServeHTTP(respRecorder, req)
 
 	assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
 }
 
-func TestCreateApplicationInternalDbError(t *testing.T) {
+func TestSearchApplicationsSuccess(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
-	repository.On("CreateApplication", mock.Anything, mock.Anything).Return("", database.InternalError)
+	from := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	filter := sharedmodels.ApplicationFilter{
+		LastName:        "Last",
+		FirstNamePrefix: "Fi",
+		CreatedFrom:     &from,
+		CreatedTo:       &to,
+		Statuses:        []sharedmodels.Status{sharedmodels.Completed, sharedmodels.Rejected, sharedmodels.TimedOut},
+	}
+	entries := []sharedmodels.ApplicationEntry{{ID: primitive.NewObjectID(), Status: sharedmodels.Completed, FirstName: "First", LastName: "Last",
+		CallbackURL: "https://example.com/callback"}}
+	repository.On("SearchApplications", mock.Anything, filter, getDefaultPageRequest()).Return(entries, "next", nil)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
-	router.POST("/api/application", controller.CreateApplication)
+	router.GET("/api/applications", controller.SearchApplications)
 
-	validRequest := &models.CreateApplicationRequest{
-		FirstName: "First",
-		LastName:  "Last",
+	query := "last_name=Last&first_name_prefix=Fi&created_from=2022-07-01T00:00:00Z&created_to=2022-08-01T02:00:00%2B02:00" +
+		"&status=completed,rejected&status=TIMED_OUT"
+	req, _ := http.NewRequest("GET", "/api/applications?"+query, nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	var actualResponse models.SearchApplicationsResponse
+	json.Unmarshal(responseData, &actualResponse)
+
+	expectedResponse := models.SearchApplicationsResponse{Applications: dbEntryToClientResp(entries), NextPageToken: "next"}
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, expectedResponse, actualResponse)
+	assert.Equal(t, "https://example.com/callback", actualResponse.Applications[0].CallbackURL)
+}
+
+func TestSearchApplicationsInvalidFilters(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications", controller.SearchApplications)
+
+	for _, query := range []string{
+		"",
+		"status=completed,unknown",
+		"created_from=yesterday",
+		"created_from=2022-08-01T00:00:00Z&created_to=2022-07-01T00:00:00Z",
+		"last_name=" + strings.Repeat("a", maxNameFilterLength+1),
+		"last_name=Last&limit=0",
+	} {
+		req, _ := http.NewRequest("GET", "/api/applications?"+query, nil)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
 	}
-	jsonReqBody, _ := json.Marshal(validRequest)
+	repository.AssertNotCalled(t, "SearchApplications", mock.Anything, mock.Anything, mock.Anything)
+}
 
-	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
+func TestSearchApplicationsInternalDbError(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("SearchApplications", mock.Anything, mock.Anything, mock.Anything).Return(nil, "", database.InternalError)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications", controller.SearchApplications)
+
+	req, _ := http.NewRequest("GET", "/api/applications?last_name=Last", nil)
 	respRecorder := httptest.NewRecorder()
 	router.ServeHTTP(respRecorder, req)
 
 	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
 }
 
-func TestCreateApplicationQueueError(t *testing.T) {
+func TestExportApplicationsCSV(t *testing.T) {
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
-	repository.On("CreateApplication", mock.Anything, mock.Anything).Return(dbID, nil)
-	repository.On("RemoveApplication", mock.Anything).Return(nil)
-	messageQueue.On("PublishLoanRequest", mock.Anything).Return(errors.New(""))
+	entries := getExportEntries()
+	from := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	filter := sharedmodels.ApplicationFilter{CreatedFrom: &from, Statuses: []sharedmodels.Status{sharedmodels.Completed, sharedmodels.Rejected}}
+	repository.On("ExportApplications", mock.Anything, filter, mock.Anything).Run(streamEntries(entries)).Return(nil)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
-	router.POST("/api/application", controller.CreateApplication)
+	router.GET("/api/applications/export", controller.ExportApplications)
 
-	validRequest := &models.CreateApplicationRequest{
-		FirstName: "First",
-		LastName:  "Last",
+	req, _ := http.NewRequest("GET", "/api/applications/export?format=csv&status=completed,rejected&from=2022-07-01T00:00:00Z", nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	expected := "application_id,status,first_name,last_name\n" +
+		fmt.Sprintf("%s,completed,First,Last\n", entries[0].ID.Hex()) +
+		fmt.Sprintf("%s,rejected,\"Last, First\",Name\n", entries[1].ID.Hex())
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, "text/csv", respRecorder.Header().Get("Content-Type"))
+	assert.Equal(t, expected, respRecorder.Body.String())
+}
+
+func TestExportApplicationsCSVEscapesFormulas(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	names := []string{"=HYPERLINK(\"https://example.com\")", "+1", "-1", "@SUM(A1)", "\tTab", "\rReturn",
+		"  =HYPERLINK(\"https://example.com\")", " \n@SUM(A1)", "O'Brien", " Smith"}
+	var entries []sharedmodels.ApplicationEntry
+	for _, name := range names {
+		entries = append(entries, sharedmodels.ApplicationEntry{ID: primitive.NewObjectID(), Status: sharedmodels.Queued, FirstName: name, LastName: "Last"})
+	}
+	repository.On("ExportApplications", mock.Anything, mock.Anything, mock.Anything).Run(streamEntries(entries)).Return(nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/export", controller.ExportApplications)
+
+	req, _ := http.NewRequest("GET", "/api/applications/export?format=csv", nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	records, err := csv.NewReader(respRecorder.Body).ReadAll()
+	assert2.Nil(t, err)
+	assert2.Len(t, records, len(names)+1)
+	for i, name := range names[:8] {
+		assert.Equal(t, "'"+name, records[i+1][2])
 	}
-	jsonReqBody, _ := json.Marshal(validRequest)
+	// A name which cannot start a formula is unchanged, even after leading spaces
+	assert.Equal(t, "O'Brien", records[9][2])
+	assert.Equal(t, " Smith", records[10][2])
+}
+
+func TestExportApplicationsNDJSON(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	entries := getExportEntries()
+	repository.On("ExportApplications", mock.Anything, sharedmodels.ApplicationFilter{}, mock.Anything).Run(streamEntries(entries)).Return(nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/export", controller.ExportApplications)
+
+	req, _ := http.NewRequest("GET", "/api/applications/export?format=ndjson", nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	lines := strings.Split(strings.TrimSuffix(respRecorder.Body.String(), "\n"), "\n")
+	var actual []models.ClientApplicationView
+	for _, line := range lines {
+		var view models.ClientApplicationView
+		json.Unmarshal([]byte(line), &view)
+		actual = append(actual, view)
+	}
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, "application/x-ndjson", respRecorder.Header().Get("Content-Type"))
+	assert.Equal(t, dbEntryToClientResp(entries), actual)
+}
+
+func TestExportApplicationsEmptyCSV(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("ExportApplications", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/export", controller.ExportApplications)
+
+	req, _ := http.NewRequest("GET", "/api/applications/export?format=csv", nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, "application_id,status,first_name,last_name\n", respRecorder.Body.String())
+}
+
+func TestExportApplicationsInvalidParameters(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/export", controller.ExportApplications)
+
+	for _, query := range []string{
+		"",
+		"format=xml",
+		"format=csv&status=unknown",
+		"format=csv&from=2022-08-01T00:00:00Z&to=2022-07-01T00:00:00Z",
+	} {
+		req, _ := http.NewRequest("GET", "/api/applications/export?"+query, nil)
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+	}
+	repository.AssertNotCalled(t, "ExportApplications", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestExportApplicationsInternalDbError(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("ExportApplications", mock.Anything, mock.Anything, mock.Anything).Return(database.InternalError)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/export", controller.ExportApplications)
+
+	req, _ := http.NewRequest("GET", "/api/applications/export?format=csv", nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
+}
+
+func TestExportApplicationsErrorAfterStarting(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	entries := getExportEntries()
+	repository.On("ExportApplications", mock.Anything, mock.Anything, mock.Anything).Run(streamEntries(entries[:1])).Return(database.InternalError)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/export", controller.ExportApplications)
+
+	req, _ := http.NewRequest("GET", "/api/applications/export?format=ndjson", nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	firstRow, _ := json.Marshal(dbEntryToClientView(&entries[0]))
+
+	// The status has already been sent, so the export is cut short rather than replaced with an error
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, string(firstRow)+"\n", respRecorder.Body.String())
+}
+
+func TestCreateApplicationInvalidBodyJSON(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	requestBody := "{invalidjson,"
+
+	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer([]byte(requestBody)))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+}
+
+func TestCreateApplicationInternalDbError(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("CreateApplication", mock.Anything, mock.Anything, mock.Anything).Return("", database.InternalError)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	validRequest := getCreateApplicationRequest("First", "Last")
+	jsonReqBody, _ := json.Marshal(&validRequest)
 
 	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
 	respRecorder := httptest.NewRecorder()
 	router.ServeHTTP(respRecorder, req)
 
-	repository.AssertCalled(t, "RemoveApplication", dbID)
 	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
 }
 
@@ -307,22 +775,17 @@ func TestCreateApplication(t *testing.T) {
 
 	// Create mocks
 	repository := new(sharedmocks.Repository)
-	messageQueue := new(mocks.MessageQueue)
 
-	repository.On("CreateApplication", mock.Anything, mock.Anything).Return(dbID, nil)
-	messageQueue.On("PublishLoanRequest", mock.Anything).Return(nil)
+	repository.On("CreateApplication", mock.Anything, mock.Anything, mock.Anything).Return(dbID, nil)
 
 	// Create real controller
-	controller := NewLoanAppController(repository, messageQueue)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
 	// Setup router
 	router := SetUpRouter()
 	router.POST("/api/application", controller.CreateApplication)
 
-	validRequest := &models.CreateApplicationRequest{
-		FirstName: firstName,
-		LastName:  lastName,
-	}
-	jsonReqBody, _ := json.Marshal(validRequest)
+	validRequest := getCreateApplicationRequest(firstName, lastName)
+	jsonReqBody, _ := json.Marshal(&validRequest)
 
 	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
 	respRecorder := httptest.NewRecorder()
@@ -334,11 +797,511 @@ func TestCreateApplication(t *testing.T) {
 
 	expectedClientView := models.ClientApplicationView{
 		ApplicationID: dbID,
-		Status:        sharedmodels.Pending,
+		Status:        sharedmodels.Queued,
 		FirstName:     firstName,
 		LastName:      lastName,
 	}
 
 	assert.Equal(t, http.StatusCreated, respRecorder.Code)
 	assert.Equal(t, expectedClientView, actualClientView)
+	// The loan details are passed to the repository
+	repository.AssertCalled(t, "CreateApplication", mock.Anything, validRequest.NewApplication(), mock.Anything)
+}
+
+func TestCreateApplicationWithCallbackURL(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	callbackURL := "https://example.com/loans?source=api"
+
+	repository.On("CreateApplication", mock.Anything, mock.MatchedBy(func(application sharedmodels.NewApplication) bool {
+		return application.CallbackURL == callbackURL
+	}), mock.Anything).Return(dbID, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	createRequest := getCreateApplicationRequest("First", "Last")
+	createRequest.CallbackURL = callbackURL
+	jsonReqBody, _ := json.Marshal(&createRequest)
+	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	var actualResponse models.CreateApplicationResponse
+	json.Unmarshal(responseData, &actualResponse)
+
+	assert.Equal(t, http.StatusCreated, respRecorder.Code)
+	assert.Equal(t, callbackURL, actualResponse.CallbackURL)
+	repository.AssertNumberOfCalls(t, "CreateApplication", 1)
+}
+
+func TestCreateApplicationInvalidCallbackURL(t *testing.T) {
+	invalidURLs := []string{
+		"example.com/loans",
+		"/loans",
+		"ftp://example.com/loans",
+		"https://",
+		"https://example.com/" + strings.Repeat("a", maxCallbackURLLength),
+		"http://localhost:8080/loans",
+		"http://127.0.0.1/loans",
+		"http://[::1]/loans",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/loans",
+		"http://mongo:27017/",
+		"http://bank-api.localhost/loans",
+	}
+
+	for _, callbackURL := range invalidURLs {
+		// Create mocks
+		repository := new(sharedmocks.Repository)
+
+		// Create real controller
+		controller := NewLoanAppController(repository, new(mocks.EventQueue))
+		// Setup router
+		router := SetUpRouter()
+		router.POST("/api/application", controller.CreateApplication)
+
+		createRequest := getCreateApplicationRequest("First", "Last")
+		createRequest.CallbackURL = callbackURL
+		jsonReqBody, _ := json.Marshal(&createRequest)
+		req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+		repository.AssertNotCalled(t, "CreateApplication", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestCreateApplicationInvalidLoanDetails(t *testing.T) {
+	invalidDetails := []func(details *sharedmodels.LoanDetails){
+		func(details *sharedmodels.LoanDetails) { details.LoanAmount = sharedmodels.MaxLoanAmount + 1 },
+		func(details *sharedmodels.LoanDetails) { details.Currency = "gbp" },
+		func(details *sharedmodels.LoanDetails) { details.TermMonths = 0 },
+		func(details *sharedmodels.LoanDetails) { details.Purpose = "holiday" },
+		func(details *sharedmodels.LoanDetails) { details.AnnualIncome = -1 },
+		func(details *sharedmodels.LoanDetails) { details.Email = "first.last@" },
+		func(details *sharedmodels.LoanDetails) { details.DateOfBirth = time.Now().Format("2006-01-02") },
+	}
+
+	for _, change := range invalidDetails {
+		// Create mocks
+		repository := new(sharedmocks.Repository)
+
+		// Create real controller
+		controller := NewLoanAppController(repository, new(mocks.EventQueue))
+		// Setup router
+		router := SetUpRouter()
+		router.POST("/api/application", controller.CreateApplication)
+
+		createRequest := getCreateApplicationRequest("First", "Last")
+		change(&createRequest.LoanDetails)
+		jsonReqBody, _ := json.Marshal(&createRequest)
+		req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+
+		assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+		repository.AssertNotCalled(t, "CreateApplication", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestCreateApplicationWithIdempotencyKey(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("GetIdempotencyKey", mock.Anything, "key").Return(nil, database.ErrIdempotencyKeyNotFound)
+	repository.On("CreateApplication", mock.Anything, mock.Anything, mock.Anything).Return(dbID, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	req := getCreateRequestWithIdempotencyKey("First", "Last", "key")
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusCreated, respRecorder.Code)
+	// The key must be stored along with the application
+	repository.AssertCalled(t, "CreateApplication", mock.Anything, mock.Anything, mock.MatchedBy(func(key *sharedmodels.IdempotencyKeyEntry) bool {
+		return key.Key == "key" && len(key.RequestHash) > 0
+	}))
+}
+
+func TestCreateApplicationReplaysIdempotencyKey(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	objID := primitive.NewObjectID()
+	requestHash := hashRequest(getCreateApplicationRequest("First", "Last"))
+	repository.On("GetIdempotencyKey", mock.Anything, "key").Return(&sharedmodels.IdempotencyKeyEntry{
+		Key:           "key",
+		RequestHash:   requestHash,
+		ApplicationID: objID.Hex(),
+		Status:        sharedmodels.Queued,
+	}, nil)
+	// The application has been decided since the first request
+	repository.On("GetApplication", mock.Anything, objID.Hex()).Return(&sharedmodels.ApplicationEntry{
+		ID:          objID,
+		Status:      sharedmodels.Completed,
+		FirstName:   "First",
+		LastName:    "Last",
+		LoanDetails: getLoanDetails(),
+	}, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	req := getCreateRequestWithIdempotencyKey("First", "Last", "key")
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	var actualResponse models.CreateApplicationResponse
+	json.Unmarshal(responseData, &actualResponse)
+
+	expectedResponse := models.CreateApplicationResponse{
+		ApplicationID: objID.Hex(),
+		Status:        sharedmodels.Completed,
+		FirstName:     "First",
+		LastName:      "Last",
+		LoanDetails:   getLoanDetails(),
+	}
+
+	assert.Equal(t, http.StatusCreated, respRecorder.Code)
+	assert.Equal(t, expectedResponse, actualResponse)
+	repository.AssertNotCalled(t, "CreateApplication", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationReplayInternalError(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	requestHash := hashRequest(getCreateApplicationRequest("First", "Last"))
+	repository.On("GetIdempotencyKey", mock.Anything, "key").Return(&sharedmodels.IdempotencyKeyEntry{
+		Key:           "key",
+		RequestHash:   requestHash,
+		ApplicationID: dbID,
+		Status:        sharedmodels.Queued,
+	}, nil)
+	repository.On("GetApplication", mock.Anything, dbID).Return(nil, database.InternalError)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	req := getCreateRequestWithIdempotencyKey("First", "Last", "key")
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
+	repository.AssertNotCalled(t, "CreateApplication", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationIdempotencyKeyConflict(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	repository.On("GetIdempotencyKey", mock.Anything, "key").Return(&sharedmodels.IdempotencyKeyEntry{
+		Key:           "key",
+		RequestHash:   hashRequest(getCreateApplicationRequest("Other", "Last")),
+		ApplicationID: dbID,
+		Status:        sharedmodels.Queued,
+	}, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	req := getCreateRequestWithIdempotencyKey("First", "Last", "key")
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusConflict, respRecorder.Code)
+	repository.AssertNotCalled(t, "CreateApplication", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationIdempotencyKeyRace(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	objID := primitive.NewObjectID()
+	// The key is not found at first, but a concurrent request stores it before this one
+	requestHash := hashRequest(getCreateApplicationRequest("First", "Last"))
+	repository.On("GetIdempotencyKey", mock.Anything, "key").Return(nil, database.ErrIdempotencyKeyNotFound).Once()
+	repository.On("GetIdempotencyKey", mock.Anything, "key").Return(&sharedmodels.IdempotencyKeyEntry{
+		Key:           "key",
+		RequestHash:   requestHash,
+		ApplicationID: objID.Hex(),
+		Status:        sharedmodels.Queued,
+	}, nil)
+	repository.On("CreateApplication", mock.Anything, mock.Anything, mock.Anything).Return("", database.ErrIdempotencyKeyExists)
+	repository.On("GetApplication", mock.Anything, objID.Hex()).Return(&sharedmodels.ApplicationEntry{ID: objID, Status: sharedmodels.Queued}, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	req := getCreateRequestWithIdempotencyKey("First", "Last", "key")
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusCreated, respRecorder.Code)
+	assert2.Contains(t, respRecorder.Body.String(), objID.Hex())
+}
+
+func TestCreateApplicationIdempotencyKeyTooLong(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/application", controller.CreateApplication)
+
+	req := getCreateRequestWithIdempotencyKey("First", "Last", strings.Repeat("k", 256))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+}
+
+func TestCreateApplicationsAllCreated(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	first := getCreateApplicationRequest("First", "Last")
+	second := getCreateApplicationRequest("Second", "Last")
+	second.CallbackURL = "https://example.com/loans"
+	applications := []sharedmodels.NewApplication{first.NewApplication(), second.NewApplication()}
+	repository.On("CreateApplications", mock.Anything, applications).Return([]string{"id1", "id2"}, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/applications/batch", controller.CreateApplications)
+
+	body, _ := json.Marshal([]models.CreateApplicationRequest{first, second})
+	req := getBatchRequest(string(body))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	var response models.BatchCreateApplicationsResponse
+	json.Unmarshal(respRecorder.Body.Bytes(), &response)
+	assert.Equal(t, http.StatusCreated, respRecorder.Code)
+	assert.Equal(t, models.BatchCreateApplicationsResponse{
+		Created: 2,
+		Results: []models.BatchCreateApplicationResult{
+			{Index: 0, ApplicationID: "id1", Status: sharedmodels.Queued},
+			{Index: 1, ApplicationID: "id2", Status: sharedmodels.Queued},
+		},
+	}, response)
+}
+
+func TestCreateApplicationsPartialFailure(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	second := getCreateApplicationRequest("Second", "Last")
+	third := getCreateApplicationRequest("Third", "Last")
+	third.CallbackURL = "ftp://example.com"
+	applications := []sharedmodels.NewApplication{second.NewApplication()}
+	repository.On("CreateApplications", mock.Anything, applications).Return([]string{"id1"}, nil)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/applications/batch", controller.CreateApplications)
+
+	secondBody, _ := json.Marshal(&second)
+	thirdBody, _ := json.Marshal(&third)
+	req := getBatchRequest(fmt.Sprintf(`[{"first_name":"First"},%s,%s,{"first_name":4}]`, secondBody, thirdBody))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	var response models.BatchCreateApplicationsResponse
+	json.Unmarshal(respRecorder.Body.Bytes(), &response)
+	assert.Equal(t, http.StatusMultiStatus, respRecorder.Code)
+	assert.Equal(t, 1, response.Created)
+	assert.Equal(t, 3, response.Failed)
+	assert2.Len(t, response.Results, 4)
+	for i, result := range response.Results {
+		assert.Equal(t, i, result.Index)
+	}
+	assert.NotEqual(t, "", response.Results[0].Error)
+	assert.Equal(t, "id1", response.Results[1].ApplicationID)
+	assert.Equal(t, "", response.Results[1].Error)
+	assert.NotEqual(t, "", response.Results[2].Error)
+	assert.NotEqual(t, "", response.Results[3].Error)
+}
+
+func TestCreateApplicationsNoneValid(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/applications/batch", controller.CreateApplications)
+
+	req := getBatchRequest(`[{"first_name":"First"},{"last_name":"Last"}]`)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	var response models.BatchCreateApplicationsResponse
+	json.Unmarshal(respRecorder.Body.Bytes(), &response)
+	assert.Equal(t, http.StatusUnprocessableEntity, respRecorder.Code)
+	assert.Equal(t, 2, response.Failed)
+	repository.AssertNotCalled(t, "CreateApplications", mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationsInvalidBatch(t *testing.T) {
+	invalidBodies := []string{
+		`{"first_name":"First","last_name":"Last"}`,
+		`[]`,
+		`[{"first_name":"First","last_name":"Last"}`,
+		"[" + strings.Repeat(`{"first_name":"First","last_name":"Last"},`, maxBatchSize) + `{"first_name":"First","last_name":"Last"}]`,
+	}
+
+	for _, body := range invalidBodies {
+		// Create mocks
+		repository := new(sharedmocks.Repository)
+
+		// Create real controller
+		controller := NewLoanAppController(repository, new(mocks.EventQueue))
+		// Setup router
+		router := SetUpRouter()
+		router.POST("/api/applications/batch", controller.CreateApplications)
+
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, getBatchRequest(body))
+
+		assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+		repository.AssertNotCalled(t, "CreateApplications", mock.Anything, mock.Anything)
+	}
+}
+
+func TestCreateApplicationsBodyTooLarge(t *testing.T) {
+	body := `[{"first_name":"` + strings.Repeat("a", maxBatchBodyBytes) + `","last_name":"Last"}]`
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/applications/batch", controller.CreateApplications)
+
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, getBatchRequest(body))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, respRecorder.Code)
+	repository.AssertNotCalled(t, "CreateApplications", mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationsBodyReadError(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/applications/batch", controller.CreateApplications)
+
+	// For example, the client disconnected while sending the body
+	req, _ := http.NewRequest("POST", "/api/applications/batch", iotest.ErrReader(errors.New("connection reset by peer")))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+	repository.AssertNotCalled(t, "CreateApplications", mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationsInternalDbError(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	repository.On("CreateApplications", mock.Anything, mock.Anything).Return(nil, database.InternalError)
+
+	// Create real controller
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+	// Setup router
+	router := SetUpRouter()
+	router.POST("/api/applications/batch", controller.CreateApplications)
+
+	body, _ := json.Marshal([]models.CreateApplicationRequest{getCreateApplicationRequest("First", "Last")})
+	req := getBatchRequest(string(body))
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusInternalServerError, respRecorder.Code)
+}
+
+func getBatchRequest(body string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/applications/batch", strings.NewReader(body))
+	return req
+}
+
+func getCreateRequestWithIdempotencyKey(firstName, lastName, key string) *http.Request {
+	createRequest := getCreateApplicationRequest(firstName, lastName)
+	jsonReqBody, _ := json.Marshal(&createRequest)
+	req, _ := http.NewRequest("POST", "/api/application", bytes.NewBuffer(jsonReqBody))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	return req
+}
+
+func getDefaultPageRequest() sharedmodels.PageRequest {
+	return sharedmodels.PageRequest{Limit: defaultPageLimit, Sort: sharedmodels.Oldest}
+}
+
+func getExportEntries() []sharedmodels.ApplicationEntry {
+	return []sharedmodels.ApplicationEntry{
+		{ID: primitive.NewObjectID(), Status: sharedmodels.Completed, FirstName: "First", LastName: "Last"},
+		{ID: primitive.NewObjectID(), Status: sharedmodels.Rejected, FirstName: "Last, First", LastName: "Name"},
+	}
+}
+
+// streamEntries makes a mock of ExportApplications call its fn with each of entries
+func streamEntries(entries []sharedmodels.ApplicationEntry) func(args mock.Arguments) {
+	return func(args mock.Arguments) {
+		fn := args.Get(2).(func(entry sharedmodels.ApplicationEntry) error)
+		for _, entry := range entries {
+			if fn(entry) != nil {
+				return
+			}
+		}
+	}
+}
+
+func getCreateApplicationRequest(firstName, lastName string) models.CreateApplicationRequest {
+	return models.CreateApplicationRequest{FirstName: firstName, LastName: lastName, LoanDetails: getLoanDetails()}
+}
+
+func getLoanDetails() sharedmodels.LoanDetails {
+	return sharedmodels.LoanDetails{
+		LoanAmount:   1500000,
+		Currency:     "GBP",
+		TermMonths:   36,
+		Purpose:      sharedmodels.Car,
+		AnnualIncome: 4200000,
+		Email:        "first.last@example.com",
+		DateOfBirth:  "1990-01-31",
+	}
 }
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"api-gateway/models"
+	"api-gateway/repositorys"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"service-shared/database"
+	sharedmodels "service-shared/shared-models"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKeepAliveInterval = 15 * time.Second
+	eventWriteTimeout        = 10 * time.Second
+	maxFeedApplications      = 100
+)
+
+/*
+StatusEventsController pushes changes to the status of applications to clients, so that they do not
+have to poll GET /api/application. Events are received from the poll service by a repositorys.StatusBroker.
+*/
+type StatusEventsController struct {
+	repository database.Repository
+	broker     *repositorys.StatusBroker
+	upgrader   websocket.Upgrader
+	// keepAlive is how often an idle stream is written to, so that proxies do not close it
+	keepAlive time.Duration
+}
+
+//NewStatusEventsController returns a StatusEventsController
+func NewStatusEventsController(repository database.Repository, broker *repositorys.StatusBroker) *StatusEventsController {
+	return &StatusEventsController{repository: repository, broker: broker, keepAlive: defaultKeepAliveInterval}
+}
+
+//ApplicationEvents godoc
+//@Summary Streams status changes of a loan application
+//@Tags applications
+//@Description Streams the status of a loan application as Server-Sent Events. Each event is named "status", and its data
+//@Description is a models.StatusEventView. The first event is the current status, and the stream ends once the
+//@Description application is decided, times out or is withdrawn. A comment is sent every 15 seconds to keep an idle stream open.
+//@Produce text/event-stream
+//@Param application_id path string true "Loan Application ID"
+//@Success 200 {object} models.StatusEventView "Status events"
+//@Failure 404 {object} HTTPNotFoundError "When an application ID is not found"
+//@Failure 500 {object} HTTPInternalServerError "When an internal server error occurs"
+//@Router /api/application/{application_id}/events [get]
+func (controller StatusEventsController) ApplicationEvents(ginCtx *gin.Context) {
+	applicationID := ginCtx.Param("application_id")
+
+	// Subscribe before reading the application, so that a decision made in between is not missed
+	subscription := controller.broker.Subscribe(applicationID)
+	defer subscription.Close()
+
+	entry, err := controller.repository.GetApplication(ginCtx.Request.Context(), applicationID)
+	if err != nil {
+		if errors.Is(err, database.InternalError) {
+			newInternalError(ginCtx, http.StatusInternalServerError, err)
+			return
+		}
+
+		newNotFoundError(ginCtx, http.StatusNotFound, err)
+		return
+	}
+
+	ginCtx.Header("Content-Type", "text/event-stream")
+	ginCtx.Header("Cache-Control", "no-cache")
+	// Stop proxies such as nginx from buffering the stream
+	ginCtx.Header("X-Accel-Buffering", "no")
+	ginCtx.Status(http.StatusOK)
+
+	current := dbEntryToStatusEventView(entry)
+	if writeServerSentEvent(ginCtx, current) != nil || current.Status.IsTerminal() {
+		return
+	}
+
+	keepAlive := time.NewTicker(controller.keepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ginCtx.Request.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				// The gateway is shutting down, or the client fell too far behind
+				return
+			}
+			view := statusEventToView(event)
+			if writeServerSentEvent(ginCtx, view) != nil || view.Status.IsTerminal() {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(ginCtx.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			ginCtx.Writer.Flush()
+		}
+	}
+}
+
+//StatusEventsFeed godoc
+//@Summary Streams status changes of many loan applications
+//@Tags applications
+//@Description Upgrades to a WebSocket, on which a models.StatusEventView is sent as a JSON text message whenever one of
+//@Description the applications is decided, times out or is withdrawn. Without any application IDs, events for every application are sent.
+//@Description Only changes made after connecting are sent, so read the current status of the applications once connected.
+//@Description Anything the client sends is ignored. The connection is pinged every 15 seconds.
+//@Param application_id query []string false "Loan Application IDs, of which there may be up to 100" collectionFormat(multi)
+//@Success 101 {object} models.StatusEventView "Switching to the WebSocket protocol"
+//@Failure 400 {object} HTTPBadRequestError "When the request is not a WebSocket handshake, or there are too many application IDs"
+//@Router /api/applications/events [get]
+func (controller StatusEventsController) StatusEventsFeed(ginCtx *gin.Context) {
+	var applicationIDs []string
+	for _, ids := range ginCtx.QueryArray("application_id") {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); len(id) > 0 {
+				applicationIDs = append(applicationIDs, id)
+			}
+		}
+	}
+	if len(applicationIDs) > maxFeedApplications {
+		newBadRequest(ginCtx, http.StatusBadRequest, errors.New(fmt.Sprintf("At most %d application IDs may be given", maxFeedApplications)))
+		return
+	}
+
+	// Subscribe before completing the handshake, so that every change made once the client is connected is sent
+	subscription := controller.broker.Subscribe(applicationIDs...)
+	defer subscription.Close()
+
+	// The upgrader responds with an error itself if the handshake fails
+	conn, err := controller.upgrader.Upgrade(ginCtx.Writer, ginCtx.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Read in the background, so that pongs, and the client closing the connection, are handled
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(2 * controller.keepAlive))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * controller.keepAlive))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(controller.keepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+					time.Now().Add(eventWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := conn.WriteJSON(statusEventToView(event)); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//writeServerSentEvent writes view as a "status" event, and flushes it to the client
+func writeServerSentEvent(ginCtx *gin.Context, view models.StatusEventView) error {
+	data, _ := json.Marshal(view)
+	if _, err := fmt.Fprintf(ginCtx.Writer, "event: status\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+
+	ginCtx.Writer.Flush()
+	return nil
+}
+
+func statusEventToView(event sharedmodels.StatusChangedEvent) models.StatusEventView {
+	return models.StatusEventView{
+		ApplicationID: event.ApplicationID,
+		Status:        event.Status,
+		Reason:        event.Reason,
+		At:            event.At,
+	}
+}
+
+//dbEntryToStatusEventView returns the current status of an application, as of its latest status change
+func dbEntryToStatusEventView(dbEntry *sharedmodels.ApplicationEntry) models.StatusEventView {
+	view := models.StatusEventView{ApplicationID: dbEntry.ID.Hex(), Status: dbEntry.Status}
+	if len(dbEntry.History) > 0 {
+		latest := dbEntry.History[len(dbEntry.History)-1]
+		view.Reason = latest.Reason
+		view.At = latest.At
+	} else if dbEntry.CreatedAt != nil {
+		view.At = *dbEntry.CreatedAt
+	}
+
+	return view
+}
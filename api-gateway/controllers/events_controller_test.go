@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"api-gateway/models"
+	"api-gateway/repositorys"
+	"errors"
+	"fmt"
+	"github.com/go-playground/assert/v2"
+	"github.com/gorilla/websocket"
+	assert2 "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	sharedmocks "service-shared/mocks/database"
+	sharedmodels "service-shared/shared-models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplicationEventsDoesNotExist(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	repository.On("GetApplication", mock.Anything, applicationID).Return(nil, errors.New(""))
+
+	// Create real controller
+	controller := NewStatusEventsController(repository, repositorys.NewStatusBroker())
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/application/:application_id/events", controller.ApplicationEvents)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/application/%s/events", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	assert.Equal(t, http.StatusNotFound, respRecorder.Code)
+}
+
+func TestApplicationEventsAlreadyDecided(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	decidedAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	dbEntry := &sharedmodels.ApplicationEntry{
+		ID:     primitive.NewObjectID(),
+		Status: sharedmodels.Rejected,
+		History: []sharedmodels.StatusChange{
+			{Status: sharedmodels.Rejected, Source: "poll", Reason: "Credit check failed", At: decidedAt},
+		},
+	}
+	repository.On("GetApplication", mock.Anything, applicationID).Return(dbEntry, nil)
+
+	// Create real controller
+	controller := NewStatusEventsController(repository, repositorys.NewStatusBroker())
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/application/:application_id/events", controller.ApplicationEvents)
+
+	// The stream ends straight away, as the application is already decided
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/application/%s/events", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	expectedResponse := fmt.Sprintf("event: status\ndata: {\"application_id\":\"%s\",\"status\":\"rejected\","+
+		"\"reason\":\"Credit check failed\",\"at\":\"2022-01-02T03:04:05Z\"}\n\n", dbEntry.ID.Hex())
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, "text/event-stream", respRecorder.Header().Get("Content-Type"))
+	assert.Equal(t, expectedResponse, string(responseData))
+}
+
+func TestApplicationEventsStreamsUntilDecided(t *testing.T) {
+	// Create mocks
+	repository := new(sharedmocks.Repository)
+	broker := repositorys.NewStatusBroker()
+	dbEntry := &sharedmodels.ApplicationEntry{ID: primitive.NewObjectID(), Status: sharedmodels.Polling}
+	event := sharedmodels.StatusChangedEvent{ApplicationID: applicationID, Status: sharedmodels.Completed}
+	// The application is decided after the controller subscribes, but before it sends the current status
+	repository.On("GetApplication", mock.Anything, applicationID).Return(dbEntry, nil).
+		Run(func(args mock.Arguments) {
+			broker.Publish(sharedmodels.StatusChangedEvent{ApplicationID: "other", Status: sharedmodels.Rejected})
+			broker.Publish(event)
+		})
+
+	// Create real controller
+	controller := NewStatusEventsController(repository, broker)
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/application/:application_id/events", controller.ApplicationEvents)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/application/%s/events", applicationID), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	events := strings.Split(strings.TrimSpace(string(responseData)), "\n\n")
+
+	assert.Equal(t, http.StatusOK, respRecorder.Code)
+	assert.Equal(t, 2, len(events))
+	assert2.Contains(t, events[0], "\"status\":\"polling\"")
+	assert2.Contains(t, events[1], fmt.Sprintf("{\"application_id\":\"%s\",\"status\":\"completed\"", applicationID))
+}
+
+func TestStatusEventsFeedTooManyApplications(t *testing.T) {
+	// Create real controller
+	controller := NewStatusEventsController(new(sharedmocks.Repository), repositorys.NewStatusBroker())
+	// Setup router
+	router := SetUpRouter()
+	router.GET("/api/applications/events", controller.StatusEventsFeed)
+
+	ids := make([]string, maxFeedApplications+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id%d", i)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/applications/events?application_id="+strings.Join(ids, ","), nil)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	responseData, _ := ioutil.ReadAll(respRecorder.Body)
+
+	assert.Equal(t, http.StatusBadRequest, respRecorder.Code)
+	assert2.Contains(t, string(responseData), "At most 100 application IDs may be given")
+}
+
+func TestStatusEventsFeed(t *testing.T) {
+	broker := repositorys.NewStatusBroker()
+	server := getStatusEventsServer(broker)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(
+		getWebSocketURL(server, "/api/applications/events?application_id=abc,def&application_id=ghi"), nil)
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	// The subscription is made before the handshake completes, so these are not missed
+	broker.Publish(sharedmodels.StatusChangedEvent{ApplicationID: "other", Status: sharedmodels.Completed})
+	broker.Publish(sharedmodels.StatusChangedEvent{ApplicationID: "ghi", Status: sharedmodels.TimedOut, Reason: "Timed out"})
+
+	var actualView models.StatusEventView
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err = conn.ReadJSON(&actualView)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, models.StatusEventView{ApplicationID: "ghi", Status: sharedmodels.TimedOut, Reason: "Timed out"}, actualView)
+}
+
+func TestStatusEventsFeedBrokerClosed(t *testing.T) {
+	broker := repositorys.NewStatusBroker()
+	server := getStatusEventsServer(broker)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(getWebSocketURL(server, "/api/applications/events"), nil)
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	broker.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+
+	assert.Equal(t, true, websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
+
+func getStatusEventsServer(broker *repositorys.StatusBroker) *httptest.Server {
+	controller := NewStatusEventsController(new(sharedmocks.Repository), broker)
+	router := SetUpRouter()
+	router.GET("/api/applications/events", controller.StatusEventsFeed)
+	return httptest.NewServer(router)
+}
+
+func getWebSocketURL(server *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + path
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"api-gateway/models"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+const (
+	csvFormat    = "csv"
+	ndjsonFormat = "ndjson"
+)
+
+//exportWriter writes exported applications to a response, one row at a time
+type exportWriter interface {
+	//contentType returns the media type of the export
+	contentType() string
+	//start writes anything which comes before the first row
+	start() error
+	write(view models.ClientApplicationView) error
+	//flush writes any buffered rows
+	flush() error
+}
+
+//exportFormats returns a new exportWriter for each format, keyed by the value of the format parameter
+var exportFormats = map[string]func(out io.Writer) exportWriter{
+	csvFormat:    func(out io.Writer) exportWriter { return &csvExportWriter{writer: csv.NewWriter(out)} },
+	ndjsonFormat: func(out io.Writer) exportWriter { return &ndjsonExportWriter{encoder: json.NewEncoder(out)} },
+}
+
+//csvExportWriter writes a header row, followed by a row for each application
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (export *csvExportWriter) contentType() string {
+	return "text/csv"
+}
+
+func (export *csvExportWriter) start() error {
+	return export.writer.Write(models.ClientApplicationViewCSVHeader)
+}
+
+func (export *csvExportWriter) write(view models.ClientApplicationView) error {
+	return export.writer.Write(view.CSVRecord())
+}
+
+func (export *csvExportWriter) flush() error {
+	export.writer.Flush()
+	return export.writer.Error()
+}
+
+//ndjsonExportWriter writes each application as a JSON object on its own line
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (export *ndjsonExportWriter) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (export *ndjsonExportWriter) start() error {
+	return nil
+}
+
+func (export *ndjsonExportWriter) write(view models.ClientApplicationView) error {
+	return export.encoder.Encode(view)
+}
+
+func (export *ndjsonExportWriter) flush() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+)
+
+/*
+Tracing returns a middleware which records each request as a server span, named after its method and route.
+The span continues the trace in the traceparent header of the request, if it has one, and is passed on in the
+context of the request, so that the work done by the handler is part of the same trace.
+*/
+func Tracing() gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		route := ginCtx.FullPath()
+		spanName := "HTTP " + ginCtx.Request.Method
+		if len(route) > 0 {
+			spanName = ginCtx.Request.Method + " " + route
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(ginCtx.Request.Context(), propagation.HeaderCarrier(ginCtx.Request.Header))
+		ctx, span := tracing.Tracer().Start(ctx, spanName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(sharedmodels.APIGatewayService, route, ginCtx.Request)...))
+		defer span.End()
+		ginCtx.Request = ginCtx.Request.WithContext(ctx)
+
+		ginCtx.Next()
+
+		status := ginCtx.Writer.Status()
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	mocks "api-gateway/mocks/repositorys"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	sharedmocks "service-shared/mocks/database"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+)
+
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	repository := new(sharedmocks.Repository)
+	repository.On("GetApplication", mock.Anything, applicationID).Return(&sharedmodels.ApplicationEntry{}, nil)
+	controller := NewLoanAppController(repository, new(mocks.EventQueue))
+
+	router := SetUpRouter()
+	router.Use(Tracing())
+	router.GET("/api/application", controller.GetApplication)
+
+	req, _ := http.NewRequest("GET", "/api/application?application_id="+applicationID, nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "GET /api/application", spans[0].Name)
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", spans[0].Parent.SpanID().String())
+	// The handler is passed the context of the span
+	repository.AssertCalled(t, "GetApplication", mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanContextFromContext(ctx).SpanID() == spans[0].SpanContext.SpanID()
+	}), applicationID)
+}
+
+func setUpTracing() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
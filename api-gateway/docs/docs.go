@@ -18,7 +18,7 @@ const docTemplate = `{
     "paths": {
         "/api/application": {
             "post": {
-                "description": "Creates a new loan application",
+                "description": "Creates a new loan application.\nA request sent with an Idempotency-Key header can be safely retried. A retry with the same key\nand body returns the application created by the first request, rather than creating another.\nIf a callback_url is given, a signed webhook is posted to it once the application is completed or rejected.\nThe loan amount and annual income are in minor units of an ISO 4217 currency, and the date of birth is in the form YYYY-MM-DD.",
                 "consumes": [
                     "application/json"
                 ],
@@ -38,6 +38,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.CreateApplicationRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "A unique key, of up to 255 characters, identifying this request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -48,11 +54,17 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "When the request body is malformed",
+                        "description": "When the request body, loan details, callback URL or idempotency key are invalid",
                         "schema": {
                             "$ref": "#/definitions/controllers.HTTPBadRequestError"
                         }
                     },
+                    "409": {
+                        "description": "When the idempotency key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPConflictError"
+                        }
+                    },
                     "500": {
                         "description": "When an internal server error occurs",
                         "schema": {
@@ -109,9 +121,226 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/application/{application_id}/cancel": {
+            "post": {
+                "description": "Withdraws a loan application which has not been decided. An application which has not been sent to the bank\nis not sent, and an application which has been sent is no longer polled. Withdrawing an application which\nhas already been withdrawn returns it unchanged, so a request can be safely retried.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Withdraws a loan application",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Loan Application ID",
+                        "name": "application_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Application withdrawn",
+                        "schema": {
+                            "$ref": "#/definitions/models.ClientApplicationView"
+                        }
+                    },
+                    "404": {
+                        "description": "When an application ID is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPNotFoundError"
+                        }
+                    },
+                    "409": {
+                        "description": "When the application has already been decided",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "When an internal server error occurs",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPInternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/application/{application_id}/events": {
+            "get": {
+                "description": "Streams the status of a loan application as Server-Sent Events. Each event is named \"status\", and its data\nis a models.StatusEventView. The first event is the current status, and the stream ends once the\napplication is decided, times out or is withdrawn. A comment is sent every 15 seconds to keep an idle stream open.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Streams status changes of a loan application",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Loan Application ID",
+                        "name": "application_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Status events",
+                        "schema": {
+                            "$ref": "#/definitions/models.StatusEventView"
+                        }
+                    },
+                    "404": {
+                        "description": "When an application ID is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPNotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "When an internal server error occurs",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPInternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/application/{application_id}/history": {
+            "get": {
+                "description": "Gets the full lifecycle of a loan application for support staff. This includes the ID of\nthe application with the bank, when it was created, submitted and decided, and each change to its status.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Gets the history of a loan application",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Loan Application ID",
+                        "name": "application_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Application history retrieved",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApplicationHistoryResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "When an application ID is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPNotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "When an internal server error occurs",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPInternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/applications": {
+            "get": {
+                "description": "Gets a page of the loans matching every filter provided, sorted by creation time. At least one filter is required.\nIf there are more loans, the response includes a next_page_token. Pass it as the page_token\nparameter, along with the same filters and sort, to get the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Searches for loan applications",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Last name, which must match exactly",
+                        "name": "last_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The start of the first name",
+                        "name": "first_name_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only loans created at or after this RFC 3339 time, eg 2022-07-01T00:00:00Z",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only loans created before this RFC 3339 time",
+                        "name": "created_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Statuses [queued, submitted, polling, pending, completed, rejected, timed_out, failed, withdrawn]",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 50,
+                        "description": "The maximum number of loans to return, from 1 to 500",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The next_page_token returned with the previous page",
+                        "name": "page_token",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort by creation time [asc, desc]",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Applications retrieved",
+                        "schema": {
+                            "$ref": "#/definitions/models.SearchApplicationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "When no filter is provided, or a parameter is not a valid value",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPBadRequestError"
+                        }
+                    },
+                    "500": {
+                        "description": "When an internal server error occurs",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPInternalServerError"
+                        }
+                    }
+                }
+            }
+        },
         "/api/applications-with-status": {
             "get": {
-                "description": "Gets all loans based on a provided status",
+                "description": "Gets a page of the loans with a provided status, sorted by creation time.\nIf there are more loans, the response includes a next_page_token. Pass it as the page_token\nparameter, along with the same status and sort, to get the next page.",
                 "produces": [
                     "application/json"
                 ],
@@ -122,10 +351,30 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Status [pending, completed, rejected]",
+                        "description": "Status [queued, submitted, polling, pending, completed, rejected, timed_out, failed, withdrawn]",
                         "name": "status",
                         "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 50,
+                        "description": "The maximum number of loans to return, from 1 to 500",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The next_page_token returned with the previous page",
+                        "name": "page_token",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort by creation time [asc, desc]",
+                        "name": "sort",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -136,7 +385,7 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "When the status parameter is not provided or is not a valid value",
+                        "description": "When the status parameter is not provided, or a parameter is not a valid value",
                         "schema": {
                             "$ref": "#/definitions/controllers.HTTPBadRequestError"
                         }
@@ -149,6 +398,175 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/api/applications/batch": {
+            "post": {
+                "description": "Creates a loan application for each item of an array of up to 500 requests.\nEach item is validated on its own. The valid items are created together, and the invalid items are\nreported in the results, by their index in the array. Either every valid item is created, or none are.\n201 is returned when every item was created, 207 when only some were, and 422 when no item was valid.\nBatches are not idempotent, the Idempotency-Key header is not supported.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Create a batch of loan applications",
+                "parameters": [
+                    {
+                        "description": "Create loan applications",
+                        "name": "applications",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.CreateApplicationRequest"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Every loan application created",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchCreateApplicationsResponse"
+                        }
+                    },
+                    "207": {
+                        "description": "Some loan applications created",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchCreateApplicationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "When the request body is not an array of 1 to 500 items",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPBadRequestError"
+                        }
+                    },
+                    "413": {
+                        "description": "When the request body is larger than 4 MiB",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPBadRequestError"
+                        }
+                    },
+                    "422": {
+                        "description": "When no item is valid",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchCreateApplicationsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "When an internal server error occurs, no application is created",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPInternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/applications/events": {
+            "get": {
+                "description": "Upgrades to a WebSocket, on which a models.StatusEventView is sent as a JSON text message whenever one of\nthe applications is decided, times out or is withdrawn. Without any application IDs, events for every application are sent.\nOnly changes made after connecting are sent, so read the current status of the applications once connected.\nAnything the client sends is ignored. The connection is pinged every 15 seconds.",
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Streams status changes of many loan applications",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Loan Application IDs, of which there may be up to 100",
+                        "name": "application_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching to the WebSocket protocol",
+                        "schema": {
+                            "$ref": "#/definitions/models.StatusEventView"
+                        }
+                    },
+                    "400": {
+                        "description": "When the request is not a WebSocket handshake, or there are too many application IDs",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPBadRequestError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/applications/export": {
+            "get": {
+                "description": "Streams every loan matching the filters provided, oldest first, as CSV or newline delimited JSON.\nEach row has the same fields as models.ClientApplicationView, and a CSV export starts with a header row.\nWithout any filters, every loan is exported.\nIf an error occurs once the export has started, it is cut short, so the last row may be incomplete.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "applications"
+                ],
+                "summary": "Exports loan applications",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Format [csv, ndjson]",
+                        "name": "format",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Statuses [queued, submitted, polling, pending, completed, rejected, timed_out, failed, withdrawn]",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only loans created at or after this RFC 3339 time, eg 2022-07-01T00:00:00Z",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only loans created before this RFC 3339 time",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Applications exported",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ClientApplicationView"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "When the format parameter is not provided, or a parameter is not a valid value",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPBadRequestError"
+                        }
+                    },
+                    "500": {
+                        "description": "When an internal server error occurs before the export starts",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.HTTPInternalServerError"
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
@@ -165,6 +583,19 @@ const docTemplate = `{
                 }
             }
         },
+        "controllers.HTTPConflictError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer",
+                    "example": 409
+                },
+                "error": {
+                    "type": "string",
+                    "example": "status conflict"
+                }
+            }
+        },
         "controllers.HTTPInternalServerError": {
             "type": "object",
             "properties": {
@@ -191,6 +622,106 @@ const docTemplate = `{
                 }
             }
         },
+        "models.ApplicationHistoryResponse": {
+            "type": "object",
+            "required": [
+                "application_id",
+                "first_name",
+                "history",
+                "last_name",
+                "status"
+            ],
+            "properties": {
+                "annual_income": {
+                    "type": "integer"
+                },
+                "application_id": {
+                    "type": "string"
+                },
+                "bank_application_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "date_of_birth": {
+                    "type": "string"
+                },
+                "decided_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.StatusChangeView"
+                    }
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "loan_amount": {
+                    "type": "integer"
+                },
+                "purpose": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "submitted_at": {
+                    "type": "string"
+                },
+                "term_months": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BatchCreateApplicationResult": {
+            "type": "object",
+            "properties": {
+                "application_id": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.BatchCreateApplicationsResponse": {
+            "type": "object",
+            "required": [
+                "results"
+            ],
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BatchCreateApplicationResult"
+                    }
+                }
+            }
+        },
         "models.ClientApplicationView": {
             "type": "object",
             "required": [
@@ -217,15 +748,46 @@ const docTemplate = `{
         "models.CreateApplicationRequest": {
             "type": "object",
             "required": [
+                "annual_income",
+                "currency",
+                "date_of_birth",
+                "email",
                 "first_name",
-                "last_name"
+                "last_name",
+                "loan_amount",
+                "purpose",
+                "term_months"
             ],
             "properties": {
+                "annual_income": {
+                    "type": "integer"
+                },
+                "callback_url": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "date_of_birth": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
                 "first_name": {
                     "type": "string"
                 },
                 "last_name": {
                     "type": "string"
+                },
+                "loan_amount": {
+                    "type": "integer"
+                },
+                "purpose": {
+                    "type": "string"
+                },
+                "term_months": {
+                    "type": "integer"
                 }
             }
         },
@@ -238,17 +800,41 @@ const docTemplate = `{
                 "status"
             ],
             "properties": {
+                "annual_income": {
+                    "type": "integer"
+                },
                 "application_id": {
                     "type": "string"
                 },
+                "callback_url": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "date_of_birth": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
                 "first_name": {
                     "type": "string"
                 },
                 "last_name": {
                     "type": "string"
                 },
+                "loan_amount": {
+                    "type": "integer"
+                },
+                "purpose": {
+                    "type": "string"
+                },
                 "status": {
                     "type": "string"
+                },
+                "term_months": {
+                    "type": "integer"
                 }
             }
         },
@@ -263,6 +849,70 @@ const docTemplate = `{
                     "items": {
                         "$ref": "#/definitions/models.ClientApplicationView"
                     }
+                },
+                "next_page_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.SearchApplicationsResponse": {
+            "type": "object",
+            "required": [
+                "applications"
+            ],
+            "properties": {
+                "applications": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ClientApplicationView"
+                    }
+                },
+                "next_page_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.StatusChangeView": {
+            "type": "object",
+            "required": [
+                "at",
+                "source",
+                "status"
+            ],
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.StatusEventView": {
+            "type": "object",
+            "required": [
+                "application_id",
+                "at",
+                "status"
+            ],
+            "properties": {
+                "application_id": {
+                    "type": "string"
+                },
+                "at": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
                 }
             }
         }
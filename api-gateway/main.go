@@ -9,16 +9,21 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
-	amqp "github.com/rabbitmq/amqp091-go"
 	swaggerFiles "github.com/swaggo/files"
 	"github.com/swaggo/gin-swagger"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"service-shared/database"
+	messagequeue "service-shared/message-queue"
 	shared_config "service-shared/shared-config"
 	sharedhelpers "service-shared/shared-helpers"
 	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+	"sync"
+	"syscall"
 )
 
 /*
@@ -28,41 +33,78 @@ This API gateway is capable of service the following requests:
 1. Creating a loan application
 2. Getting the status of a loan application, given its application ID
 3. Getting all applications with a given status.
+4. Streaming changes to the status of applications, as Server-Sent Events or over a WebSocket.
+5. Withdrawing a loan application which has not been decided.
 
 This application is responsible for receiving and handling HTTP requests.
 
 GET requests do not reach out to the bank API, but provide a view of loan applications
 directly from the database to clients.
 
-POST requests also do not reach out to the bank API, instead they publish a message to a message
-queue, and return the status of an application as pending should the create request be successful.
+POST requests also do not reach out to the bank API, instead they store the application along with
+a message in an outbox, and return the status of an application as queued should the create request
+be successful. An outbox relay publishes these messages to a message queue in the background.
 This means that this API gateway does not rely on an 'live' bank API to provide some response to clients.
 */
 func main() {
 	cfg := shared_config.Get()
 	fmt.Println("API Gateway is starting ...")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Record the spans of each request, and of the messages published for it
+	shutdownTracing, err := tracing.Init(ctx, cfg, sharedmodels.APIGatewayService)
+	sharedhelpers.FailOnError(err, "Failed to initialise tracing")
+	defer shutdownTracing()
 
 	// Connect to database
 	fmt.Println("Connecting to db ... ")
-	dbClient, err := database.InitClient(cfg)
+	dbClient, err := database.InitClient(ctx, cfg)
 	sharedhelpers.FailOnError(err, "Failed to initialise the db client")
 	defer dbClient.Disconnect(context.Background())
 	collection := dbClient.Database(cfg.DatabaseName).Collection(cfg.DBColletionName)
-	database.InitIndexes(collection)
-	mongo := database.NewMongoCollection(collection)
-	repository := database.NewMongoRepository(mongo)
+	outboxCollection := dbClient.Database(cfg.DatabaseName).Collection(cfg.OutboxCollectionName)
+	idempotencyCollection := dbClient.Database(cfg.DatabaseName).Collection(cfg.IdempotencyCollectionName)
+	indexCtx, cancelIndexes := context.WithTimeout(ctx, cfg.DBConnectTimeout)
+	database.InitIndexes(indexCtx, collection)
+	database.InitOutboxIndexes(indexCtx, outboxCollection, cfg.OutboxRetention)
+	database.InitIdempotencyIndexes(indexCtx, idempotencyCollection, cfg.IdempotencyKeyTTL)
+	cancelIndexes()
+	repository := database.NewMongoRepository(database.Collections{
+		Applications: database.NewMongoCollection(collection),
+		Outbox:       database.NewMongoCollection(outboxCollection),
+		Idempotency:  database.NewMongoCollection(idempotencyCollection),
+	}, database.NewMongoTransactor(dbClient), cfg)
 
 	// Setup rabbitmq work queue
 	fmt.Println("Connecting to RabbitMQ ... ")
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
-	sharedhelpers.FailOnError(err, "Failed to connect to RabbitMQ")
-	defer conn.Close()
-	ch, err := conn.Channel()
-	sharedhelpers.FailOnError(err, "Failed to open a channel to RabbitMQ")
-	messageQueue := repositorys.NewRabbitQueue(ch, cfg)
+	connection := messagequeue.NewConnectionManager(cfg.RabbitMQURL, sharedhelpers.Backoff{
+		Base:   cfg.RabbitMQReconnectBase,
+		Max:    cfg.RabbitMQReconnectMax,
+		Jitter: 0.2,
+	})
+	connection.Connect()
+	defer connection.Close()
+	messageQueue := repositorys.NewRabbitQueue(connection, cfg)
+	eventQueue := repositorys.NewRabbitEventQueue(connection, cfg)
+
+	// Status changes published by the poll service are pushed to the clients waiting on them
+	broker := repositorys.NewStatusBroker()
+	err = repositorys.SubscribeToStatusEvents(connection, cfg, broker)
+	sharedhelpers.FailOnError(err, "Failed to subscribe to status events")
 
-	// Set up controller
-	controller := controllers.NewLoanAppController(repository, messageQueue)
+	// Relay messages written to the outbox onto the create application queue
+	relay := repositorys.NewOutboxRelay(repository, messageQueue, cfg)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		relay.Run(ctx)
+	}()
+
+	// Set up controllers
+	controller := controllers.NewLoanAppController(repository, eventQueue)
+	eventsController := controllers.NewStatusEventsController(repository, broker)
 
 	// Setup the API
 	fmt.Println("Setting up the API router ...")
@@ -72,26 +114,50 @@ func main() {
 	f, _ := os.Create("gin.log")
 	gin.DefaultWriter = io.MultiWriter(f)
 
-	// Add custom validator for validstatus
+	// Add custom validators, such as validstatus
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
-		err := v.RegisterValidation("validstatus", sharedmodels.ValidStatus)
-		sharedhelpers.FailOnError(err, "Failed to registry the validstatus validator")
+		err := sharedmodels.RegisterValidations(v)
+		sharedhelpers.FailOnError(err, "Failed to register the custom validators")
 	}
 
 	router := gin.Default()
+	router.Use(controllers.Tracing())
 	router.POST("/api/application", controller.CreateApplication)
 	router.GET("/api/application", controller.GetApplication)
+	router.GET("/api/application/:application_id/history", controller.GetApplicationHistory)
+	router.GET("/api/application/:application_id/events", eventsController.ApplicationEvents)
+	router.POST("/api/application/:application_id/cancel", controller.WithdrawApplication)
 	router.GET("/api/applications-with-status", controller.GetApplicationsWithStatus)
+	router.GET("/api/applications", controller.SearchApplications)
+	router.GET("/api/applications/export", controller.ExportApplications)
+	router.POST("/api/applications/batch", controller.CreateApplications)
+	router.GET("/api/applications/events", eventsController.StatusEventsFeed)
 	docs.SwaggerInfo.Title = "Go Bank Loan API"
 	docs.SwaggerInfo.Description = "An API which simulates creating loans with a banking API, as well as receiving information about the status of those loans."
 	docs.SwaggerInfo.Version = "1.0"
 	// use ginSwagger middleware to serve the API docs
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.HTTPPort), Handler: router}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 	fmt.Println("API is UP ... ")
 
-	err = router.Run(fmt.Sprintf(":%d", cfg.HTTPPort))
-	if err != nil {
-		log.Fatal(err)
+	// Stop accepting requests, and wait for in-flight requests and the outbox relay to finish
+	<-ctx.Done()
+	fmt.Println("API Gateway is shutting down ...")
+	// End event streams, which would otherwise hold the server open until the shutdown timeout
+	broker.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to gracefully shut down the HTTP server : %s\n", err)
+	}
+	if !sharedhelpers.WaitTimeout(wg, cfg.ShutdownTimeout) {
+		log.Printf("Timed out waiting for the outbox relay to stop\n")
 	}
 }
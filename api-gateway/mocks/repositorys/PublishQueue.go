@@ -3,6 +3,8 @@
 package mocks
 
 import (
+	context "context"
+
 	mock "github.com/stretchr/testify/mock"
 
 	shared_models "service-shared/shared-models"
@@ -13,13 +15,13 @@ type PublishQueue struct {
 	mock.Mock
 }
 
-// PublishLoanRequest provides a mock function with given fields: loanApplication
-func (_m *PublishQueue) PublishLoanRequest(loanApplication shared_models.CreateLoanMessage) error {
-	ret := _m.Called(loanApplication)
+// PublishLoanRequest provides a mock function with given fields: ctx, envelope
+func (_m *PublishQueue) PublishLoanRequest(ctx context.Context, envelope shared_models.Envelope) error {
+	ret := _m.Called(ctx, envelope)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(shared_models.CreateLoanMessage) error); ok {
-		r0 = rf(loanApplication)
+	if rf, ok := ret.Get(0).(func(context.Context, shared_models.Envelope) error); ok {
+		r0 = rf(ctx, envelope)
 	} else {
 		r0 = ret.Error(0)
 	}
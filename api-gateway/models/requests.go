@@ -1,8 +1,24 @@
 //Package models provides models used by controllers
 package models
 
-// CreateApplicationRequest represents an API request to create a new loan application
+import sharedmodels "service-shared/shared-models"
+
+// CreateApplicationRequest represents an API request to create a new loan application.
+// CallbackURL is optional, if it is set a signed webhook is posted to it once the application is decided.
+// Every field of the embedded LoanDetails is required.
 type CreateApplicationRequest struct {
-	FirstName string `json:"first_name" binding:"required"`
-	LastName  string `json:"last_name" binding:"required"`
+	FirstName   string `json:"first_name" binding:"required"`
+	LastName    string `json:"last_name" binding:"required"`
+	CallbackURL string `json:"callback_url,omitempty"`
+	sharedmodels.LoanDetails
+}
+
+// NewApplication returns the application to be created for the request
+func (request CreateApplicationRequest) NewApplication() sharedmodels.NewApplication {
+	return sharedmodels.NewApplication{
+		FirstName:   request.FirstName,
+		LastName:    request.LastName,
+		CallbackURL: request.CallbackURL,
+		LoanDetails: request.LoanDetails,
+	}
 }
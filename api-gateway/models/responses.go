@@ -1,14 +1,37 @@
 //Package models provides models used by controllers
 package models
 
-import sharedmodels "service-shared/shared-models"
+import (
+	sharedmodels "service-shared/shared-models"
+	"strings"
+	"time"
+	"unicode"
+)
 
 // CreateApplicationResponse represents an API response to a CreateApplicationRequest
 type CreateApplicationResponse struct {
-	ApplicationID string              `json:"application_id" binding:"required""`
+	ApplicationID string              `json:"application_id" binding:"required"`
 	Status        sharedmodels.Status `json:"status" binding:"required"`
 	FirstName     string              `json:"first_name" binding:"required"`
 	LastName      string              `json:"last_name" binding:"required"`
+	CallbackURL   string              `json:"callback_url,omitempty"`
+	sharedmodels.LoanDetails
+}
+
+// BatchCreateApplicationsResponse represents an API response to a batch of CreateApplicationRequests.
+// Results holds an entry for every item of the batch, in the order they were sent.
+type BatchCreateApplicationsResponse struct {
+	Created int                            `json:"created"`
+	Failed  int                            `json:"failed"`
+	Results []BatchCreateApplicationResult `json:"results" binding:"required"`
+}
+
+// BatchCreateApplicationResult is the outcome of an item of a batch. Either ApplicationID and Status, or Error, is set.
+type BatchCreateApplicationResult struct {
+	Index         int                 `json:"index"`
+	ApplicationID string              `json:"application_id,omitempty"`
+	Status        sharedmodels.Status `json:"status,omitempty"`
+	Error         string              `json:"error,omitempty"`
 }
 
 // ClientApplicationView represents the information we provide to clients of this API for a loan application
@@ -17,9 +40,75 @@ type ClientApplicationView struct {
 	Status        sharedmodels.Status `json:"status" binding:"required, validstatus" bson:"status"`
 	FirstName     string              `json:"first_name" binding:"required"`
 	LastName      string              `json:"last_name" binding:"required"`
+	CallbackURL   string              `json:"callback_url,omitempty"`
 }
 
-// GetAppsWithStatusResponse provides the client with a view of all applications with a given status
+// ClientApplicationViewCSVHeader names the columns of ClientApplicationView.CSVRecord
+var ClientApplicationViewCSVHeader = []string{"application_id", "status", "first_name", "last_name"}
+
+// CSVRecord returns the view as a row of a CSV export, in the order of ClientApplicationViewCSVHeader.
+// Cells given by clients are escaped with csvCell, so that a spreadsheet does not run them as formulas.
+func (view ClientApplicationView) CSVRecord() []string {
+	return []string{view.ApplicationID, string(view.Status), csvCell(view.FirstName), csvCell(view.LastName)}
+}
+
+// csvCell prefixes value with a single quote if it starts with a character which makes a spreadsheet treat
+// the cell as a formula, or which some spreadsheets skip before checking for one. Spreadsheets also trim
+// leading spaces, so the first character which is not whitespace is checked for a formula.
+func csvCell(value string) string {
+	trimmed := strings.TrimLeftFunc(value, unicode.IsSpace)
+	if startsWithAny(value, "\t\r") || startsWithAny(trimmed, "=+-@") {
+		return "'" + value
+	}
+
+	return value
+}
+
+func startsWithAny(value, chars string) bool {
+	return len(value) > 0 && strings.ContainsRune(chars, rune(value[0]))
+}
+
+// GetAppsWithStatusResponse provides the client with a view of a page of the applications with a given status.
+// NextPageToken is omitted on the last page.
 type GetAppsWithStatusResponse struct {
 	ApplicationsWithStatus []ClientApplicationView `json:"applications" binding:"required"`
+	NextPageToken          string                  `json:"next_page_token,omitempty"`
+}
+
+// SearchApplicationsResponse provides the client with a view of a page of the applications matching a search.
+// NextPageToken is omitted on the last page.
+type SearchApplicationsResponse struct {
+	Applications  []ClientApplicationView `json:"applications" binding:"required"`
+	NextPageToken string                  `json:"next_page_token,omitempty"`
+}
+
+// ApplicationHistoryResponse provides support staff with the full lifecycle of a loan application
+type ApplicationHistoryResponse struct {
+	ApplicationID     string              `json:"application_id" binding:"required"`
+	BankApplicationID string              `json:"bank_application_id,omitempty"`
+	Status            sharedmodels.Status `json:"status" binding:"required"`
+	FirstName         string              `json:"first_name" binding:"required"`
+	LastName          string              `json:"last_name" binding:"required"`
+	CreatedAt         *time.Time          `json:"created_at,omitempty"`
+	SubmittedAt       *time.Time          `json:"submitted_at,omitempty"`
+	DecidedAt         *time.Time          `json:"decided_at,omitempty"`
+	History           []StatusChangeView  `json:"history" binding:"required"`
+	// LoanDetails are omitted for applications created before they were introduced
+	sharedmodels.LoanDetails
+}
+
+// StatusChangeView represents a step in the lifecycle of a loan application, and the service which made it
+type StatusChangeView struct {
+	Status sharedmodels.Status `json:"status" binding:"required"`
+	Source string              `json:"source" binding:"required"`
+	Reason string              `json:"reason,omitempty"`
+	At     time.Time           `json:"at" binding:"required"`
+}
+
+// StatusEventView represents a change to the status of a loan application, pushed to clients as it happens
+type StatusEventView struct {
+	ApplicationID string              `json:"application_id" binding:"required"`
+	Status        sharedmodels.Status `json:"status" binding:"required"`
+	Reason        string              `json:"reason,omitempty"`
+	At            time.Time           `json:"at" binding:"required"`
 }
@@ -0,0 +1,57 @@
+package repositorys
+
+import (
+	"context"
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+)
+
+//EventQueue defines an interface for telling other services that the status of an application has changed.
+type EventQueue interface {
+	PublishStatusChanged(ctx context.Context, event sharedmodels.StatusChangedEvent) error
+}
+
+/*
+RabbitEventQueue publishes status changed events to the status event exchange, for changes made by the gateway
+itself, such as an application being withdrawn. Every gateway, including this one, receives the event through
+SubscribeToStatusEvents, and pushes it to the clients waiting on the application.
+*/
+type RabbitEventQueue struct {
+	publisher messagequeue.Publisher
+	cfg       sharedconfig.Config
+}
+
+//NewRabbitEventQueue returns a RabbitEventQueue. It publishes on a channel managed by connection,
+//which declares the status event exchange each time it is opened.
+func NewRabbitEventQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitEventQueue {
+	publisher, err := messagequeue.NewReconnectingPublisher(
+		connection,
+		"gateway status event publisher",
+		cfg.PublishConfirmTimeout,
+		func(ch *amqp.Channel) error {
+			return declareStatusEventExchange(ch, cfg)
+		})
+	sharedhelpers.FailOnError(err, "Gateway failed to open a status event channel to RabbitMQ")
+	return &RabbitEventQueue{publisher: publisher, cfg: cfg}
+}
+
+//PublishStatusChanged publishes event to the status event exchange, in the trace of ctx.
+func (queue RabbitEventQueue) PublishStatusChanged(ctx context.Context, event sharedmodels.StatusChangedEvent) error {
+	body, _ := json.Marshal(event)
+	publishing := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+	}
+
+	_, span := messagequeue.StartPublishSpan(ctx, queue.cfg.StatusEventExchangeName, &publishing)
+	err := queue.publisher.Publish(queue.cfg.StatusEventExchangeName, "", publishing)
+	tracing.End(span, err)
+
+	return err
+}
@@ -2,59 +2,66 @@
 package repositorys
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
 	sharedconfig "service-shared/shared-config"
 	sharedhelpers "service-shared/shared-helpers"
 	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
 )
 
 //PublishQueue defines an interface for interacting with a message queue. Specifically, it provides
-//an abstraction for sending a loan request to a message queue.
+//an abstraction for sending a loan request, wrapped in an envelope, to a message queue.
+//The message continues the trace in ctx.
 type PublishQueue interface {
-	PublishLoanRequest(loanApplication sharedmodels.CreateLoanMessage) error
+	PublishLoanRequest(ctx context.Context, envelope sharedmodels.Envelope) error
 }
 
 //RabbitMessageQueue is used to interact with a RabbitMQ message queue.
 type RabbitMessageQueue struct {
-	queue *amqp.Queue
-	ch    *amqp.Channel
-	cfg   sharedconfig.Config
+	queueName string
+	publisher messagequeue.Publisher
+	codec     messagequeue.Codec
+	cfg       sharedconfig.Config
 }
 
-//NewRabbitQueue returns a RabbitMessageQueue struct.
-func NewRabbitQueue(ch *amqp.Channel, cfg sharedconfig.Config) *RabbitMessageQueue {
-	queue, err := ch.QueueDeclare(
-		cfg.CreateApplicationQueueName, // name
-		true,                           // durable (survive restarts)
-		false,                          // do not delete when unused
-		false,                          // exclusive
-		false,                          // no-wait
-		nil)                            // args
-
-	sharedhelpers.FailOnError(err, "Gateway publisher failed to declare create application queue")
-	return &RabbitMessageQueue{queue: &queue, ch: ch}
+//NewRabbitQueue returns a RabbitMessageQueue struct. It publishes on a channel managed by connection,
+//which declares the create application queue each time it is opened. Messages are published in the
+//format of cfg.MessageContentType.
+func NewRabbitQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitMessageQueue {
+	codec, err := messagequeue.CodecFor(cfg.MessageContentType)
+	sharedhelpers.FailOnError(err, "Gateway publisher has no codec for the message content type")
+
+	publisher, err := messagequeue.NewReconnectingPublisher(
+		connection,
+		"gateway publisher",
+		cfg.PublishConfirmTimeout,
+		func(ch *amqp.Channel) error {
+			_, err := messagequeue.DeclareQueue(ch, cfg.CreateApplicationQueueName, cfg.DeadLetterExchangeName)
+			return err
+		})
+	sharedhelpers.FailOnError(err, "Gateway publisher failed to open a channel to RabbitMQ")
+	return &RabbitMessageQueue{queueName: cfg.CreateApplicationQueueName, publisher: publisher, codec: codec}
 }
 
 /*
 PublishLoanRequest publishes a message to a RabbitMQ queue. This message is intended to be consumed
 by a consumer, which should then negotiate with the bank API and create a loan application.
+
+A nil error means that the broker has confirmed the message, and so it is safe to mark it as sent.
 */
-func (msgQueue RabbitMessageQueue) PublishLoanRequest(createRequest sharedmodels.CreateLoanMessage) error {
-	fmt.Printf("Publishing loan request %#v\n", createRequest)
-	request, _ := json.Marshal(createRequest)
-
-	publishErr := msgQueue.ch.Publish(
-		"",
-		msgQueue.queue.Name,
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         request,
-		})
+func (msgQueue RabbitMessageQueue) PublishLoanRequest(ctx context.Context, envelope sharedmodels.Envelope) error {
+	fmt.Printf("Publishing loan request %s\n", envelope.MessageID)
+	publishing, err := messagequeue.NewPublishing(msgQueue.codec, envelope)
+	if err != nil {
+		return err
+	}
+
+	_, span := messagequeue.StartPublishSpan(ctx, msgQueue.queueName, &publishing)
+	publishErr := msgQueue.publisher.Publish("", msgQueue.queueName, publishing)
+	tracing.End(span, publishErr)
 
 	return publishErr
 }
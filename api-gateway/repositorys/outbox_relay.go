@@ -0,0 +1,101 @@
+package repositorys
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"service-shared/database"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+	"time"
+)
+
+/*
+OutboxRelay publishes loan requests which have been written to the outbox
+by database.Repository to the create application queue.
+
+An outbox entry is only marked as sent after it has been published. If the
+gateway stops between publishing and marking an entry, the entry is published
+again when the relay next runs. This gives at-least-once delivery, consumers
+of the create application queue must therefore tolerate duplicate messages.
+*/
+type OutboxRelay struct {
+	repository   database.Repository
+	messageQueue PublishQueue
+	interval     time.Duration
+	batchSize    int
+}
+
+//NewOutboxRelay returns an OutboxRelay struct.
+func NewOutboxRelay(repository database.Repository, messageQueue PublishQueue, cfg sharedconfig.Config) *OutboxRelay {
+	return &OutboxRelay{
+		repository:   repository,
+		messageQueue: messageQueue,
+		interval:     cfg.OutboxRelayInterval,
+		batchSize:    cfg.OutboxRelayBatchSize,
+	}
+}
+
+//Run relays outbox entries to the message queue every interval until ctx is cancelled.
+//It is intended to be run in its own goroutine.
+func (relay OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relay.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			relay.relayUnsent(ctx)
+		}
+	}
+}
+
+/*
+relayUnsent publishes a batch of unsent outbox entries, oldest first.
+
+If an entry cannot be published, the remaining entries in the batch are left for the
+next run so that loan requests are published in the order that they were created.
+Each entry is published in the trace of the request which created it.
+*/
+func (relay OutboxRelay) relayUnsent(ctx context.Context) {
+	entries, err := relay.repository.GetUnsentOutboxEntries(ctx, relay.batchSize)
+	if err != nil {
+		log.Printf("Outbox relay could not read the outbox %s\n", err)
+		return
+	}
+
+	for _, entry := range entries {
+		envelope, err := newLoanRequestEnvelope(entry)
+		if err != nil {
+			log.Printf("Outbox relay could not wrap entry %s, will retry : %s\n", entry.ID.Hex(), err)
+			return
+		}
+
+		if err := relay.messageQueue.PublishLoanRequest(tracing.Extract(ctx, entry.TraceContext), envelope); err != nil {
+			log.Printf("Outbox relay could not publish entry %s, will retry : %s\n", entry.ID.Hex(), err)
+			return
+		}
+
+		if err := relay.repository.MarkOutboxEntrySent(ctx, entry.ID.Hex()); err != nil {
+			// The entry will be published again on the next run
+			log.Printf("Outbox relay could not mark entry %s as sent : %s\n", entry.ID.Hex(), err)
+			return
+		}
+
+		fmt.Printf("Relayed message %#v to queue\n", entry.Message)
+	}
+}
+
+/*
+newLoanRequestEnvelope wraps the message of entry in an Envelope. Its MessageID, and the CorrelationID
+of every message which follows it, is the ID of the entry. So an entry which is published again, because
+it could not be marked as sent, is published with the same MessageID.
+*/
+func newLoanRequestEnvelope(entry sharedmodels.OutboxEntry) (sharedmodels.Envelope, error) {
+	envelope, err := sharedmodels.NewEnvelope(sharedmodels.CreateLoanMessageType, sharedmodels.CreateLoanMessageVersion,
+		sharedmodels.APIGatewayService, entry.ID.Hex(), entry.Message)
+	envelope.MessageID = entry.ID.Hex()
+	return envelope, err
+}
@@ -0,0 +1,119 @@
+package repositorys
+
+import (
+	mocks "api-gateway/mocks/repositorys"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"service-shared/database"
+	sharedmocks "service-shared/mocks/database"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+)
+
+func TestRelayUnsentOutboxReadError(t *testing.T) {
+	// Setup
+	repository := new(sharedmocks.Repository)
+	messageQueue := new(mocks.PublishQueue)
+	repository.On("GetUnsentOutboxEntries", mock.Anything, mock.Anything).Return(nil, database.InternalError)
+
+	relay := NewOutboxRelay(repository, messageQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	messageQueue.AssertNotCalled(t, "PublishLoanRequest", mock.Anything, mock.Anything)
+}
+
+func TestRelayUnsentPublishesAndMarksSent(t *testing.T) {
+	entries := getOutboxEntries(2)
+	// Setup
+	repository := new(sharedmocks.Repository)
+	messageQueue := new(mocks.PublishQueue)
+	repository.On("GetUnsentOutboxEntries", mock.Anything, 10).Return(entries, nil)
+	repository.On("MarkOutboxEntrySent", mock.Anything, mock.Anything).Return(nil)
+	messageQueue.On("PublishLoanRequest", mock.Anything, mock.Anything).Return(nil)
+
+	relay := NewOutboxRelay(repository, messageQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	for _, entry := range entries {
+		messageQueue.AssertCalled(t, "PublishLoanRequest", mock.Anything, mock.MatchedBy(func(envelope sharedmodels.Envelope) bool {
+			return envelope.MessageID == entry.ID.Hex()
+		}))
+		repository.AssertCalled(t, "MarkOutboxEntrySent", mock.Anything, entry.ID.Hex())
+	}
+}
+
+func TestRelayUnsentStopsOnPublishError(t *testing.T) {
+	entries := getOutboxEntries(2)
+	// Setup
+	repository := new(sharedmocks.Repository)
+	messageQueue := new(mocks.PublishQueue)
+	repository.On("GetUnsentOutboxEntries", mock.Anything, mock.Anything).Return(entries, nil)
+	messageQueue.On("PublishLoanRequest", mock.Anything, mock.Anything).Return(errors.New(""))
+
+	relay := NewOutboxRelay(repository, messageQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	// The failed entry must not be marked as sent, and later entries must wait for the next run
+	repository.AssertNotCalled(t, "MarkOutboxEntrySent", mock.Anything, mock.Anything)
+	messageQueue.AssertNumberOfCalls(t, "PublishLoanRequest", 1)
+}
+
+func TestRelayUnsentContinuesTraceOfEntry(t *testing.T) {
+	entries := getOutboxEntries(1)
+	entries[0].TraceContext = map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// Setup
+	repository := new(sharedmocks.Repository)
+	messageQueue := new(mocks.PublishQueue)
+	repository.On("GetUnsentOutboxEntries", mock.Anything, mock.Anything).Return(entries, nil)
+	repository.On("MarkOutboxEntrySent", mock.Anything, mock.Anything).Return(nil)
+	messageQueue.On("PublishLoanRequest", mock.Anything, mock.Anything).Return(nil)
+
+	relay := NewOutboxRelay(repository, messageQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	messageQueue.AssertCalled(t, "PublishLoanRequest", mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanContextFromContext(ctx).TraceID().String() == "0af7651916cd43dd8448eb211c80319c"
+	}), mock.Anything)
+}
+
+func TestNewLoanRequestEnvelope(t *testing.T) {
+	entry := getOutboxEntries(1)[0]
+
+	envelope, err := newLoanRequestEnvelope(entry)
+
+	assert.Nil(t, err)
+	assert.Equal(t, entry.ID.Hex(), envelope.MessageID)
+	assert.Equal(t, entry.ID.Hex(), envelope.CorrelationID)
+	assert.Equal(t, sharedmodels.CreateLoanMessageType, envelope.Type)
+	assert.Equal(t, sharedmodels.CreateLoanMessageVersion, envelope.SchemaVersion)
+	assert.Equal(t, sharedmodels.APIGatewayService, envelope.Producer)
+	// The message is decoded as it was written to the outbox
+	message, err := sharedmodels.DecodeCreateLoanMessage(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, entry.Message, message)
+}
+
+func getOutboxEntries(count int) []sharedmodels.OutboxEntry {
+	var entries []sharedmodels.OutboxEntry
+	for i := 0; i < count; i++ {
+		id := primitive.NewObjectID()
+		entries = append(entries, sharedmodels.OutboxEntry{
+			ID: id,
+			Message: sharedmodels.CreateLoanMessage{
+				ApplicationID: id.Hex(),
+				FirstName:     "First",
+				LastName:      "Last",
+			},
+		})
+	}
+
+	return entries
+}
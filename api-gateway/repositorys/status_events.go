@@ -0,0 +1,192 @@
+package repositorys
+
+import (
+	"context"
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+	"sync"
+)
+
+//subscriptionBufferSize is how many events a subscription holds for a client before it is considered too slow
+const subscriptionBufferSize = 64
+
+/*
+StatusBroker fans status changed events out to the clients of this gateway which are subscribed to them.
+
+Publishing never blocks. A subscriber which falls subscriptionBufferSize events behind is unsubscribed,
+and its events channel closed, so that one slow client cannot hold up the others. The client can then
+reconnect, and read the current status of its applications.
+*/
+type StatusBroker struct {
+	mu          *sync.Mutex
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+//Subscription receives the status changed events for a set of applications, or for every application.
+type Subscription struct {
+	events         chan sharedmodels.StatusChangedEvent
+	applicationIDs map[string]bool
+	broker         *StatusBroker
+}
+
+//NewStatusBroker returns a StatusBroker without any subscribers
+func NewStatusBroker() *StatusBroker {
+	return &StatusBroker{mu: &sync.Mutex{}, subscribers: map[*Subscription]bool{}}
+}
+
+//Subscribe returns a Subscription to the events for applicationIDs. Without any applicationIDs, it receives every event.
+//The subscription must be closed once it is no longer needed.
+func (broker *StatusBroker) Subscribe(applicationIDs ...string) *Subscription {
+	subscription := &Subscription{
+		events: make(chan sharedmodels.StatusChangedEvent, subscriptionBufferSize),
+		broker: broker,
+	}
+	if len(applicationIDs) > 0 {
+		subscription.applicationIDs = map[string]bool{}
+		for _, applicationID := range applicationIDs {
+			subscription.applicationIDs[applicationID] = true
+		}
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.closed {
+		close(subscription.events)
+		return subscription
+	}
+	broker.subscribers[subscription] = true
+	return subscription
+}
+
+//Publish sends event to every subscription for its application.
+func (broker *StatusBroker) Publish(event sharedmodels.StatusChangedEvent) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for subscription := range broker.subscribers {
+		if subscription.applicationIDs != nil && !subscription.applicationIDs[event.ApplicationID] {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			log.Printf("Dropping a status event subscriber which is %d events behind\n", subscriptionBufferSize)
+			broker.remove(subscription)
+		}
+	}
+}
+
+//Close closes every subscription, and any made afterwards. It is used on shutdown, so that streams to clients end.
+func (broker *StatusBroker) Close() {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	broker.closed = true
+	for subscription := range broker.subscribers {
+		broker.remove(subscription)
+	}
+}
+
+//remove unsubscribes subscription and closes its events channel. broker.mu must be held.
+func (broker *StatusBroker) remove(subscription *Subscription) {
+	if broker.subscribers[subscription] {
+		delete(broker.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+//Events returns the channel on which events are received. It is closed if the subscriber falls too far behind, or the broker is closed.
+func (subscription *Subscription) Events() <-chan sharedmodels.StatusChangedEvent {
+	return subscription.events
+}
+
+//Close unsubscribes from the broker. It is safe to call more than once.
+func (subscription *Subscription) Close() {
+	subscription.broker.mu.Lock()
+	defer subscription.broker.mu.Unlock()
+	subscription.broker.remove(subscription)
+}
+
+/*
+SubscribeToStatusEvents binds a queue to the status event exchange, and publishes each event received on it to broker.
+
+The queue is exclusive to this gateway and deleted when it disconnects, so every gateway receives every event.
+It is declared, and bound, again whenever connection reopens the channel. Events published while the gateway
+is disconnected from RabbitMQ are not received.
+Each event is received in a consumer span, which continues the trace that the event was published in.
+*/
+func SubscribeToStatusEvents(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config, broker *StatusBroker) error {
+	return connection.OpenChannel("status event subscriber", func(ch *amqp.Channel) error {
+		err := declareStatusEventExchange(ch, cfg)
+		if err != nil {
+			return err
+		}
+
+		queue, err := ch.QueueDeclare(
+			"",    // name, chosen by the broker
+			false, // not durable
+			true,  // delete when unused
+			true,  // exclusive
+			false, // no-wait
+			nil)   // args
+		if err != nil {
+			return err
+		}
+
+		if err = ch.QueueBind(queue.Name, "", cfg.StatusEventExchangeName, false, nil); err != nil {
+			return err
+		}
+
+		deliveries, err := ch.Consume(
+			queue.Name, // queue
+			"",         // consumer
+			true,       // auto-ack, as events are not redelivered
+			true,       // exclusive
+			false,      // no-local
+			false,      // no-wait
+			nil)        // args
+		if err != nil {
+			return err
+		}
+
+		// deliveries is closed when the channel closes
+		go func() {
+			for delivery := range deliveries {
+				receiveStatusEvent(delivery, cfg, broker)
+			}
+		}()
+
+		return nil
+	})
+}
+
+//receiveStatusEvent publishes the status changed event in delivery to broker.
+func receiveStatusEvent(delivery amqp.Delivery, cfg sharedconfig.Config, broker *StatusBroker) {
+	_, span := messagequeue.StartConsumeSpan(context.Background(), cfg.StatusEventExchangeName, delivery)
+	var event sharedmodels.StatusChangedEvent
+	if err := json.Unmarshal(delivery.Body, &event); err != nil {
+		log.Printf("Could not unmarshal status event %s : %s\n", delivery.Body, err)
+		tracing.End(span, err)
+		return
+	}
+
+	broker.Publish(event)
+	span.End()
+}
+
+//declareStatusEventExchange declares the status event exchange, in the same way as the poll service
+func declareStatusEventExchange(ch *amqp.Channel, cfg sharedconfig.Config) error {
+	return ch.ExchangeDeclare(
+		cfg.StatusEventExchangeName, // name
+		"fanout",                    // kind
+		true,                        // durable (survive restarts)
+		false,                       // do not delete when unused
+		false,                       // internal
+		false,                       // no-wait
+		nil)                         // args
+}
@@ -0,0 +1,86 @@
+package repositorys
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+)
+
+func TestStatusBrokerPublishesToSubscribers(t *testing.T) {
+	broker := NewStatusBroker()
+	abc := broker.Subscribe("abc")
+	defer abc.Close()
+	def := broker.Subscribe("def")
+	defer def.Close()
+	all := broker.Subscribe()
+	defer all.Close()
+
+	event := sharedmodels.StatusChangedEvent{ApplicationID: "abc", Status: sharedmodels.Completed}
+	broker.Publish(event)
+
+	assert.Equal(t, event, <-abc.Events())
+	assert.Equal(t, event, <-all.Events())
+	assert.Empty(t, def.Events())
+}
+
+func TestStatusBrokerDropsSlowSubscriber(t *testing.T) {
+	broker := NewStatusBroker()
+	slow := broker.Subscribe()
+	defer slow.Close()
+
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		broker.Publish(sharedmodels.StatusChangedEvent{ApplicationID: "abc", Status: sharedmodels.Completed})
+	}
+
+	// The buffered events are still received, then the channel is closed
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	assert.Equal(t, subscriptionBufferSize, received)
+	assert.Empty(t, broker.subscribers)
+}
+
+func TestStatusBrokerCloseEndsSubscriptions(t *testing.T) {
+	broker := NewStatusBroker()
+	before := broker.Subscribe("abc")
+
+	broker.Close()
+	after := broker.Subscribe("abc")
+	// Closing a subscription after the broker is safe
+	before.Close()
+
+	_, open := <-before.Events()
+	assert.False(t, open)
+	_, open = <-after.Events()
+	assert.False(t, open)
+}
+
+func TestReceiveStatusEventContinuesTrace(t *testing.T) {
+	// Setup
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	broker := NewStatusBroker()
+	subscription := broker.Subscribe("abc")
+	defer subscription.Close()
+
+	delivery := amqp.Delivery{
+		Headers: amqp.Table{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		Body:    []byte(`{"application_id":"abc","status":"withdrawn"}`),
+	}
+	receiveStatusEvent(delivery, sharedconfig.Config{StatusEventExchangeName: "status_events"}, broker)
+
+	assert.Equal(t, sharedmodels.Withdrawn, (<-subscription.Events()).Status)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, trace.SpanKindConsumer, spans[0].SpanKind)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext.TraceID().String())
+}
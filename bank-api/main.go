@@ -28,11 +28,20 @@ import (
 )
 
 // Application represents the application data structure.
+// The loan details are optional, as older clients only send the applicant's name.
+// Amounts are in minor units of the currency.
 type Application struct {
-	ID        string `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Status    string `json:"status"`
+	ID           string `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	LoanAmount   int64  `json:"loan_amount,omitempty"`
+	Currency     string `json:"currency,omitempty"`
+	TermMonths   int    `json:"term_months,omitempty"`
+	Purpose      string `json:"purpose,omitempty"`
+	AnnualIncome int64  `json:"annual_income,omitempty"`
+	Email        string `json:"email,omitempty"`
+	DateOfBirth  string `json:"date_of_birth,omitempty"`
+	Status       string `json:"status"`
 }
 
 var (
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"create-application-service/repositorys"
+	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
 	"net/http"
+	"os/signal"
+	"service-shared/database"
 	sharedhttp "service-shared/http"
 	messagequeue "service-shared/message-queue"
 	sharedconfig "service-shared/shared-config"
 	sharedhelpers "service-shared/shared-helpers"
+	"service-shared/tracing"
 	"sync"
+	"syscall"
+	"time"
 )
 
 /*
@@ -19,41 +27,76 @@ messages off a queue. These messages instruct this service to create a
 new loan application with the bank API.
 
 This consumer also acts as a publisher, after creating an application with
-the bank API, it will publish a message to a queue. This message is intended
+the bank API, and marking it as submitted in the DB, it will publish a message to a queue. This message is intended
 to be picked up by the Poll Application Service.
 
 This function spawns parallel workers.
 For implementation details, please see repositorys.mq_worker
 */
+const serviceName = "create-application-service"
+
 func main() {
 	cfg := sharedconfig.Get()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Record the spans of each message, continuing the trace of the request which created the application
+	shutdownTracing, err := tracing.Init(ctx, cfg, serviceName)
+	sharedhelpers.FailOnError(err, "Failed to initialise tracing")
+	defer shutdownTracing()
+
+	// Connect to database
+	fmt.Println("Connecting to db ... ")
+	dbClient, err := database.InitClient(ctx, cfg)
+	sharedhelpers.FailOnError(err, "Failed to initialise the db client")
+	defer dbClient.Disconnect(context.Background())
+	repository := database.NewMongoRepository(database.Collections{
+		Applications: database.NewMongoCollection(dbClient.Database(cfg.DatabaseName).Collection(cfg.DBColletionName)),
+	}, database.NewMongoTransactor(dbClient), cfg)
 
-	// Connect to the rabbit queue that we will consume from
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
-	sharedhelpers.FailOnError(err, "Failed to connect to RabbitMQ")
-	defer conn.Close()
+	// Connect to RabbitMQ. The connection, and every channel opened on it, is recovered if it is lost
+	connection := messagequeue.NewConnectionManager(cfg.RabbitMQURL, sharedhelpers.Backoff{
+		Base:   cfg.RabbitMQReconnectBase,
+		Max:    cfg.RabbitMQReconnectMax,
+		Jitter: 0.2,
+	})
+	connection.Connect()
+	defer connection.Close()
 
 	// Create publish queue
-	ch, err := conn.Channel()
-	sharedhelpers.FailOnError(err, "Publisher failed to open a channel to RabbitMQ")
-	defer ch.Close()
-	publishQueue := repositorys.NewRabbitPublishQueue(ch, cfg)
+	publishQueue := repositorys.NewRabbitPublishQueue(connection, cfg)
+
+	// Failed messages are published to the dead letter exchange on their own channel
+	deadLetterPublisher, err := messagequeue.NewReconnectingPublisher(connection, "dead letter publisher", cfg.PublishConfirmTimeout, nil)
+	sharedhelpers.FailOnError(err, "Failed to open a dead letter channel to RabbitMQ")
 
 	// Set up worker to consume off the channel and publish to the poll queue
 	in := make(chan amqp.Delivery)
 	wg := &sync.WaitGroup{}
+	// Work which is still in progress at the shutdown deadline is cancelled, and its message requeued
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
 	maxWorkers := cfg.CreateServiceWorkers
 	wg.Add(cfg.CreateServiceWorkers)
-	handler := messagequeue.AmqpDeliveryHandler{}
+	handler := messagequeue.NewAmqpDeliveryHandler(deadLetterPublisher, cfg.DeadLetterExchangeName, cfg.CreateApplicationQueueName, serviceName)
 	httpClient := sharedhttp.DefaultClient{HttpClient: http.DefaultClient}
 	for i := 1; i <= maxWorkers; i++ {
-		worker := repositorys.NewRabbitMQWorker(wg, in, publishQueue, cfg, handler, httpClient)
-		go worker.ProcessMessages()
+		worker := repositorys.NewRabbitMQWorker(repository, wg, in, publishQueue, cfg, handler, httpClient)
+		go worker.ProcessMessages(ctx, workCtx)
 	}
 
 	// Consumes messages from the queue, passes to in, which is consumed by the workers
-	consumer := messagequeue.NewRabbitMQConsumer(conn, maxWorkers, in, cfg.CreateApplicationQueueName)
-	go consumer.Consume()
+	consumer := messagequeue.NewRabbitMQConsumer(connection, maxWorkers, in, cfg.CreateApplicationQueueName, cfg.DeadLetterExchangeName)
+	err = consumer.Consume(ctx)
+	sharedhelpers.FailOnError(err, "Failed to consume from the create application queue")
 
-	wg.Wait()
+	// On shutdown, stop consuming and wait for the workers to finish their current message.
+	// Messages which are still unacked when the connection closes are requeued by the broker.
+	<-ctx.Done()
+	fmt.Println("Create Application service is shutting down ...")
+	time.AfterFunc(cfg.ShutdownTimeout, cancelWork)
+	// Allow the workers a moment to requeue cancelled messages
+	if !sharedhelpers.WaitTimeout(wg, cfg.ShutdownTimeout+time.Second) {
+		log.Printf("Timed out waiting for workers to finish, unacked messages will be requeued\n")
+	}
 }
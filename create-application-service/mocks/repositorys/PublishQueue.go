@@ -2,20 +2,24 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // PublishQueue is an autogenerated mock type for the PublishQueue type
 type PublishQueue struct {
 	mock.Mock
 }
 
-// PublishPollRequest provides a mock function with given fields: bankApplicationID, ourApplicationID
-func (_m *PublishQueue) PublishPollRequest(bankApplicationID string, ourApplicationID string) error {
-	ret := _m.Called(bankApplicationID, ourApplicationID)
+// PublishPollRequest provides a mock function with given fields: ctx, bankApplicationID, ourApplicationID, correlationID
+func (_m *PublishQueue) PublishPollRequest(ctx context.Context, bankApplicationID string, ourApplicationID string, correlationID string) error {
+	ret := _m.Called(ctx, bankApplicationID, ourApplicationID, correlationID)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(bankApplicationID, ourApplicationID)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, bankApplicationID, ourApplicationID, correlationID)
 	} else {
 		r0 = ret.Error(0)
 	}
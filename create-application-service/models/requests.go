@@ -1,9 +1,13 @@
 package models
 
+import sharedmodels "service-shared/shared-models"
+
 // Requests to the bank API
 
+//CreateLoanRequest is sent to the bank API. The loan details are omitted for applications created before they were introduced.
 type CreateLoanRequest struct {
 	ID        string `json:"id" binding:"required"`
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
+	sharedmodels.LoanDetails
 }
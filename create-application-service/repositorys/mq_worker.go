@@ -2,6 +2,7 @@ package repositorys
 
 import (
 	"bytes"
+	"context"
 	"create-application-service/models"
 	"encoding/json"
 	"errors"
@@ -9,23 +10,32 @@ import (
 	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"net/http"
+	"service-shared/database"
 	sharedhttp "service-shared/http"
 	messagequeue "service-shared/message-queue"
 	sharedconfig "service-shared/shared-config"
 	sharedmodels "service-shared/shared-models"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	contentType = "application/json"
+	// The bank API responds to a create request for an existing application ID with a 400 containing this message
+	alreadyUsedResponse = "already used"
 )
 
+//bankApplicationNamespace is the UUID namespace in which bank application IDs are derived from our application IDs
+var bankApplicationNamespace = uuid.MustParse("861839cc-1342-43e2-9d1f-43e02b21bb6e")
+
 /*
 RabbitMQWorker defines a worker responsible for handling
 messages from a rabbitMQ queue. It receives Deliveries via
 inChan and will attempt to process them.
 */
 type RabbitMQWorker struct {
+	repository   database.Repository
 	wg           *sync.WaitGroup
 	inChan       <-chan amqp.Delivery
 	publishQueue PublishQueue
@@ -34,13 +44,15 @@ type RabbitMQWorker struct {
 	httpClient   sharedhttp.Client
 }
 
-func NewRabbitMQWorker(wg *sync.WaitGroup,
+func NewRabbitMQWorker(repo database.Repository,
+	wg *sync.WaitGroup,
 	inChan <-chan amqp.Delivery,
 	publishQueue PublishQueue,
 	cfg sharedconfig.Config,
 	handler messagequeue.DeliveryHandler,
 	httpClient sharedhttp.Client) RabbitMQWorker {
 	return RabbitMQWorker{
+		repository:   repo,
 		wg:           wg,
 		inChan:       inChan,
 		publishQueue: publishQueue,
@@ -51,12 +63,18 @@ func NewRabbitMQWorker(wg *sync.WaitGroup,
 }
 
 //ProcessMessages receives deliveries from its inChan and delegates
-//processing responsibilities to processMessage(delivery amqp.Delivery).
-func (worker RabbitMQWorker) ProcessMessages() {
+//processing responsibilities to processMessage(ctx, delivery amqp.Delivery).
+//It returns once ctx is cancelled, after finishing the delivery it is currently processing.
+//Deliveries are processed using workCtx. If workCtx is cancelled, the current delivery is requeued.
+func (worker RabbitMQWorker) ProcessMessages(ctx, workCtx context.Context) {
 	defer worker.wg.Done()
 	for {
-		delivery := <-worker.inChan
-		worker.processMessage(delivery)
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-worker.inChan:
+			worker.processMessage(workCtx, delivery)
+		}
 	}
 }
 
@@ -64,78 +82,136 @@ func (worker RabbitMQWorker) ProcessMessages() {
 processMesage will process a delivery message.
 
 This will reach out to the create application API of the bank. Once a loan
-application has been created with the bank, this worker will delegate responsibility
-for publishing a message to the Poll Application Service to a repositorys.PublishQueue
+application has been created with the bank, it is marked as submitted in the DB, and this
+worker will delegate responsibility for publishing a message to the Poll Application Service
+to a repositorys.PublishQueue
+
+An application which has been withdrawn is not sent to the bank. If it is withdrawn while it is
+being sent, the submission is still recorded and the poll request published, so that the poll service
+can raise an alert to reconcile it with the bank.
+
+Messages are unmarshalled with the codec for their content type, so either JSON or protobuf messages are accepted,
+and then decoded by sharedmodels.DecodeCreateLoanMessage. V1 messages predate the loan details, so only the
+applicant's name is sent for them. Messages with a version newer than sharedmodels.CreateLoanMessageVersion are dead-lettered.
+
+If an unrecoverable error occurs while processing a message, the message is passed to the
+dead letter queue along with the reason for the failure, and the application is marked as failed.
 
-If an unrecoverable error occurs while processing a message, this function will simulate
-the behaviour of passing a message to a dead letter queue. Note that the DLQ behaviour has
-not yet been implemented due to time constraints.
+The message is processed in a span which continues the trace of the request which created the application.
 */
-func (worker RabbitMQWorker) processMessage(delivery amqp.Delivery) {
-	var message *sharedmodels.CreateLoanMessage
-	err := json.Unmarshal(delivery.Body, &message)
+func (worker RabbitMQWorker) processMessage(ctx context.Context, delivery amqp.Delivery) {
+	ctx, span := messagequeue.StartConsumeSpan(ctx, worker.cfg.CreateApplicationQueueName, delivery)
+	defer span.End()
+
+	// A message published by a newer version of the API gateway is dead-lettered rather than half understood.
+	// The application is not marked as failed, so the message can be replayed once this service is upgraded.
+	envelope, err := messagequeue.UnmarshalEnvelope(delivery)
 	if messagequeue.CheckError(err,
-		fmt.Sprintf("Could not unmarshal message %s to CreateLoanMessage - bad data on queue?", delivery.Body),
+		fmt.Sprintf("Could not unmarshal %s message - bad data on queue?", delivery.ContentType),
+		delivery,
+		worker.handler) {
+		return
+	}
+	message, err := sharedmodels.DecodeCreateLoanMessage(envelope)
+	if messagequeue.CheckError(err,
+		fmt.Sprintf("Could not decode message %s to CreateLoanMessage - bad data on queue?", delivery.Body),
 		delivery,
 		worker.handler) {
 		return
 	}
 
-	/*
-		We generate a new UUID, different from the ApplicationID held in the CreateLoanMessage.
-		This is necessary as we may not be the only publisher to the bank API. So to handle cases
-		where we encounter a collision in IDs, we should generate them here.
-	*/
-	bankApplicationID := uuid.New().String()
+	entry, err := worker.repository.GetApplication(ctx, message.ApplicationID)
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Could not read application before sending it to the bank API", delivery, worker.handler, worker.markFailed(message.ApplicationID)) {
+		return
+	}
+	if entry.Status == sharedmodels.Withdrawn {
+		fmt.Printf("Application %s has been withdrawn, it will not be sent to the bank API\n", message.ApplicationID)
+		worker.handler.Ack(false, delivery)
+		return
+	}
+
+	bankApplicationID := BankApplicationID(message.ApplicationID)
 	loanRequest := models.CreateLoanRequest{
-		ID:        bankApplicationID,
-		FirstName: message.FirstName,
-		LastName:  message.LastName,
+		ID:          bankApplicationID,
+		FirstName:   message.FirstName,
+		LastName:    message.LastName,
+		LoanDetails: message.LoanDetails,
 	}
 
-	resp, err := worker.sendLoanRequest(loanRequest)
+	resp, err := worker.sendLoanRequest(ctx, loanRequest)
 	// Send to DLQ if we cannot contact the bank API. An alternative would be to requeue and try again
-	if messagequeue.CheckError(err, "Could not send loan request to bank API", delivery, worker.handler) {
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Could not send loan request to bank API", delivery, worker.handler, worker.markFailed(message.ApplicationID)) {
 		return
 	}
 
-	finished, err := handleCreateResponse(resp)
+	err = handleCreateResponse(resp)
 	// Send to DLQ if we get an unknwon return code from the bank API.
-	if messagequeue.CheckError(err, "Unknown return code from bank API", delivery, worker.handler) {
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Unknown return code from bank API", delivery, worker.handler, worker.markFailed(message.ApplicationID)) {
 		return
 	}
 
-	if !finished {
-		// Duplicate UUID - re-queue the msg, we will try again with a new UUID
-		worker.handler.Nack(false, true, delivery)
+	// The loan application exists with the bank, either created now or by an earlier delivery of this message
+	err = worker.repository.RecordSubmission(ctx, message.ApplicationID, bankApplicationID, time.Now().UTC())
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Created application but could not mark it as submitted", delivery, worker.handler, worker.markFailed(message.ApplicationID)) {
 		return
 	}
 
-	// We successfully created the loan application
-	err = worker.publishQueue.PublishPollRequest(bankApplicationID, message.ApplicationID)
-	if messagequeue.CheckError(err, "Created application but could not publish to poll queue", delivery, worker.handler) {
+	err = worker.publishQueue.PublishPollRequest(ctx, bankApplicationID, message.ApplicationID, envelope.CorrelationID)
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Created application but could not publish to poll queue", delivery, worker.handler, worker.markFailed(message.ApplicationID)) {
 		return
 	}
 
 	worker.handler.Ack(false, delivery)
 }
 
-func handleCreateResponse(resp *sharedhttp.ClientResponse) (bool, error) {
-	switch resp.StatusCode {
-	case http.StatusCreated:
+/*
+markFailed returns a callback for messagequeue.CheckErrorContextOnDeadLetter, which marks the application as failed
+once its message has been dead-lettered. Marking the application is best effort, as the DB may be the cause of the error.
+*/
+func (worker RabbitMQWorker) markFailed(applicationID string) messagequeue.DeadLetterCallback {
+	return func(ctx context.Context, reason string) error {
+		err := worker.repository.UpdateApplicationStatus(ctx, applicationID, sharedmodels.StatusChange{
+			Status: sharedmodels.Failed,
+			Source: sharedmodels.CreateApplicationService,
+			Reason: reason,
+		})
+		if err != nil {
+			return fmt.Errorf("%w : could not mark application %s as failed", err, applicationID)
+		}
+		return nil
+	}
+}
+
+/*
+BankApplicationID returns the ID used for an application with the bank API.
+
+It is a UUID, different from our applicationID, as we may not be the only publisher to the bank API.
+The UUID is derived from applicationID rather than generated randomly, so that a redelivered message,
+for example after a crash between creating the application and acking the message, uses the same ID
+and cannot create a second application with the bank.
+*/
+func BankApplicationID(applicationID string) string {
+	return uuid.NewSHA1(bankApplicationNamespace, []byte(applicationID)).String()
+}
+
+func handleCreateResponse(resp *sharedhttp.ClientResponse) error {
+	switch {
+	case resp.StatusCode == http.StatusCreated:
 		// Successfully created the loan application
-		return true, nil
-	case http.StatusBadRequest:
-		// Duplicate UUID, we must try again
-		return false, nil
+		return nil
+	case resp.StatusCode == http.StatusBadRequest && strings.Contains(string(resp.ResponseBody), alreadyUsedResponse):
+		// The application was created by an earlier delivery of this message
+		return nil
 	default:
-		return false, errors.New(fmt.Sprintf("Unexpected response code from bank API %d\n", resp.StatusCode))
+		return errors.New(fmt.Sprintf("Unexpected response code from bank API %d\n", resp.StatusCode))
 	}
 }
 
-func (worker RabbitMQWorker) sendLoanRequest(request models.CreateLoanRequest) (*sharedhttp.ClientResponse, error) {
+func (worker RabbitMQWorker) sendLoanRequest(ctx context.Context, request models.CreateLoanRequest) (*sharedhttp.ClientResponse, error) {
 	req, _ := json.Marshal(request)
+	ctx, cancel := context.WithTimeout(ctx, worker.cfg.BankCreateTimeout)
+	defer cancel()
 
 	fmt.Printf("Sending to URL %s\n", worker.cfg.BankCreateURL)
-	return worker.httpClient.Post(worker.cfg.BankCreateURL, contentType, bytes.NewBuffer(req))
+	return worker.httpClient.Post(ctx, worker.cfg.BankCreateURL, contentType, bytes.NewBuffer(req))
 }
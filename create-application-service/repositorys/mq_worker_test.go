@@ -1,12 +1,21 @@
 package repositorys
 
 import (
+	"context"
 	mocks "create-application-service/mocks/repositorys"
+	"create-application-service/models"
 	"encoding/json"
 	"errors"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	sharedhttp2 "service-shared/http"
+	messagequeue "service-shared/message-queue"
+	shareddb "service-shared/mocks/database"
 	sharedhttp "service-shared/mocks/http"
 	sharedmq "service-shared/mocks/message-queue"
 	sharedconfig "service-shared/shared-config"
@@ -16,6 +25,20 @@ import (
 	"time"
 )
 
+func TestProcessMessagesReturnsWhenCancelled(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	inChan := make(chan amqp.Delivery)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := NewRabbitMQWorker(new(shareddb.Repository), wg, inChan, new(mocks.PublishQueue), sharedconfig.Config{}, new(sharedmq.DeliveryHandler), new(sharedhttp.Client))
+
+	cancel()
+	worker.ProcessMessages(ctx, context.Background())
+
+	// Assert that the worker has marked itself as done
+	wg.Wait()
+}
+
 func TestProcessMessageFailsToUnmarshalBody(t *testing.T) {
 	// Setup
 	publishQueue := new(mocks.PublishQueue)
@@ -23,16 +46,16 @@ func TestProcessMessageFailsToUnmarshalBody(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	inChan := make(chan amqp.Delivery)
 	deliveryHandler := new(sharedmq.DeliveryHandler)
-	deliveryHandler.On("Nack", false, false, mock.Anything).Return(nil)
+	deliveryHandler.On("DeadLetter", mock.Anything, mock.Anything).Return(nil)
 
 	// Create worker
-	worker := NewRabbitMQWorker(wg, inChan, publishQueue, cfg, deliveryHandler, new(sharedhttp.Client))
+	worker := NewRabbitMQWorker(new(shareddb.Repository), wg, inChan, publishQueue, cfg, deliveryHandler, new(sharedhttp.Client))
 
 	body := "{invalidjson,"
-	worker.processMessage(getDeliveryWithBody([]byte(body)))
+	worker.processMessage(context.Background(), getDeliveryWithBody([]byte(body)))
 
 	// Assert that the message is sent to DLQ
-	deliveryHandler.AssertCalled(t, "Nack", false, false, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, mock.Anything)
 }
 
 func TestProcessMessageFailsToSendRequestToBank(t *testing.T) {
@@ -43,16 +66,16 @@ func TestProcessMessageFailsToSendRequestToBank(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	inChan := make(chan amqp.Delivery)
 	deliveryHandler := new(sharedmq.DeliveryHandler)
-	deliveryHandler.On("Nack", false, false, delivery).Return(nil)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
 	httpClient := new(sharedhttp.Client)
-	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
 
 	// Create worker
-	worker := NewRabbitMQWorker(wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(getRepository(), wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
 
 	// Assert that the message is sent to the dlq
-	deliveryHandler.AssertCalled(t, "Nack", false, false, delivery)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
 }
 
 func TestProcessMessageUnknownBankStatusCode(t *testing.T) {
@@ -63,20 +86,20 @@ func TestProcessMessageUnknownBankStatusCode(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	inChan := make(chan amqp.Delivery)
 	deliveryHandler := new(sharedmq.DeliveryHandler)
-	deliveryHandler.On("Nack", false, false, delivery).Return(nil)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
 	httpClient := new(sharedhttp.Client)
 	response := &sharedhttp2.ClientResponse{
 		StatusCode:   1,
 		ResponseBody: nil,
 	}
-	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
 
 	// Create worker
-	worker := NewRabbitMQWorker(wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(getRepository(), wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
 
 	// Assert that the message is sent to the dlq
-	deliveryHandler.AssertCalled(t, "Nack", false, false, delivery)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
 }
 
 func TestProcessMessageDuplicateIdStatusCode(t *testing.T) {
@@ -87,20 +110,52 @@ func TestProcessMessageDuplicateIdStatusCode(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	inChan := make(chan amqp.Delivery)
 	deliveryHandler := new(sharedmq.DeliveryHandler)
-	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	httpClient := new(sharedhttp.Client)
 	response := &sharedhttp2.ClientResponse{
 		StatusCode:   400,
-		ResponseBody: nil,
+		ResponseBody: []byte("the application ID is already used.\n"),
 	}
-	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
 
 	// Create worker
-	worker := NewRabbitMQWorker(wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(getRepository(), wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
 
-	// Assert that the message is requeued
-	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+	// Assert that a redelivered message is treated as created, and polled using the same bank ID
+	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, BankApplicationID("Test"), "Test", "")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageBadRequestStatusCode(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	response := &sharedhttp2.ClientResponse{
+		StatusCode:   400,
+		ResponseBody: []byte("unexpected EOF\n"),
+	}
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(getRepository(), wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to the dlq
+	publishQueue.AssertNotCalled(t, "PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestBankApplicationIDIsDeterministic(t *testing.T) {
+	assert.Equal(t, BankApplicationID("62ceaefa5338ed06fe445e18"), BankApplicationID("62ceaefa5338ed06fe445e18"))
+	assert.NotEqual(t, BankApplicationID("62ceaefa5338ed06fe445e18"), BankApplicationID("62ceaefa5338ed06fe445e19"))
 }
 
 func TestProcessMessageFailToPublishToPollQueue(t *testing.T) {
@@ -112,22 +167,22 @@ func TestProcessMessageFailToPublishToPollQueue(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	inChan := make(chan amqp.Delivery)
 	deliveryHandler := new(sharedmq.DeliveryHandler)
-	deliveryHandler.On("Nack", false, false, delivery).Return(nil)
-	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything).Return(errors.New(""))
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
 	httpClient := new(sharedhttp.Client)
 	response := &sharedhttp2.ClientResponse{
 		StatusCode:   201,
 		ResponseBody: nil,
 	}
-	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
 
 	// Create worker
-	worker := NewRabbitMQWorker(wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(getRepository(), wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
 
 	// Assert that the message is sent to DLQ
-	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, mock.Anything)
-	deliveryHandler.AssertCalled(t, "Nack", false, false, delivery)
+	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
 }
 
 func TestProcessMessageAckWhenSuccess(t *testing.T) {
@@ -140,24 +195,329 @@ func TestProcessMessageAckWhenSuccess(t *testing.T) {
 	inChan := make(chan amqp.Delivery)
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	deliveryHandler.On("Ack", false, delivery).Return(nil)
-	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything).Return(nil)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	httpClient := new(sharedhttp.Client)
 	response := &sharedhttp2.ClientResponse{
 		StatusCode:   201,
 		ResponseBody: nil,
 	}
-	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(response, nil)
 
 	// Create worker
-	worker := NewRabbitMQWorker(wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(getRepository(), wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
 
 	// Assert that the message is sent to DLQ
-	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, mock.Anything)
+	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	// Assert the message is ACKd
 	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
 }
 
+func TestProcessMessageMarksApplicationSubmitted(t *testing.T) {
+	delivery := getValidDelivery()
+
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 201}, nil)
+	repository := getRepository()
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the submission is recorded before the application is polled
+	repository.AssertCalled(t, "RecordSubmission", mock.Anything, "Test", BankApplicationID("Test"), mock.Anything)
+	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, BankApplicationID("Test"), "Test", "")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageRecordSubmissionInternalDbError(t *testing.T) {
+	delivery := getValidDelivery()
+
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 201}, nil)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Queued}, nil)
+	repository.On("RecordSubmission", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
+	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ without being polled
+	publishQueue.AssertNotCalled(t, "PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageMarksApplicationFailed(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 500}, nil)
+	repository := getRepository()
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the application is marked as failed, with the reason it was dead-lettered
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	reason := deliveryHandler.Calls[0].Arguments.String(0)
+	repository.AssertCalled(t, "UpdateApplicationStatus", mock.Anything, "Test", sharedmodels.StatusChange{
+		Status: sharedmodels.Failed,
+		Source: sharedmodels.CreateApplicationService,
+		Reason: reason,
+	})
+}
+
+func TestProcessMessageDoesNotMarkFailedWhenCancelled(t *testing.T) {
+	delivery := getValidDelivery()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, context.Canceled)
+	repository := getRepository()
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(ctx, delivery)
+
+	// Assert that the requeued application is left as it was
+	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+	repository.AssertNotCalled(t, "UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageSkipsWithdrawnApplication(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, "Test").Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Withdrawn}, nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the withdrawn application is not sent to the bank
+	httpClient.AssertNotCalled(t, "Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	publishQueue.AssertNotCalled(t, "PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageGetApplicationInternalDbError(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	wg := &sync.WaitGroup{}
+	cfg := sharedconfig.Config{}
+	inChan := make(chan amqp.Delivery)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, wg, inChan, publishQueue, cfg, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ without reaching the bank
+	httpClient.AssertNotCalled(t, "Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageSendsLoanDetails(t *testing.T) {
+	details := sharedmodels.LoanDetails{
+		LoanAmount:   1500000,
+		Currency:     "GBP",
+		TermMonths:   36,
+		Purpose:      sharedmodels.Car,
+		AnnualIncome: 4200000,
+		Email:        "first.last@example.com",
+		DateOfBirth:  "1990-01-31",
+	}
+	delivery := getDeliveryWithMessage(sharedmodels.CreateLoanMessageVersion, sharedmodels.CreateLoanMessage{
+		ApplicationID: "Test",
+		FirstName:     "First",
+		LastName:      "Last",
+		LoanDetails:   details,
+	})
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 201}, nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), publishQueue, sharedconfig.Config{}, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the loan details are sent to the bank, and the poll request continues the correlation
+	var sent models.CreateLoanRequest
+	json.NewDecoder(httpClient.Calls[0].Arguments.Get(3).(io.Reader)).Decode(&sent)
+	assert.Equal(t, BankApplicationID("Test"), sent.ID)
+	assert.Equal(t, details, sent.LoanDetails)
+	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, BankApplicationID("Test"), "Test", "correlation")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageBareMessageSendsNamesOnly(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 201}, nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), publishQueue, sharedconfig.Config{}, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that a bare message, published before envelopes and the loan details existed, is still sent
+	var sent map[string]interface{}
+	json.NewDecoder(httpClient.Calls[0].Arguments.Get(3).(io.Reader)).Decode(&sent)
+	assert.Equal(t, map[string]interface{}{"id": BankApplicationID("Test"), "first_name": "First", "last_name": "Last"}, sent)
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageUnsupportedVersion(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.CreateLoanMessageVersion+1, sharedmodels.CreateLoanMessage{
+		ApplicationID: "Test",
+		FirstName:     "First",
+		LastName:      "Last",
+	})
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	repository := new(shareddb.Repository)
+
+	// Create worker
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, make(chan amqp.Delivery), new(mocks.PublishQueue), sharedconfig.Config{}, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ, and the application is left to be replayed
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	httpClient.AssertNotCalled(t, "Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	repository.AssertNotCalled(t, "UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageProtobufMessage(t *testing.T) {
+	msg := sharedmodels.CreateLoanMessage{ApplicationID: "Test", FirstName: "First", LastName: "Last", LoanDetails: sharedmodels.LoanDetails{LoanAmount: 1500000}}
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.CreateLoanMessageType, sharedmodels.CreateLoanMessageVersion, sharedmodels.APIGatewayService, "correlation", msg)
+	publishing, _ := messagequeue.NewPublishing(messagequeue.ProtobufCodec{}, envelope)
+	delivery := getDeliveryWithBody(publishing.Body)
+	delivery.ContentType = publishing.ContentType
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 201}, nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), publishQueue, sharedconfig.Config{}, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that a protobuf message is handled like a JSON message
+	var sent models.CreateLoanRequest
+	json.NewDecoder(httpClient.Calls[0].Arguments.Get(3).(io.Reader)).Decode(&sent)
+	assert.Equal(t, int64(1500000), sent.LoanAmount)
+	publishQueue.AssertCalled(t, "PublishPollRequest", mock.Anything, BankApplicationID("Test"), "Test", "correlation")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageUnsupportedContentType(t *testing.T) {
+	delivery := getDeliveryWithBody([]byte("<loan/>"))
+	delivery.ContentType = "text/xml"
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+
+	// Create worker
+	worker := NewRabbitMQWorker(new(shareddb.Repository), &sync.WaitGroup{}, make(chan amqp.Delivery), new(mocks.PublishQueue), sharedconfig.Config{}, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	httpClient.AssertNotCalled(t, "Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageContinuesTraceOfDelivery(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	delivery := getValidDelivery()
+	delivery.Headers = amqp.Table{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	// Setup
+	publishQueue := new(mocks.PublishQueue)
+	publishQueue.On("PublishPollRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient := new(sharedhttp.Client)
+	httpClient.On("Post", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&sharedhttp2.ClientResponse{StatusCode: 201}, nil)
+
+	// Create worker
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), publishQueue, sharedconfig.Config{}, deliveryHandler, httpClient)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the request to the bank, and the poll request, are part of the trace of the create request
+	inTrace := mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanContextFromContext(ctx).TraceID().String() == "0af7651916cd43dd8448eb211c80319c"
+	})
+	httpClient.AssertCalled(t, "Post", inTrace, mock.Anything, mock.Anything, mock.Anything)
+	publishQueue.AssertCalled(t, "PublishPollRequest", inTrace, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// getRepository returns a Repository mock which accepts every update of a queued application
+func getRepository() *shareddb.Repository {
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Queued}, nil)
+	repository.On("RecordSubmission", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	return repository
+}
+
+// getValidDelivery returns a delivery of a bare CreateLoanMessage, as published before envelopes and the loan details existed
 func getValidDelivery() amqp.Delivery {
 	msg := sharedmodels.CreateLoanMessage{
 		ApplicationID: "Test",
@@ -169,6 +529,14 @@ func getValidDelivery() amqp.Delivery {
 	return getDeliveryWithBody(bytes)
 }
 
+// getDeliveryWithMessage returns a delivery of msg, in an envelope of the given schema version
+func getDeliveryWithMessage(version int, msg sharedmodels.CreateLoanMessage) amqp.Delivery {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.CreateLoanMessageType, version, sharedmodels.APIGatewayService, "correlation", msg)
+	bytes, _ := json.Marshal(envelope)
+
+	return getDeliveryWithBody(bytes)
+}
+
 func getDeliveryWithBody(body []byte) amqp.Delivery {
 	return amqp.Delivery{
 		Acknowledger:    nil,
@@ -1,58 +1,76 @@
 package repositorys
 
 import (
-	"encoding/json"
+	"context"
 	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
 	sharedconfig "service-shared/shared-config"
 	sharedhelpers "service-shared/shared-helpers"
 	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+	"time"
 )
 
 //PublishQueue defines an interface for interacting with a message queue. Specifically, it provies
-//an abstraction for sending a poll loan request to a message queue.
+//an abstraction for sending a poll loan request to a message queue. The message continues the trace in ctx.
 type PublishQueue interface {
-	PublishPollRequest(bankApplicationID, ourApplicationID string) error
+	PublishPollRequest(ctx context.Context, bankApplicationID, ourApplicationID, correlationID string) error
 }
 
 //RabbitPublishQueue is used to interact with a RabbitMQ message queue.
 type RabbitPublishQueue struct {
-	queue *amqp.Queue
-	ch    *amqp.Channel
-	cfg   sharedconfig.Config
+	queueName string
+	publisher messagequeue.Publisher
+	codec     messagequeue.Codec
+	cfg       sharedconfig.Config
 }
 
-//NewRabbitPublishQueue returns a RabbitPublishQueue struct.
-func NewRabbitPublishQueue(ch *amqp.Channel, cfg sharedconfig.Config) *RabbitPublishQueue {
-	pollQueue, err := ch.QueueDeclare(
-		cfg.PollApplicationQueueName, // name
-		true,                         // durable (survive restarts)
-		false,                        // do not delete when unused
-		false,                        // exclusive
-		false,                        // no-wait
-		nil)                          // args
-
-	sharedhelpers.FailOnError(err, "Publisher failed to declare the poll application queue")
-	return &RabbitPublishQueue{queue: &pollQueue, ch: ch}
+//NewRabbitPublishQueue returns a RabbitPublishQueue struct. It publishes on a channel managed by connection,
+//which declares the poll application queue each time it is opened. Messages are published in the format of
+//cfg.MessageContentType.
+func NewRabbitPublishQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitPublishQueue {
+	codec, err := messagequeue.CodecFor(cfg.MessageContentType)
+	sharedhelpers.FailOnError(err, "Publisher has no codec for the message content type")
+
+	publisher, err := messagequeue.NewReconnectingPublisher(
+		connection,
+		"poll publisher",
+		cfg.PublishConfirmTimeout,
+		func(ch *amqp.Channel) error {
+			_, err := messagequeue.DeclareQueue(ch, cfg.PollApplicationQueueName, cfg.DeadLetterExchangeName)
+			return err
+		})
+	sharedhelpers.FailOnError(err, "Publisher failed to open a channel to RabbitMQ")
+	return &RabbitPublishQueue{queueName: cfg.PollApplicationQueueName, publisher: publisher, codec: codec}
 }
 
 /*
 PublishPollRequest publishes a message to a RabbitMQ queue. This message is intended to be consumed by
 a consumer, which should then negotiate with the jobs API of the bank to determine the status of an application.
+
+The message is published in an Envelope with the correlationID of the create request.
+
+A nil error means that the broker has confirmed the message. Only then is it safe to ack the create request.
 */
-func (queue RabbitPublishQueue) PublishPollRequest(bankApplicationID, ourApplicationID string) error {
-	message := sharedmodels.PollLoanMessage{BankApplicationID: bankApplicationID, OurApplicationID: ourApplicationID}
-	request, _ := json.Marshal(message)
-
-	publishErr := queue.ch.Publish(
-		"",
-		queue.queue.Name,
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         request,
-		})
+func (queue RabbitPublishQueue) PublishPollRequest(ctx context.Context, bankApplicationID, ourApplicationID, correlationID string) error {
+	message := sharedmodels.PollLoanMessage{
+		BankApplicationID: bankApplicationID,
+		OurApplicationID:  ourApplicationID,
+		FirstSeen:         time.Now().UTC(),
+	}
+	envelope, err := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, correlationID, message)
+	if err != nil {
+		return err
+	}
+	publishing, err := messagequeue.NewPublishing(queue.codec, envelope)
+	if err != nil {
+		return err
+	}
+
+	_, span := messagequeue.StartPublishSpan(ctx, queue.queueName, &publishing)
+	publishErr := queue.publisher.Publish("", queue.queueName, publishing)
+	tracing.End(span, publishErr)
 
 	return publishErr
 }
@@ -0,0 +1,154 @@
+//Package commands implements the loanctl commands, which allow an operator to inspect,
+//purge and replay messages which have been dead-lettered by the consumer services.
+package commands
+
+import (
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"strings"
+	"time"
+)
+
+//DeadLetterQueue describes a queue whose dead-lettered messages can be managed by loanctl.
+type DeadLetterQueue struct {
+	// Name is the name of the original queue, messages are replayed to this queue
+	Name string
+	// applicationID extracts our application ID from a message
+	applicationID func(delivery amqp.Delivery) string
+}
+
+//DeadLetterQueues returns the queues managed by loanctl, keyed by the name used on the command line.
+func DeadLetterQueues(cfg sharedconfig.Config) map[string]DeadLetterQueue {
+	return map[string]DeadLetterQueue{
+		"create":  {Name: cfg.CreateApplicationQueueName, applicationID: createLoanApplicationID},
+		"poll":    {Name: cfg.PollApplicationQueueName, applicationID: pollLoanApplicationID},
+		"webhook": {Name: cfg.WebhookQueueName, applicationID: webhookApplicationID},
+	}
+}
+
+//payload returns the JSON payload of a message in a sharedmodels.Envelope, in any format with a messagequeue.Codec.
+//The schema version is not checked, so that messages dead-lettered for being too new can still be listed.
+func payload(delivery amqp.Delivery) []byte {
+	envelope, err := messagequeue.UnmarshalEnvelope(delivery)
+	if err != nil {
+		return nil
+	}
+
+	return envelope.Payload
+}
+
+//printableBody returns the body of delivery, with a protobuf message converted to JSON so that an operator can read it.
+func printableBody(delivery amqp.Delivery) []byte {
+	if delivery.ContentType != messagequeue.ProtobufContentType {
+		return delivery.Body
+	}
+	envelope, err := messagequeue.UnmarshalEnvelope(delivery)
+	if err != nil {
+		return delivery.Body
+	}
+	body, err := messagequeue.JSONCodec{}.Marshal(envelope)
+	if err != nil {
+		return delivery.Body
+	}
+
+	return body
+}
+
+func createLoanApplicationID(delivery amqp.Delivery) string {
+	var message sharedmodels.CreateLoanMessage
+	if err := json.Unmarshal(payload(delivery), &message); err != nil {
+		return ""
+	}
+
+	return message.ApplicationID
+}
+
+func pollLoanApplicationID(delivery amqp.Delivery) string {
+	var message sharedmodels.PollLoanMessage
+	if err := json.Unmarshal(payload(delivery), &message); err != nil {
+		return ""
+	}
+
+	return message.OurApplicationID
+}
+
+func webhookApplicationID(delivery amqp.Delivery) string {
+	var message sharedmodels.WebhookMessage
+	if err := json.Unmarshal(delivery.Body, &message); err != nil {
+		return ""
+	}
+
+	return message.ApplicationID
+}
+
+//DeadLetter represents a dead-lettered message along with the failure metadata recorded in its headers.
+type DeadLetter struct {
+	Delivery      amqp.Delivery
+	ApplicationID string
+	Reason        string
+	Service       string
+	Attempts      int
+	FailedAt      time.Time
+}
+
+func newDeadLetter(queue DeadLetterQueue, delivery amqp.Delivery) DeadLetter {
+	deadLetter := DeadLetter{
+		Delivery:      delivery,
+		ApplicationID: queue.applicationID(delivery),
+		Attempts:      messagequeue.FailureAttempts(delivery),
+	}
+	deadLetter.Reason, _ = delivery.Headers[messagequeue.FailureReasonHeader].(string)
+	deadLetter.Service, _ = delivery.Headers[messagequeue.FailureServiceHeader].(string)
+	deadLetter.FailedAt, _ = delivery.Headers[messagequeue.FailedAtHeader].(time.Time)
+
+	if len(deadLetter.Reason) == 0 {
+		// The message was dead-lettered by the broker, so only the x-death header is available
+		deadLetter.Reason = brokerDeathReason(delivery)
+	}
+
+	return deadLetter
+}
+
+//brokerDeathReason returns the reason recorded by RabbitMQ in the x-death header, eg 'rejected'
+func brokerDeathReason(delivery amqp.Delivery) string {
+	deaths, ok := delivery.Headers["x-death"].([]interface{})
+	if !ok || len(deaths) == 0 {
+		return ""
+	}
+
+	death, ok := deaths[0].(amqp.Table)
+	if !ok {
+		return ""
+	}
+
+	reason, _ := death["reason"].(string)
+	return reason
+}
+
+//Filter selects dead letters. Empty fields match every dead letter.
+type Filter struct {
+	ApplicationID string
+	// Reason matches any dead letter whose failure reason contains it, ignoring case
+	Reason string
+}
+
+//Matches returns true iff deadLetter satisfies every field of the filter.
+func (filter Filter) Matches(deadLetter DeadLetter) bool {
+	if len(filter.ApplicationID) > 0 && filter.ApplicationID != deadLetter.ApplicationID {
+		return false
+	}
+
+	if len(filter.Reason) > 0 && !strings.Contains(strings.ToLower(deadLetter.Reason), strings.ToLower(filter.Reason)) {
+		return false
+	}
+
+	return true
+}
+
+//IsEmpty returns true if the filter matches every dead letter.
+func (filter Filter) IsEmpty() bool {
+	return len(filter.ApplicationID) == 0 && len(filter.Reason) == 0
+}
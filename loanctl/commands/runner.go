@@ -0,0 +1,232 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"io"
+	messagequeue "service-shared/message-queue"
+	"text/tabwriter"
+	"time"
+)
+
+var ErrNoDeadLetters = errors.New("no dead-lettered messages matched the filter")
+
+//Channel represents the operations loanctl performs on an amqp.Channel. It is a wrapper interface to aid testing.
+type Channel interface {
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	QueuePurge(name string, noWait bool) (int, error)
+}
+
+/*
+Runner runs loanctl commands against the dead letter queues.
+
+Dead-lettered messages are read with basic.get and are not acked until a command
+has finished with them. This means that a message which is only listed, or which
+does not match a filter, is returned to its dead letter queue when the command ends.
+As every message read is held unacked and in memory, a command reads at most limit messages.
+*/
+type Runner struct {
+	ch        Channel
+	publisher messagequeue.Publisher
+	out       io.Writer
+	limit     int
+}
+
+//NewRunner returns a Runner. Replayed messages are published using publisher, output is written to out.
+//Each command reads at most limit messages from a dead letter queue.
+func NewRunner(ch Channel, publisher messagequeue.Publisher, out io.Writer, limit int) Runner {
+	return Runner{ch: ch, publisher: publisher, out: out, limit: limit}
+}
+
+//List prints a summary of each dead-lettered message on queue matching filter.
+func (runner Runner) List(queue DeadLetterQueue, filter Filter) error {
+	deadLetters, err := runner.fetch(queue)
+	defer requeue(deadLetters)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(runner.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "APPLICATION ID\tATTEMPTS\tSERVICE\tFAILED AT\tREASON")
+	for _, deadLetter := range deadLetters {
+		if !filter.Matches(deadLetter) {
+			continue
+		}
+
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\n",
+			deadLetter.ApplicationID,
+			deadLetter.Attempts,
+			deadLetter.Service,
+			formatTime(deadLetter.FailedAt),
+			deadLetter.Reason)
+	}
+
+	return writer.Flush()
+}
+
+//Peek prints the headers and payload of up to count dead-lettered messages on queue matching filter.
+func (runner Runner) Peek(queue DeadLetterQueue, filter Filter, count int) error {
+	deadLetters, err := runner.fetch(queue)
+	defer requeue(deadLetters)
+	if err != nil {
+		return err
+	}
+
+	printed := 0
+	for _, deadLetter := range deadLetters {
+		if printed == count {
+			break
+		}
+		if !filter.Matches(deadLetter) {
+			continue
+		}
+
+		fmt.Fprintf(runner.out, "--- %s\n", deadLetter.ApplicationID)
+		for key, value := range deadLetter.Delivery.Headers {
+			fmt.Fprintf(runner.out, "%s: %v\n", key, value)
+		}
+		fmt.Fprintf(runner.out, "\n%s\n\n", printableBody(deadLetter.Delivery))
+		printed++
+	}
+
+	if printed == 0 {
+		return ErrNoDeadLetters
+	}
+
+	return nil
+}
+
+/*
+Purge removes dead-lettered messages matching filter from the dead letter queue of queue.
+If the filter is empty, the whole dead letter queue is purged, however many messages it holds.
+*/
+func (runner Runner) Purge(queue DeadLetterQueue, filter Filter) error {
+	if filter.IsEmpty() {
+		purged, err := runner.ch.QueuePurge(messagequeue.DeadLetterQueueName(queue.Name), false)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(runner.out, "Purged %d messages from %s\n", purged, messagequeue.DeadLetterQueueName(queue.Name))
+		return nil
+	}
+
+	deadLetters, err := runner.fetch(queue)
+	if err != nil {
+		requeue(deadLetters)
+		return err
+	}
+
+	var remaining []DeadLetter
+	purged := 0
+	for _, deadLetter := range deadLetters {
+		if !filter.Matches(deadLetter) {
+			remaining = append(remaining, deadLetter)
+			continue
+		}
+
+		if err := deadLetter.Delivery.Ack(false); err != nil {
+			requeue(remaining)
+			return err
+		}
+		purged++
+	}
+	requeue(remaining)
+
+	fmt.Fprintf(runner.out, "Purged %d messages from %s\n", purged, messagequeue.DeadLetterQueueName(queue.Name))
+	return nil
+}
+
+/*
+Replay republishes dead-lettered messages matching filter to their original queue, and
+removes them from the dead letter queue once the broker has confirmed the republished message.
+
+The failure headers are kept, so if a replayed message fails again its attempt count increases.
+*/
+func (runner Runner) Replay(queue DeadLetterQueue, filter Filter) error {
+	deadLetters, err := runner.fetch(queue)
+	if err != nil {
+		requeue(deadLetters)
+		return err
+	}
+
+	var remaining []DeadLetter
+	defer func() { requeue(remaining) }()
+	replayed := 0
+	for i, deadLetter := range deadLetters {
+		if !filter.Matches(deadLetter) {
+			remaining = append(remaining, deadLetter)
+			continue
+		}
+
+		if err := runner.republish(queue, deadLetter.Delivery); err != nil {
+			remaining = append(remaining, deadLetters[i:]...)
+			return fmt.Errorf("replayed %d messages, could not replay message for application %s : %w",
+				replayed, deadLetter.ApplicationID, err)
+		}
+
+		if err := deadLetter.Delivery.Ack(false); err != nil {
+			// The message has been replayed, but is still on the dead letter queue
+			remaining = append(remaining, deadLetters[i+1:]...)
+			return err
+		}
+		replayed++
+	}
+
+	fmt.Fprintf(runner.out, "Replayed %d messages to %s\n", replayed, queue.Name)
+	return nil
+}
+
+func (runner Runner) republish(queue DeadLetterQueue, delivery amqp.Delivery) error {
+	return runner.publisher.Publish(
+		"",
+		queue.Name,
+		amqp.Publishing{
+			Headers:       delivery.Headers,
+			DeliveryMode:  amqp.Persistent,
+			ContentType:   delivery.ContentType,
+			CorrelationId: delivery.CorrelationId,
+			MessageId:     delivery.MessageId,
+			Timestamp:     delivery.Timestamp,
+			Type:          delivery.Type,
+			Body:          delivery.Body,
+		})
+}
+
+//fetch gets up to runner.limit messages from the dead letter queue of queue without acking them.
+//The caller is responsible for acking or requeueing every returned message.
+func (runner Runner) fetch(queue DeadLetterQueue) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	for len(deadLetters) < runner.limit {
+		delivery, ok, err := runner.ch.Get(messagequeue.DeadLetterQueueName(queue.Name), false)
+		if err != nil {
+			return deadLetters, err
+		}
+		if !ok {
+			return deadLetters, nil
+		}
+
+		deadLetters = append(deadLetters, newDeadLetter(queue, delivery))
+	}
+
+	// There may be more messages, which this command will not see
+	fmt.Fprintf(runner.out, "Read the first %d messages from %s, use -limit to read more\n",
+		runner.limit, messagequeue.DeadLetterQueueName(queue.Name))
+	return deadLetters, nil
+}
+
+//requeue returns dead letters which have been fetched, but not acked, to their dead letter queue
+func requeue(deadLetters []DeadLetter) {
+	for _, deadLetter := range deadLetters {
+		deadLetter.Delivery.Nack(false, true)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
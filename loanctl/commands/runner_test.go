@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	messagequeue "service-shared/message-queue"
+	sharedmq "service-shared/mocks/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"strings"
+	"testing"
+)
+
+// fakeChannel returns its deliveries from Get, and records how each one was settled
+type fakeChannel struct {
+	deliveries []amqp.Delivery
+	acked      map[uint64]bool
+	requeued   map[uint64]bool
+	purged     string
+}
+
+func newFakeChannel(applicationIDs ...string) *fakeChannel {
+	ch := &fakeChannel{acked: map[uint64]bool{}, requeued: map[uint64]bool{}}
+	for i, applicationID := range applicationIDs {
+		body, _ := json.Marshal(sharedmodels.CreateLoanMessage{ApplicationID: applicationID})
+		ch.deliveries = append(ch.deliveries, amqp.Delivery{
+			Acknowledger: ch,
+			DeliveryTag:  uint64(i + 1),
+			Headers:      amqp.Table{messagequeue.FailureReasonHeader: "reason for " + applicationID},
+			Body:         body,
+		})
+	}
+	return ch
+}
+
+func (ch *fakeChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	if len(ch.deliveries) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	delivery := ch.deliveries[0]
+	ch.deliveries = ch.deliveries[1:]
+	return delivery, true, nil
+}
+
+func (ch *fakeChannel) QueuePurge(name string, noWait bool) (int, error) {
+	ch.purged = name
+	return 0, nil
+}
+
+func (ch *fakeChannel) Ack(tag uint64, multiple bool) error {
+	ch.acked[tag] = true
+	return nil
+}
+
+func (ch *fakeChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	ch.requeued[tag] = requeue
+	return nil
+}
+
+func (ch *fakeChannel) Reject(tag uint64, requeue bool) error {
+	ch.requeued[tag] = requeue
+	return nil
+}
+
+func getCreateQueue() DeadLetterQueue {
+	return DeadLetterQueues(sharedconfig.Config{CreateApplicationQueueName: "create_application"})["create"]
+}
+
+func TestWebhookQueueApplicationID(t *testing.T) {
+	queue := DeadLetterQueues(sharedconfig.Config{WebhookQueueName: "webhook_dispatch"})["webhook"]
+	body, _ := json.Marshal(sharedmodels.WebhookMessage{
+		StatusChangedEvent: sharedmodels.StatusChangedEvent{ApplicationID: "abc", Status: sharedmodels.Completed},
+		Attempt:            9,
+	})
+
+	deadLetter := newDeadLetter(queue, amqp.Delivery{Body: body})
+
+	assert.Equal(t, "webhook_dispatch", queue.Name)
+	assert.Equal(t, "abc", deadLetter.ApplicationID)
+}
+
+func TestQueueApplicationIDInEnvelope(t *testing.T) {
+	queue := DeadLetterQueues(sharedconfig.Config{PollApplicationQueueName: "poll_applications"})["poll"]
+	// A message from a newer publisher, which the poll service could not decode
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion+1,
+		sharedmodels.CreateApplicationService, "", sharedmodels.PollLoanMessage{OurApplicationID: "abc"})
+	body, _ := json.Marshal(envelope)
+
+	deadLetter := newDeadLetter(queue, amqp.Delivery{Body: body})
+
+	assert.Equal(t, "abc", deadLetter.ApplicationID)
+}
+
+func TestQueueApplicationIDInProtobufEnvelope(t *testing.T) {
+	queue := getCreateQueue()
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.CreateLoanMessageType, sharedmodels.CreateLoanMessageVersion,
+		sharedmodels.APIGatewayService, "", sharedmodels.CreateLoanMessage{ApplicationID: "abc"})
+	publishing, _ := messagequeue.NewPublishing(messagequeue.ProtobufCodec{}, envelope)
+
+	deadLetter := newDeadLetter(queue, amqp.Delivery{ContentType: publishing.ContentType, Body: publishing.Body})
+
+	assert.Equal(t, "abc", deadLetter.ApplicationID)
+}
+
+func TestPrintableBodyOfProtobufMessage(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "", sharedmodels.PollLoanMessage{OurApplicationID: "abc"})
+	publishing, _ := messagequeue.NewPublishing(messagequeue.ProtobufCodec{}, envelope)
+
+	body := printableBody(amqp.Delivery{ContentType: publishing.ContentType, Body: publishing.Body})
+
+	var printed sharedmodels.Envelope
+	assert.Nil(t, json.Unmarshal(body, &printed))
+	assert.Equal(t, envelope.MessageID, printed.MessageID)
+}
+
+func TestFilterMatches(t *testing.T) {
+	deadLetter := DeadLetter{ApplicationID: "abc", Reason: "Unknown return code from bank API"}
+
+	assert.True(t, Filter{}.Matches(deadLetter))
+	assert.True(t, Filter{ApplicationID: "abc"}.Matches(deadLetter))
+	assert.True(t, Filter{Reason: "bank api"}.Matches(deadLetter))
+	assert.False(t, Filter{ApplicationID: "def"}.Matches(deadLetter))
+	assert.False(t, Filter{ApplicationID: "abc", Reason: "unmarshal"}.Matches(deadLetter))
+}
+
+func TestListRequeuesEveryMessage(t *testing.T) {
+	ch := newFakeChannel("abc", "def")
+	out := &bytes.Buffer{}
+
+	err := NewRunner(ch, new(sharedmq.Publisher), out, 10).List(getCreateQueue(), Filter{ApplicationID: "abc"})
+
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(out.String(), "reason for abc"))
+	assert.False(t, strings.Contains(out.String(), "reason for def"))
+	assert.Equal(t, map[uint64]bool{1: true, 2: true}, ch.requeued)
+	assert.Empty(t, ch.acked)
+}
+
+func TestPurgeWithoutFilterPurgesQueue(t *testing.T) {
+	ch := newFakeChannel()
+
+	err := NewRunner(ch, new(sharedmq.Publisher), &bytes.Buffer{}, 10).Purge(getCreateQueue(), Filter{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "create_application.dlq", ch.purged)
+}
+
+func TestPurgeWithFilterAcksMatches(t *testing.T) {
+	ch := newFakeChannel("abc", "def")
+
+	err := NewRunner(ch, new(sharedmq.Publisher), &bytes.Buffer{}, 10).Purge(getCreateQueue(), Filter{ApplicationID: "def"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[uint64]bool{2: true}, ch.acked)
+	assert.Equal(t, map[uint64]bool{1: true}, ch.requeued)
+}
+
+func TestReplayPublishesToOriginalQueue(t *testing.T) {
+	ch := newFakeChannel("abc", "def")
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", "", "create_application", mock.Anything).Return(nil)
+
+	err := NewRunner(ch, publisher, &bytes.Buffer{}, 10).Replay(getCreateQueue(), Filter{ApplicationID: "abc"})
+
+	assert.Nil(t, err)
+	publisher.AssertNumberOfCalls(t, "Publish", 1)
+	assert.Equal(t, map[uint64]bool{1: true}, ch.acked)
+	assert.Equal(t, map[uint64]bool{2: true}, ch.requeued)
+}
+
+func TestReplayLeavesMessageWhenPublishFails(t *testing.T) {
+	ch := newFakeChannel("abc", "def")
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
+
+	err := NewRunner(ch, publisher, &bytes.Buffer{}, 10).Replay(getCreateQueue(), Filter{})
+
+	assert.NotNil(t, err)
+	assert.Empty(t, ch.acked)
+	assert.Equal(t, map[uint64]bool{1: true, 2: true}, ch.requeued)
+}
+
+func TestListReadsAtMostLimitMessages(t *testing.T) {
+	ch := newFakeChannel("abc", "def", "ghi")
+	out := &bytes.Buffer{}
+
+	err := NewRunner(ch, new(sharedmq.Publisher), out, 2).List(getCreateQueue(), Filter{})
+
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(out.String(), "use -limit to read more"))
+	assert.False(t, strings.Contains(out.String(), "reason for ghi"))
+	// The message which was not read is left on the queue
+	assert.Len(t, ch.deliveries, 1)
+	assert.Equal(t, map[uint64]bool{1: true, 2: true}, ch.requeued)
+}
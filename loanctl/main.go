@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"loanctl/commands"
+	"os"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+)
+
+const usage = `loanctl manages messages which have been dead-lettered by the consumer services.
+
+Usage:
+  loanctl <command> -queue <create|poll|webhook> [-application-id id] [-reason text] [-limit n] [-count n]
+
+Commands:
+  list    Lists the dead-lettered messages, along with why they failed
+  peek    Prints the headers and payload of dead-lettered messages
+  purge   Removes dead-lettered messages. Without a filter, the whole dead letter queue is purged
+  replay  Republishes dead-lettered messages to the queue they were dead-lettered from
+
+Each command reads at most -limit messages (default 1000) from the dead letter queue, and holds them
+unacked until it ends. Peek prints at most -count of them (default 10).
+
+RabbitMQ and the queue names are configured using the same environment variables as the services,
+for example RABBIT_MQ_URL.
+`
+
+/*
+main Runs loanctl, an admin command for the dead letter queues.
+
+Each consumer service dead-letters messages which it cannot process, see
+messagequeue.AmqpDeliveryHandler. This command allows an operator to inspect
+those messages, and once the cause has been fixed, to replay them.
+*/
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	queueName := flags.String("queue", "", "The queue whose dead letters to manage [create, poll, webhook]")
+	applicationID := flags.String("application-id", "", "Only manage dead letters for this application ID")
+	reason := flags.String("reason", "", "Only manage dead letters whose failure reason contains this text")
+	limit := flags.Int("limit", 1000, "The maximum number of messages read from the dead letter queue")
+	count := flags.Int("count", 10, "The maximum number of messages printed by peek")
+	flags.Parse(os.Args[2:])
+	if *limit < 1 {
+		fmt.Fprintf(os.Stderr, "The -limit flag must be at least 1\n")
+		os.Exit(2)
+	}
+
+	cfg := sharedconfig.Get()
+	queue, ok := commands.DeadLetterQueues(cfg)[*queueName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "The -queue flag is required and must be one of [create poll webhook]\n")
+		os.Exit(2)
+	}
+	filter := commands.Filter{ApplicationID: *applicationID, Reason: *reason}
+
+	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	sharedhelpers.FailOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+	ch, err := conn.Channel()
+	sharedhelpers.FailOnError(err, "Failed to open a channel to RabbitMQ")
+	defer ch.Close()
+
+	// Make sure that the queues exist, so that we can read from and replay to them
+	_, err = messagequeue.DeclareQueue(ch, queue.Name, cfg.DeadLetterExchangeName)
+	sharedhelpers.FailOnError(err, "Failed to declare the queue "+queue.Name)
+
+	publishCh, err := conn.Channel()
+	sharedhelpers.FailOnError(err, "Failed to open a channel to RabbitMQ")
+	defer publishCh.Close()
+	publisher, err := messagequeue.NewConfirmingPublisher(publishCh, cfg.PublishConfirmTimeout)
+	sharedhelpers.FailOnError(err, "Failed to put the channel into confirm mode")
+
+	runner := commands.NewRunner(ch, publisher, os.Stdout, *limit)
+	switch command {
+	case "list":
+		err = runner.List(queue, filter)
+	case "peek":
+		err = runner.Peek(queue, filter, *count)
+	case "purge":
+		err = runner.Purge(queue, filter)
+	case "replay":
+		err = runner.Replay(queue, filter)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
+	"math/rand"
 	"net/http"
+	"os/signal"
 	"poll-application-service/repositorys"
 	"service-shared/database"
 	sharedhttp "service-shared/http"
 	messagequeue "service-shared/message-queue"
 	sharedconfig "service-shared/shared-config"
 	helpers "service-shared/shared-helpers"
+	"service-shared/tracing"
 	"sync"
+	"syscall"
+	"time"
 )
 
 /*
@@ -24,38 +30,92 @@ bank API for a given loan application.
 This function spawns parallel workers.
 For implementation details, please see repositorys.mq_worker.
 */
+const serviceName = "poll-application-service"
+
 func main() {
 	cfg := sharedconfig.Get()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// Seed the jitter applied to poll backoffs
+	rand.Seed(time.Now().UnixNano())
+
+	// Record the spans of each message, continuing the trace of the request which created the application
+	shutdownTracing, err := tracing.Init(ctx, cfg, serviceName)
+	helpers.FailOnError(err, "Failed to initialise tracing")
+	defer shutdownTracing()
 
 	fmt.Println("Connecting to db ... ")
-	dbClient, err := database.InitClient(cfg)
+	dbClient, err := database.InitClient(ctx, cfg)
 	helpers.FailOnError(err, "Failed to initialise the db client")
 	defer dbClient.Disconnect(context.Background())
 	collection := dbClient.Database(cfg.DatabaseName).Collection(cfg.DBColletionName)
-	database.InitIndexes(collection)
-	mongo := database.NewMongoCollection(collection)
-	repository := database.NewMongoRepository(mongo)
+	eventOutboxCollection := dbClient.Database(cfg.DatabaseName).Collection(cfg.EventOutboxCollectionName)
+	indexCtx, cancelIndexes := context.WithTimeout(ctx, cfg.DBConnectTimeout)
+	database.InitIndexes(indexCtx, collection)
+	database.InitOutboxIndexes(indexCtx, eventOutboxCollection, cfg.OutboxRetention)
+	cancelIndexes()
+	repository := database.NewMongoRepository(database.Collections{
+		Applications: database.NewMongoCollection(collection),
+		EventOutbox:  database.NewMongoCollection(eventOutboxCollection),
+	}, database.NewMongoTransactor(dbClient), cfg)
 
 	fmt.Println("Connecting to RabbitMQ ... ")
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
-	helpers.FailOnError(err, "Failed to connect to RabbitMQ")
-	defer conn.Close()
+	// The connection, and every channel opened on it, is recovered if it is lost
+	connection := messagequeue.NewConnectionManager(cfg.RabbitMQURL, helpers.Backoff{
+		Base:   cfg.RabbitMQReconnectBase,
+		Max:    cfg.RabbitMQReconnectMax,
+		Jitter: 0.2,
+	})
+	connection.Connect()
+	defer connection.Close()
+
+	// Failed messages are published to the dead letter exchange
+	deadLetterPublisher, err := messagequeue.NewReconnectingPublisher(connection, "dead letter publisher", cfg.PublishConfirmTimeout, nil)
+	helpers.FailOnError(err, "Failed to open a dead letter channel to RabbitMQ")
+
+	// Pending applications are published to wait queues, to be polled again later
+	retryQueue := repositorys.NewRabbitRetryQueue(connection, cfg)
+
+	// Applications which time out are reported on the alert exchange
+	alertQueue := repositorys.NewRabbitAlertQueue(connection, cfg)
+
+	// Decided applications are announced on the status event exchange, for the API gateway to push to clients.
+	// Events are written to the event outbox along with each decision, and relayed to the exchange.
+	eventQueue := repositorys.NewRabbitEventQueue(connection, cfg)
 
 	// Workers to process messages received from the queue
 	fmt.Println("Creating workers to consume from rabbit MQ")
 	in := make(chan amqp.Delivery)
 	wg := &sync.WaitGroup{}
+	relay := repositorys.NewStatusEventRelay(repository, eventQueue, cfg)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		relay.Run(ctx)
+	}()
+	// Work which is still in progress at the shutdown deadline is cancelled, and its message requeued
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
 	maxWorkers := cfg.PollServiceWorkers
-	handler := messagequeue.AmqpDeliveryHandler{}
+	handler := messagequeue.NewAmqpDeliveryHandler(deadLetterPublisher, cfg.DeadLetterExchangeName, cfg.PollApplicationQueueName, serviceName)
 	httpClient := sharedhttp.DefaultClient{HttpClient: http.DefaultClient}
 	wg.Add(maxWorkers)
 	for i := 1; i <= maxWorkers; i++ {
-		worker := repositorys.NewRabbitMQWorker(repository, wg, in, cfg, handler, httpClient)
-		go worker.ProcessMessages()
+		worker := repositorys.NewRabbitMQWorker(repository, wg, in, cfg, handler, httpClient, retryQueue, alertQueue)
+		go worker.ProcessMessages(ctx, workCtx)
 	}
 	// Consumes messages from the queue, passes to in, which is consumed by the workers
-	consumer := messagequeue.NewRabbitMQConsumer(conn, maxWorkers, in, cfg.PollApplicationQueueName)
+	consumer := messagequeue.NewRabbitMQConsumer(connection, maxWorkers, in, cfg.PollApplicationQueueName, cfg.DeadLetterExchangeName)
+	err = consumer.Consume(ctx)
+	helpers.FailOnError(err, "Failed to consume from the poll application queue")
 
-	go consumer.Consume()
-	wg.Wait()
+	// On shutdown, stop consuming and wait for the workers to finish their current message.
+	// Messages which are still unacked when the connection closes are requeued by the broker.
+	<-ctx.Done()
+	fmt.Println("Poll Application service is shutting down ...")
+	time.AfterFunc(cfg.ShutdownTimeout, cancelWork)
+	// Allow the workers a moment to requeue cancelled messages
+	if !helpers.WaitTimeout(wg, cfg.ShutdownTimeout+time.Second) {
+		log.Printf("Timed out waiting for workers to finish, unacked messages will be requeued\n")
+	}
 }
@@ -0,0 +1,56 @@
+package repositorys
+
+import (
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+)
+
+//AlertQueue defines an interface for raising alerts which require the attention of an operator.
+type AlertQueue interface {
+	PublishAlert(alert sharedmodels.AlertMessage) error
+}
+
+/*
+RabbitAlertQueue publishes alerts to a durable fanout exchange. Nothing in this project consumes
+alerts. Monitoring, or an operator, can receive them by binding a queue to the exchange.
+*/
+type RabbitAlertQueue struct {
+	publisher messagequeue.Publisher
+	cfg       sharedconfig.Config
+}
+
+//NewRabbitAlertQueue returns a RabbitAlertQueue. It publishes on a channel managed by connection,
+//which declares the alert exchange each time it is opened.
+func NewRabbitAlertQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitAlertQueue {
+	declare := func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare(
+			cfg.AlertExchangeName, // name
+			"fanout",              // kind
+			true,                  // durable (survive restarts)
+			false,                 // do not delete when unused
+			false,                 // internal
+			false,                 // no-wait
+			nil)                   // args
+	}
+
+	publisher, err := messagequeue.NewReconnectingPublisher(connection, "alert publisher", cfg.PublishConfirmTimeout, declare)
+	sharedhelpers.FailOnError(err, "Failed to open an alert channel to RabbitMQ")
+	return &RabbitAlertQueue{publisher: publisher, cfg: cfg}
+}
+
+//PublishAlert publishes alert to the alert exchange, using the alert type as the routing key.
+func (queue RabbitAlertQueue) PublishAlert(alert sharedmodels.AlertMessage) error {
+	body, _ := json.Marshal(alert)
+	return queue.publisher.Publish(
+		queue.cfg.AlertExchangeName,
+		alert.Type,
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			Body:         body,
+		})
+}
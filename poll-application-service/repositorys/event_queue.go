@@ -0,0 +1,64 @@
+package repositorys
+
+import (
+	"context"
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+)
+
+//EventQueue defines an interface for telling other services that the status of an application has changed.
+type EventQueue interface {
+	PublishStatusChanged(ctx context.Context, event sharedmodels.StatusChangedEvent) error
+}
+
+/*
+RabbitEventQueue publishes status changed events to a durable fanout exchange. The API gateway binds
+a queue to the exchange, and pushes each event to the clients waiting on the application.
+
+The webhook dispatcher also binds a durable queue to the exchange, so events are published as persistent
+messages, and are not lost if RabbitMQ restarts before the dispatcher has sent the webhook.
+*/
+type RabbitEventQueue struct {
+	publisher messagequeue.Publisher
+	cfg       sharedconfig.Config
+}
+
+//NewRabbitEventQueue returns a RabbitEventQueue. It publishes on a channel managed by connection,
+//which declares the status event exchange each time it is opened.
+func NewRabbitEventQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitEventQueue {
+	declare := func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare(
+			cfg.StatusEventExchangeName, // name
+			"fanout",                    // kind
+			true,                        // durable (survive restarts)
+			false,                       // do not delete when unused
+			false,                       // internal
+			false,                       // no-wait
+			nil)                         // args
+	}
+
+	publisher, err := messagequeue.NewReconnectingPublisher(connection, "status event publisher", cfg.PublishConfirmTimeout, declare)
+	sharedhelpers.FailOnError(err, "Failed to open a status event channel to RabbitMQ")
+	return &RabbitEventQueue{publisher: publisher, cfg: cfg}
+}
+
+//PublishStatusChanged publishes event to the status event exchange, in the trace of ctx.
+func (queue RabbitEventQueue) PublishStatusChanged(ctx context.Context, event sharedmodels.StatusChangedEvent) error {
+	body, _ := json.Marshal(event)
+	publishing := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+	}
+
+	_, span := messagequeue.StartPublishSpan(ctx, queue.cfg.StatusEventExchangeName, &publishing)
+	err := queue.publisher.Publish(queue.cfg.StatusEventExchangeName, "", publishing)
+	tracing.End(span, err)
+
+	return err
+}
@@ -0,0 +1,81 @@
+package repositorys
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"service-shared/database"
+	sharedconfig "service-shared/shared-config"
+	"service-shared/tracing"
+	"time"
+)
+
+/*
+StatusEventRelay publishes status changed events, which have been written to the event outbox along with
+the decision of an application, to the status event exchange.
+
+An entry is only marked as sent after it has been published. If the service stops between publishing and
+marking an entry, the event is published again when the relay next runs. This gives at-least-once delivery,
+consumers of the status event exchange must therefore tolerate duplicate events.
+*/
+type StatusEventRelay struct {
+	repository database.Repository
+	eventQueue EventQueue
+	interval   time.Duration
+	batchSize  int
+}
+
+//NewStatusEventRelay returns a StatusEventRelay struct.
+func NewStatusEventRelay(repository database.Repository, eventQueue EventQueue, cfg sharedconfig.Config) *StatusEventRelay {
+	return &StatusEventRelay{
+		repository: repository,
+		eventQueue: eventQueue,
+		interval:   cfg.OutboxRelayInterval,
+		batchSize:  cfg.OutboxRelayBatchSize,
+	}
+}
+
+//Run relays event outbox entries to the status event exchange every interval until ctx is cancelled.
+//It is intended to be run in its own goroutine.
+func (relay StatusEventRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relay.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			relay.relayUnsent(ctx)
+		}
+	}
+}
+
+/*
+relayUnsent publishes a batch of unsent status changed events, oldest first.
+
+If an event cannot be published, the remaining events in the batch are left for the next run so that
+events are published in the order that the applications were decided.
+Each event is published in the trace of the poll which decided the application.
+*/
+func (relay StatusEventRelay) relayUnsent(ctx context.Context) {
+	entries, err := relay.repository.GetUnsentEventOutboxEntries(ctx, relay.batchSize)
+	if err != nil {
+		log.Printf("Status event relay could not read the event outbox %s\n", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if err := relay.eventQueue.PublishStatusChanged(tracing.Extract(ctx, entry.TraceContext), entry.Event); err != nil {
+			log.Printf("Status event relay could not publish entry %s, will retry : %s\n", entry.ID.Hex(), err)
+			return
+		}
+
+		if err := relay.repository.MarkEventOutboxEntrySent(ctx, entry.ID.Hex()); err != nil {
+			// The event will be published again on the next run
+			log.Printf("Status event relay could not mark entry %s as sent : %s\n", entry.ID.Hex(), err)
+			return
+		}
+
+		fmt.Printf("Relayed status changed event %#v\n", entry.Event)
+	}
+}
@@ -0,0 +1,97 @@
+package repositorys
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	mocks "poll-application-service/mocks/repositorys"
+	"service-shared/database"
+	shareddb "service-shared/mocks/database"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+)
+
+func TestRelayUnsentEventsReadError(t *testing.T) {
+	// Setup
+	repository := new(shareddb.Repository)
+	eventQueue := new(mocks.EventQueue)
+	repository.On("GetUnsentEventOutboxEntries", mock.Anything, mock.Anything).Return(nil, database.InternalError)
+
+	relay := NewStatusEventRelay(repository, eventQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	eventQueue.AssertNotCalled(t, "PublishStatusChanged", mock.Anything, mock.Anything)
+}
+
+func TestRelayUnsentEventsPublishesAndMarksSent(t *testing.T) {
+	entries := getEventOutboxEntries(2)
+	// Setup
+	repository := new(shareddb.Repository)
+	eventQueue := new(mocks.EventQueue)
+	repository.On("GetUnsentEventOutboxEntries", mock.Anything, 10).Return(entries, nil)
+	repository.On("MarkEventOutboxEntrySent", mock.Anything, mock.Anything).Return(nil)
+	eventQueue.On("PublishStatusChanged", mock.Anything, mock.Anything).Return(nil)
+
+	relay := NewStatusEventRelay(repository, eventQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	for _, entry := range entries {
+		eventQueue.AssertCalled(t, "PublishStatusChanged", mock.Anything, entry.Event)
+		repository.AssertCalled(t, "MarkEventOutboxEntrySent", mock.Anything, entry.ID.Hex())
+	}
+}
+
+func TestRelayUnsentEventsStopsOnPublishError(t *testing.T) {
+	entries := getEventOutboxEntries(2)
+	// Setup
+	repository := new(shareddb.Repository)
+	eventQueue := new(mocks.EventQueue)
+	repository.On("GetUnsentEventOutboxEntries", mock.Anything, mock.Anything).Return(entries, nil)
+	eventQueue.On("PublishStatusChanged", mock.Anything, mock.Anything).Return(errors.New(""))
+
+	relay := NewStatusEventRelay(repository, eventQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	// The failed event must not be marked as sent, and later events must wait for the next run
+	repository.AssertNotCalled(t, "MarkEventOutboxEntrySent", mock.Anything, mock.Anything)
+	eventQueue.AssertNumberOfCalls(t, "PublishStatusChanged", 1)
+}
+
+func TestRelayUnsentEventsContinuesTraceOfEntry(t *testing.T) {
+	entries := getEventOutboxEntries(1)
+	entries[0].TraceContext = map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// Setup
+	repository := new(shareddb.Repository)
+	eventQueue := new(mocks.EventQueue)
+	repository.On("GetUnsentEventOutboxEntries", mock.Anything, mock.Anything).Return(entries, nil)
+	repository.On("MarkEventOutboxEntrySent", mock.Anything, mock.Anything).Return(nil)
+	eventQueue.On("PublishStatusChanged", mock.Anything, mock.Anything).Return(nil)
+
+	relay := NewStatusEventRelay(repository, eventQueue, sharedconfig.Config{OutboxRelayBatchSize: 10})
+	relay.relayUnsent(context.Background())
+
+	eventQueue.AssertCalled(t, "PublishStatusChanged", mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanContextFromContext(ctx).TraceID().String() == "0af7651916cd43dd8448eb211c80319c"
+	}), mock.Anything)
+}
+
+func getEventOutboxEntries(count int) []sharedmodels.EventOutboxEntry {
+	var entries []sharedmodels.EventOutboxEntry
+	for i := 0; i < count; i++ {
+		entries = append(entries, sharedmodels.EventOutboxEntry{
+			ID: primitive.NewObjectID(),
+			Event: sharedmodels.StatusChangedEvent{
+				ApplicationID: primitive.NewObjectID().Hex(),
+				Status:        sharedmodels.Completed,
+			},
+		})
+	}
+
+	return entries
+}
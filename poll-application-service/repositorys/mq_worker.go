@@ -1,6 +1,7 @@
 package repositorys
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	sharedconfig "service-shared/shared-config"
 	sharedmodels "service-shared/shared-models"
 	"sync"
+	"time"
 )
 
 /*
@@ -28,6 +30,8 @@ type RabbitMQWorker struct {
 	cfg             sharedconfig.Config
 	deliveryHandler messagequeue.DeliveryHandler
 	httpClient      sharedhttp.Client
+	retryQueue      RetryQueue
+	alertQueue      AlertQueue
 }
 
 func NewRabbitMQWorker(
@@ -36,7 +40,9 @@ func NewRabbitMQWorker(
 	inChan <-chan amqp.Delivery,
 	cfg sharedconfig.Config,
 	handler messagequeue.DeliveryHandler,
-	httpClient sharedhttp.Client) RabbitMQWorker {
+	httpClient sharedhttp.Client,
+	retryQueue RetryQueue,
+	alertQueue AlertQueue) RabbitMQWorker {
 	return RabbitMQWorker{
 		repository:      repo,
 		wg:              wg,
@@ -44,16 +50,24 @@ func NewRabbitMQWorker(
 		cfg:             cfg,
 		deliveryHandler: handler,
 		httpClient:      httpClient,
+		retryQueue:      retryQueue,
+		alertQueue:      alertQueue,
 	}
 }
 
 //ProcessMessages receives deliveries from its inChan and delegates
-//processing responsibilities to processMessage(delivery amqp.Delivery).
-func (worker RabbitMQWorker) ProcessMessages() {
+//processing responsibilities to processMessage(ctx, delivery amqp.Delivery).
+//It returns once ctx is cancelled, after finishing the delivery it is currently processing.
+//Deliveries are processed using workCtx. If workCtx is cancelled, the current delivery is requeued.
+func (worker RabbitMQWorker) ProcessMessages(ctx, workCtx context.Context) {
 	defer worker.wg.Done()
 	for {
-		delivery := <-worker.inChan
-		worker.processMessage(delivery)
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-worker.inChan:
+			worker.processMessage(workCtx, delivery)
+		}
 	}
 }
 
@@ -61,36 +75,253 @@ func (worker RabbitMQWorker) ProcessMessages() {
 processMessage will process a delivery message.
 
 This will reach out to the jobs API of the bank. If the status of an application
-is still pending, it will be re-queued to be consumed again later.
+is still pending, it is scheduled to be polled again after an exponential backoff.
+The first time that the bank reports an application as pending, it is marked as polling.
+Once an application has been polled PollMaxAttempts times, or is older than PollMaxAge,
+it is marked as timed out instead and an alert is raised.
 
 If it is finished, ie the status is complete or rejected, then a call will be made
-to update the db with the latest status.
+to update the db with the latest status, and a status changed event is published. If the application has already been decided, or
+otherwise cannot move to the new status, the update is skipped and the message is acked. A late or duplicate poll of an application
+which has already been decided is acked without polling the bank.
+
+An application which has been withdrawn is not polled again. It was withdrawn after it was submitted,
+so it is marked for reconciliation with the bank, which may still decide it, and an alert is raised. The same is
+done for an application which is withdrawn while it is being polled, once the bank decides it or it times out.
+If the alert cannot be published, the message is requeued so that the alert is raised when it is redelivered.
+
+If an unrecoverable error occurs while processing a message, the message is passed to the
+dead letter queue along with the reason for the failure, and the application is marked as failed.
 
-If an unrecoverable error occurs while processing a message, this function will simulate
-the behaviour of passing a message to a dead letter queue. Note that the DLQ behaviour has
-not yet been implemented due to time constraints.
+Each poll is processed in a span which continues the trace of the request which created the application.
 */
-func (worker RabbitMQWorker) processMessage(delivery amqp.Delivery) {
-	body := delivery.Body
+func (worker RabbitMQWorker) processMessage(ctx context.Context, delivery amqp.Delivery) {
+	ctx, span := messagequeue.StartConsumeSpan(ctx, worker.cfg.PollApplicationQueueName, delivery)
+	defer span.End()
 
-	var message *sharedmodels.PollLoanMessage
-	err := json.Unmarshal(body, &message)
+	// A message with a newer schema than this service understands is dead-lettered, so that it can be replayed after an upgrade
+	envelope, err := messagequeue.UnmarshalEnvelope(delivery)
+	if messagequeue.CheckError(
+		err,
+		fmt.Sprintf("Could not unmarshal %s message - bad data on queue?\n", delivery.ContentType),
+		delivery,
+		worker.deliveryHandler) {
+		return
+	}
+	message, err := sharedmodels.DecodePollLoanMessage(envelope)
 	if messagequeue.CheckError(
 		err,
-		fmt.Sprintf("Could not unmarshal message %s to PollLoanMessage - bad data on queue?\n", delivery.Body),
+		fmt.Sprintf("Could not decode message %s to PollLoanMessage - bad data on queue?\n", delivery.Body),
 		delivery,
 		worker.deliveryHandler) {
 		return
 	}
 
-	finished, err := worker.pollApplicationStatus(message.BankApplicationID, message.OurApplicationID)
-	if messagequeue.CheckError(err, "", delivery, worker.deliveryHandler) {
+	entry, err := worker.repository.GetApplication(ctx, message.OurApplicationID)
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Failed to read application before polling", delivery, worker.deliveryHandler, worker.markFailed(message.OurApplicationID)) {
+		return
+	}
+	if entry.Status == sharedmodels.Withdrawn {
+		worker.withdrawn(ctx, message, "", delivery)
+		return
+	}
+	if entry.Status.IsTerminal() {
+		fmt.Printf("Application %s has already been decided as %s, acking\n", message.OurApplicationID, entry.Status)
+		worker.deliveryHandler.Ack(false, delivery)
+		return
+	}
+
+	finished, err := worker.pollApplicationStatus(ctx, message.BankApplicationID, message.OurApplicationID)
+	if worker.withdrawnWhilePolling(ctx, err, message, delivery) {
+		return
+	}
+	if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "", delivery, worker.deliveryHandler, worker.markFailed(message.OurApplicationID)) {
 		// Something went wrong polling the status. Bank API might be down for example
 		return
 	}
 
-	if !finished {
-		// The loan is still pending so requeue
+	if !finished && worker.pollLimitExceeded(message) {
+		// The bank has not resolved the loan in time, so stop polling it
+		err = worker.timeOut(ctx, message)
+		if worker.withdrawnWhilePolling(ctx, err, message, delivery) {
+			return
+		}
+		if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Failed to mark application as timed out", delivery, worker.deliveryHandler, worker.markFailed(message.OurApplicationID)) {
+			return
+		}
+	} else if !finished {
+		// A first poll which is redelivered, after the application was marked as polling, is scheduled again as it was
+		if message.Attempt == 0 && entry.Status != sharedmodels.Polling {
+			err = worker.updateStatus(ctx, message.OurApplicationID, sharedmodels.Polling, "The bank API is deciding the application")
+			if messagequeue.CheckErrorContextOnDeadLetter(ctx, err, "Failed to mark application as polling", delivery, worker.deliveryHandler, worker.markFailed(message.OurApplicationID)) {
+				return
+			}
+		}
+		// The loan is still pending so poll again later
+		worker.schedulePoll(ctx, message, envelope.CorrelationID, delivery)
+		return
+	}
+
+	worker.deliveryHandler.Ack(false, delivery)
+}
+
+/*
+markFailed returns a callback for messagequeue.CheckErrorContextOnDeadLetter, which marks the application as failed
+once its message has been dead-lettered. Marking the application is best effort, as the DB may be the cause of the error.
+*/
+func (worker RabbitMQWorker) markFailed(applicationID string) messagequeue.DeadLetterCallback {
+	return func(ctx context.Context, reason string) error {
+		err := worker.updateStatus(ctx, applicationID, sharedmodels.Failed, reason)
+		if err != nil {
+			return fmt.Errorf("%w : could not mark application %s as failed", err, applicationID)
+		}
+		return nil
+	}
+}
+
+//updateStatus updates the status of an application, recording this service and reason in its history.
+func (worker RabbitMQWorker) updateStatus(ctx context.Context, applicationID string, status sharedmodels.Status, reason string) error {
+	return worker.repository.UpdateApplicationStatus(ctx, applicationID, sharedmodels.StatusChange{
+		Status: status,
+		Source: sharedmodels.PollApplicationService,
+		Reason: reason,
+	})
+}
+
+/*
+alreadyDecided returns true if err, returned by decide, is a *sharedmodels.TransitionError. This means that the
+application has already moved on, for example because this is a duplicate poll of an application which was decided
+while it was being polled. There is nothing left to do for the delivery, so it can be acked.
+
+An application which was withdrawn while it was being polled must still be reconciled, so its error is
+left for withdrawnWhilePolling.
+*/
+func alreadyDecided(err error, applicationID string) bool {
+	var transitionErr *sharedmodels.TransitionError
+	if errors.As(err, &transitionErr) && transitionErr.From != sharedmodels.Withdrawn {
+		fmt.Printf("Application %s has already been decided : %s\n", applicationID, transitionErr)
+		return true
+	}
+
+	return false
+}
+
+/*
+decide updates an application to a terminal status. A status changed event is written to the event outbox in the
+same transaction, so that clients waiting on the application are told once StatusEventRelay publishes it.
+
+The event is never lost once the decision has been recorded, even if this service stops or RabbitMQ is unavailable.
+*/
+func (worker RabbitMQWorker) decide(ctx context.Context, applicationID string, status sharedmodels.Status, reason string) error {
+	return worker.repository.UpdateApplicationStatusWithEvent(ctx, applicationID, sharedmodels.StatusChange{
+		Status: status,
+		Source: sharedmodels.PollApplicationService,
+		Reason: reason,
+	})
+}
+
+//pollLimitExceeded returns true if an application should not be polled again, including the poll just made.
+func (worker RabbitMQWorker) pollLimitExceeded(message sharedmodels.PollLoanMessage) bool {
+	if worker.cfg.PollMaxAttempts > 0 && message.Attempt+1 >= worker.cfg.PollMaxAttempts {
+		return true
+	}
+
+	// Messages published before FirstSeen was introduced do not have it set
+	if worker.cfg.PollMaxAge > 0 && !message.FirstSeen.IsZero() && time.Since(message.FirstSeen) >= worker.cfg.PollMaxAge {
+		return true
+	}
+
+	return false
+}
+
+/*
+timeOut marks an application as timed out, publishes a status changed event and raises an alert.
+
+The alert is best effort. The status has already been updated by the time it is published, so
+failing to publish it is logged rather than returned.
+*/
+func (worker RabbitMQWorker) timeOut(ctx context.Context, message sharedmodels.PollLoanMessage) error {
+	reason := "The bank did not resolve the application within the poll limits"
+	err := worker.decide(ctx, message.OurApplicationID, sharedmodels.TimedOut, fmt.Sprintf("%s, after %d polls", reason, message.Attempt+1))
+	if alreadyDecided(err, message.OurApplicationID) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Encountered an error updating status in DB %s\n", err)
+		return err
+	}
+	fmt.Printf("Marked application %s as %s after %d polls\n", message.OurApplicationID, sharedmodels.TimedOut, message.Attempt+1)
+
+	err = worker.alertQueue.PublishAlert(sharedmodels.AlertMessage{
+		Type:              sharedmodels.ApplicationTimedOutAlert,
+		OurApplicationID:  message.OurApplicationID,
+		BankApplicationID: message.BankApplicationID,
+		Attempts:          message.Attempt + 1,
+		FirstSeen:         message.FirstSeen,
+		Reason:            reason,
+		RaisedAt:          time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish timed out alert for application %s : %s\n", message.OurApplicationID, err)
+	}
+
+	return nil
+}
+
+/*
+withdrawnWhilePolling returns true if err, returned by decide, is a *sharedmodels.TransitionError from withdrawn.
+The application was withdrawn while it was being polled, so it is handled by withdrawn. If the bank decided the
+application, its decision is recorded for the reconciliation.
+*/
+func (worker RabbitMQWorker) withdrawnWhilePolling(ctx context.Context, err error, message sharedmodels.PollLoanMessage, delivery amqp.Delivery) bool {
+	var transitionErr *sharedmodels.TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != sharedmodels.Withdrawn {
+		return false
+	}
+
+	var bankDecision sharedmodels.Status
+	if transitionErr.To != sharedmodels.TimedOut {
+		bankDecision = transitionErr.To
+	}
+	worker.withdrawn(ctx, message, bankDecision, delivery)
+	return true
+}
+
+/*
+withdrawn stops polling an application which has been withdrawn. The application is marked for reconciliation
+with the bank, and an alert is raised so that an operator reconciles it. bankDecision is the status that the bank
+gave the application, or empty if the bank has not decided it.
+
+The delivery is only acked once the alert has been published. If it cannot be, the delivery is requeued, and
+the application is marked and the alert raised again when it is redelivered.
+*/
+func (worker RabbitMQWorker) withdrawn(ctx context.Context, message sharedmodels.PollLoanMessage, bankDecision sharedmodels.Status, delivery amqp.Delivery) {
+	fmt.Printf("Application %s has been withdrawn, it will not be polled again\n", message.OurApplicationID)
+	reason := "The application was withdrawn after it was submitted to the bank"
+	if len(bankDecision) > 0 {
+		reason = fmt.Sprintf("%s, which then decided it as %s", reason, bankDecision)
+	}
+
+	err := worker.repository.RecordReconciliation(ctx, message.OurApplicationID, sharedmodels.Reconciliation{
+		Reason:       reason,
+		BankDecision: bankDecision,
+		RequiredAt:   time.Now().UTC(),
+	})
+	if messagequeue.CheckErrorContext(ctx, err, "Failed to mark withdrawn application for reconciliation", delivery, worker.deliveryHandler) {
+		return
+	}
+
+	err = worker.alertQueue.PublishAlert(sharedmodels.AlertMessage{
+		Type:              sharedmodels.ApplicationWithdrawnAlert,
+		OurApplicationID:  message.OurApplicationID,
+		BankApplicationID: message.BankApplicationID,
+		Attempts:          message.Attempt,
+		FirstSeen:         message.FirstSeen,
+		Reason:            reason,
+		RaisedAt:          time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish withdrawn alert for application %s, requeueing : %s\n", message.OurApplicationID, err)
 		worker.deliveryHandler.Nack(false, true, delivery)
 		return
 	}
@@ -98,15 +329,32 @@ func (worker RabbitMQWorker) processMessage(delivery amqp.Delivery) {
 	worker.deliveryHandler.Ack(false, delivery)
 }
 
-func (worker RabbitMQWorker) pollApplicationStatus(bankAppID string, ourApplicationID string) (bool, error) {
+//schedulePoll schedules the next poll of a pending application, then acks the current delivery.
+func (worker RabbitMQWorker) schedulePoll(ctx context.Context, message sharedmodels.PollLoanMessage, correlationID string, delivery amqp.Delivery) {
+	message.Attempt++
+	if message.FirstSeen.IsZero() {
+		message.FirstSeen = time.Now().UTC()
+	}
+	err := worker.retryQueue.SchedulePoll(ctx, message, correlationID)
+	if err != nil {
+		// Fall back to requeueing, so that the application is not lost
+		log.Printf("Failed to schedule a poll of application %s, requeueing : %s\n", message.OurApplicationID, err)
+		worker.deliveryHandler.Nack(false, true, delivery)
+		return
+	}
+
+	worker.deliveryHandler.Ack(false, delivery)
+}
+
+func (worker RabbitMQWorker) pollApplicationStatus(ctx context.Context, bankAppID string, ourApplicationID string) (bool, error) {
 	pollRequest := models.PollLoanRequest{ApplicationID: bankAppID}
-	resp, err := worker.sendPollRequest(pollRequest)
+	resp, err := worker.sendPollRequest(ctx, pollRequest)
 	if err != nil {
 		log.Printf("Failed to send request to bank API %s\n", err)
 		return false, err
 	}
 
-	finished, err := worker.handleResponse(resp, ourApplicationID)
+	finished, err := worker.handleResponse(ctx, resp, ourApplicationID)
 	if err != nil {
 		return false, err
 	}
@@ -115,14 +363,17 @@ func (worker RabbitMQWorker) pollApplicationStatus(bankAppID string, ourApplicat
 
 }
 
-func (worker RabbitMQWorker) sendPollRequest(request models.PollLoanRequest) (*sharedhttp.ClientResponse, error) {
-	return worker.httpClient.Get(worker.cfg.BankJobsURL + request.ApplicationID)
+func (worker RabbitMQWorker) sendPollRequest(ctx context.Context, request models.PollLoanRequest) (*sharedhttp.ClientResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, worker.cfg.BankPollTimeout)
+	defer cancel()
+
+	return worker.httpClient.Get(ctx, worker.cfg.BankJobsURL+request.ApplicationID)
 }
 
-func (worker RabbitMQWorker) handleResponse(resp *sharedhttp.ClientResponse, ourApplicationID string) (bool, error) {
+func (worker RabbitMQWorker) handleResponse(ctx context.Context, resp *sharedhttp.ClientResponse, ourApplicationID string) (bool, error) {
 	switch resp.StatusCode {
 	case http.StatusOK:
-		return worker.handleOkResponse(resp, ourApplicationID)
+		return worker.handleOkResponse(ctx, resp, ourApplicationID)
 	case http.StatusBadRequest:
 		return false, errors.New(fmt.Sprintf("Received bad request from bank API : %s", resp.ResponseBody))
 	case http.StatusNotFound:
@@ -132,7 +383,7 @@ func (worker RabbitMQWorker) handleResponse(resp *sharedhttp.ClientResponse, our
 	}
 }
 
-func (worker RabbitMQWorker) handleOkResponse(resp *sharedhttp.ClientResponse, ourApplicationID string) (bool, error) {
+func (worker RabbitMQWorker) handleOkResponse(ctx context.Context, resp *sharedhttp.ClientResponse, ourApplicationID string) (bool, error) {
 	status, err := getStatusFromResponse(resp)
 	if err != nil {
 		log.Printf("Could not unmarshal response from bank API %s\n", err)
@@ -141,7 +392,10 @@ func (worker RabbitMQWorker) handleOkResponse(resp *sharedhttp.ClientResponse, o
 
 	if isTerminalStatus(status) {
 		// Update the database
-		err = worker.repository.UpdateApplicationStatus(ourApplicationID, sharedmodels.Status(status))
+		err = worker.decide(ctx, ourApplicationID, sharedmodels.Status(status), "Decided by the bank API")
+		if alreadyDecided(err, ourApplicationID) {
+			return true, nil
+		}
 		if err != nil {
 			log.Printf("Encountered an error updating status in DB %s\n", err)
 			return false, err
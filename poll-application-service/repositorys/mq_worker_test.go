@@ -1,12 +1,18 @@
 package repositorys
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	mocks "poll-application-service/mocks/repositorys"
 	"poll-application-service/models"
 	"service-shared/http"
+	messagequeue "service-shared/message-queue"
 	shareddb "service-shared/mocks/database"
 	sharedhttp "service-shared/mocks/http"
 	sharedmq "service-shared/mocks/message-queue"
@@ -17,6 +23,20 @@ import (
 	"time"
 )
 
+func TestProcessMessagesReturnsWhenCancelled(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	inChan := make(chan amqp.Delivery)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := NewRabbitMQWorker(new(shareddb.Repository), wg, inChan, sharedconfig.Config{}, new(sharedmq.DeliveryHandler), new(sharedhttp.Client), new(mocks.RetryQueue), new(mocks.AlertQueue))
+
+	cancel()
+	worker.ProcessMessages(ctx, context.Background())
+
+	// Assert that the worker has marked itself as done
+	wg.Wait()
+}
+
 func TestProcessMessageFailsToUnmarshalBody(t *testing.T) {
 	// Setup
 	wg := &sync.WaitGroup{}
@@ -24,15 +44,15 @@ func TestProcessMessageFailsToUnmarshalBody(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	httpClient := new(sharedhttp.Client)
-	repository := new(shareddb.Repository)
-	deliveryHandler.On("Nack", false, false, mock.Anything).Return(nil)
+	repository := getRepository()
+	deliveryHandler.On("DeadLetter", mock.Anything, mock.Anything).Return(nil)
 	body := "{invalidjson,"
 
-	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient)
-	worker.processMessage(getDeliveryWithBody([]byte(body)))
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), getDeliveryWithBody([]byte(body)))
 
 	// Assert that the message is sent to DLQ
-	deliveryHandler.AssertCalled(t, "Nack", false, false, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, mock.Anything)
 }
 
 func TestProcessMessageErrorPolling(t *testing.T) {
@@ -43,16 +63,38 @@ func TestProcessMessageErrorPolling(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	httpClient := new(sharedhttp.Client)
-	repository := new(shareddb.Repository)
-	deliveryHandler.On("Nack", false, false, delivery).Return(nil)
-	httpClient.On("Get", mock.Anything).Return(nil, errors.New(""))
+	repository := getRepository()
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(nil, errors.New(""))
 
-	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
 
-	httpClient.AssertCalled(t, "Get", mock.Anything)
+	httpClient.AssertCalled(t, "Get", mock.Anything, mock.Anything)
 	// Assert that the message is sent to DLQ
-	deliveryHandler.AssertCalled(t, "Nack", false, false, delivery)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageRequeuesWhenCancelled(t *testing.T) {
+	delivery := getValidDelivery()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(nil, context.Canceled)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(ctx, delivery)
+
+	// Assert that the message is re-queued rather than sent to the DLQ
+	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+	deliveryHandler.AssertNotCalled(t, "DeadLetter", mock.Anything, mock.Anything)
 }
 
 func TestProcessMessageLoanPending(t *testing.T) {
@@ -63,16 +105,125 @@ func TestProcessMessageLoanPending(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	httpClient := new(sharedhttp.Client)
-	repository := new(shareddb.Repository)
+	repository := getRepository()
+	retryQueue := new(mocks.RetryQueue)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	httpClient.AssertCalled(t, "Get", mock.Anything, mock.Anything)
+	// Assert that the next poll is scheduled, and the message is ack'd
+	retryQueue.AssertCalled(t, "SchedulePoll", mock.Anything, mock.MatchedBy(func(message sharedmodels.PollLoanMessage) bool {
+		return message.OurApplicationID == "abc" && message.Attempt == 1 && !message.FirstSeen.IsZero()
+	}), "correlation")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageLoanPendingScheduleError(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	retryQueue := new(mocks.RetryQueue)
 	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
-	httpClient.On("Get", mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
 
-	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
 
-	httpClient.AssertCalled(t, "Get", mock.Anything)
 	// Assert that the message is re-queued
 	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+	deliveryHandler.AssertNotCalled(t, "Ack", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageLoanPendingMaxAttempts(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{
+		OurApplicationID:  "abc",
+		BankApplicationID: "def",
+		Attempt:           4,
+		FirstSeen:         time.Now(),
+	})
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{PollMaxAttempts: 5, PollMaxAge: time.Hour}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	retryQueue := new(mocks.RetryQueue)
+	alertQueue := new(mocks.AlertQueue)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, "abc", isStatusChange(sharedmodels.TimedOut)).Return(nil)
+	alertQueue.On("PublishAlert", mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the application is timed out, an alert is raised, and the message is ack'd
+	repository.AssertCalled(t, "UpdateApplicationStatusWithEvent", mock.Anything, "abc", isStatusChange(sharedmodels.TimedOut))
+	alertQueue.AssertCalled(t, "PublishAlert", mock.MatchedBy(func(alert sharedmodels.AlertMessage) bool {
+		return alert.Type == sharedmodels.ApplicationTimedOutAlert && alert.OurApplicationID == "abc" && alert.Attempts == 5
+	}))
+	retryQueue.AssertNotCalled(t, "SchedulePoll", mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageLoanPendingMaxAge(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{
+		OurApplicationID:  "abc",
+		BankApplicationID: "def",
+		FirstSeen:         time.Now().Add(-2 * time.Hour),
+	})
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{PollMaxAttempts: 5, PollMaxAge: time.Hour}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	alertQueue := new(mocks.AlertQueue)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, "abc", isStatusChange(sharedmodels.TimedOut)).Return(nil)
+	// A failed alert does not stop the message being ack'd
+	alertQueue.On("PublishAlert", mock.Anything).Return(errors.New(""))
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	repository.AssertCalled(t, "UpdateApplicationStatusWithEvent", mock.Anything, "abc", isStatusChange(sharedmodels.TimedOut))
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageTimeOutInternalDbError(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 4})
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{PollMaxAttempts: 5}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	alertQueue := new(mocks.AlertQueue)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ, without raising an alert
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	alertQueue.AssertNotCalled(t, "PublishAlert", mock.Anything)
 }
 
 func TestProcessMessageLoanCompleted(t *testing.T) {
@@ -83,17 +234,19 @@ func TestProcessMessageLoanCompleted(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	httpClient := new(sharedhttp.Client)
-	repository := new(shareddb.Repository)
+	repository := getRepository()
 	deliveryHandler.On("Ack", false, delivery).Return(nil)
-	httpClient.On("Get", mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Completed)), nil)
-	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Completed)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
-	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
 
-	httpClient.AssertCalled(t, "Get", mock.Anything)
+	httpClient.AssertCalled(t, "Get", mock.Anything, mock.Anything)
 	// Assert that the message is ack'd
 	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	// Assert that the decision is recorded along with the event which tells clients waiting on the application
+	repository.AssertCalled(t, "UpdateApplicationStatusWithEvent", mock.Anything, "abc", isStatusChange(sharedmodels.Completed))
 }
 
 func TestProcessMessageLoanRejected(t *testing.T) {
@@ -104,20 +257,20 @@ func TestProcessMessageLoanRejected(t *testing.T) {
 	cfg := sharedconfig.Config{}
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	httpClient := new(sharedhttp.Client)
-	repository := new(shareddb.Repository)
+	repository := getRepository()
 	deliveryHandler.On("Ack", false, delivery).Return(nil)
-	httpClient.On("Get", mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Rejected)), nil)
-	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Rejected)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
-	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
 
-	httpClient.AssertCalled(t, "Get", mock.Anything)
+	httpClient.AssertCalled(t, "Get", mock.Anything, mock.Anything)
 	// Assert that the message is ack'd
 	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
 }
 
-func TestProcessMessageInternalDbError(t *testing.T) {
+func TestProcessMessageAlreadyDecided(t *testing.T) {
 	delivery := getValidDelivery()
 	// Setup
 	wg := &sync.WaitGroup{}
@@ -126,16 +279,341 @@ func TestProcessMessageInternalDbError(t *testing.T) {
 	deliveryHandler := new(sharedmq.DeliveryHandler)
 	httpClient := new(sharedhttp.Client)
 	repository := new(shareddb.Repository)
-	deliveryHandler.On("Nack", false, false, delivery).Return(nil)
-	httpClient.On("Get", mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Rejected)), nil)
-	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything).Return(errors.New(""))
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Polling}, nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Rejected)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, mock.Anything, mock.Anything).Return(
+		&sharedmodels.TransitionError{ApplicationID: "abc", From: sharedmodels.Completed, To: sharedmodels.Rejected})
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// A duplicate poll is acked, and the application is not marked as failed
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	deliveryHandler.AssertNotCalled(t, "DeadLetter", mock.Anything, mock.Anything)
+	repository.AssertNumberOfCalls(t, "UpdateApplicationStatusWithEvent", 1)
+}
+
+func TestProcessMessageLoanPendingAlreadyDecided(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	retryQueue := new(mocks.RetryQueue)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Completed}, nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// A decided application is not polled again
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	httpClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+	retryQueue.AssertNotCalled(t, "SchedulePoll", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageRedeliveredFirstPollSchedulesPoll(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	retryQueue := new(mocks.RetryQueue)
+	repository := new(shareddb.Repository)
+	// The first poll was requeued after the application had been marked as polling
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Polling}, nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp("pending"), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the application is polled again, without marking it as polling a second time
+	repository.AssertNotCalled(t, "UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything)
+	retryQueue.AssertCalled(t, "SchedulePoll", mock.Anything, mock.MatchedBy(func(message sharedmodels.PollLoanMessage) bool {
+		return message.Attempt == 1
+	}), mock.Anything)
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	deliveryHandler.AssertNotCalled(t, "DeadLetter", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageLoanPendingMarksPolling(t *testing.T) {
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	retryQueue := new(mocks.RetryQueue)
+	deliveryHandler.On("Ack", mock.Anything, mock.Anything).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def"}))
+	worker.processMessage(context.Background(), getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 1}))
+
+	// Assert that the application is only marked as polling on its first poll
+	repository.AssertCalled(t, "UpdateApplicationStatus", mock.Anything, "abc", isStatusChange(sharedmodels.Polling))
+	repository.AssertNumberOfCalls(t, "UpdateApplicationStatus", 1)
+	retryQueue.AssertNumberOfCalls(t, "SchedulePoll", 2)
+}
+
+func TestProcessMessageMarksApplicationFailed(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(&http.ClientResponse{StatusCode: 404}, nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the application is marked as failed, with the reason it was dead-lettered
+	reason := deliveryHandler.Calls[0].Arguments.String(0)
+	repository.AssertCalled(t, "UpdateApplicationStatus", mock.Anything, "abc", mock.MatchedBy(func(change sharedmodels.StatusChange) bool {
+		return change.Status == sharedmodels.Failed && change.Reason == reason
+	}))
+}
+
+func TestProcessMessageDoesNotMarkFailedWhenCancelled(t *testing.T) {
+	delivery := getValidDelivery()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(nil, context.Canceled)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(ctx, delivery)
+
+	// Assert that the requeued application is left as it was
+	repository.AssertNotCalled(t, "UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything)
+}
 
-	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient)
-	worker.processMessage(delivery)
+func TestProcessMessageInternalDbError(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := getRepository()
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Rejected)), nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, mock.Anything, mock.Anything).Return(errors.New(""))
 
-	httpClient.AssertCalled(t, "Get", mock.Anything)
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	httpClient.AssertCalled(t, "Get", mock.Anything, mock.Anything)
 	// Assert that the message is sent to DLQ
-	deliveryHandler.AssertCalled(t, "Nack", false, false, delivery)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageWithdrawnApplication(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 2})
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	retryQueue := new(mocks.RetryQueue)
+	alertQueue := new(mocks.AlertQueue)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, "abc").Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Withdrawn}, nil)
+	repository.On("RecordReconciliation", mock.Anything, "abc", mock.Anything).Return(nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	alertQueue.On("PublishAlert", mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, retryQueue, alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the bank is not polled, the application is marked and an alert raised to reconcile it, and the message is ack'd
+	httpClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+	repository.AssertCalled(t, "RecordReconciliation", mock.Anything, "abc", mock.MatchedBy(func(reconciliation sharedmodels.Reconciliation) bool {
+		return len(reconciliation.BankDecision) == 0 && !reconciliation.RequiredAt.IsZero()
+	}))
+	alertQueue.AssertCalled(t, "PublishAlert", mock.MatchedBy(func(alert sharedmodels.AlertMessage) bool {
+		return alert.Type == sharedmodels.ApplicationWithdrawnAlert && alert.OurApplicationID == "abc" && alert.BankApplicationID == "def"
+	}))
+	retryQueue.AssertNotCalled(t, "SchedulePoll", mock.Anything, mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageWithdrawnAlertFails(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 2})
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	alertQueue := new(mocks.AlertQueue)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, "abc").Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Withdrawn}, nil)
+	repository.On("RecordReconciliation", mock.Anything, "abc", mock.Anything).Return(nil)
+	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	alertQueue.On("PublishAlert", mock.Anything).Return(errors.New(""))
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, new(sharedhttp.Client), new(mocks.RetryQueue), alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is requeued, so that the alert is raised when it is redelivered
+	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+	deliveryHandler.AssertNotCalled(t, "Ack", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageWithdrawnReconciliationNotRecorded(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 2})
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	alertQueue := new(mocks.AlertQueue)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, "abc").Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Withdrawn}, nil)
+	repository.On("RecordReconciliation", mock.Anything, "abc", mock.Anything).Return(errors.New(""))
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, new(sharedhttp.Client), new(mocks.RetryQueue), alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	alertQueue.AssertNotCalled(t, "PublishAlert", mock.Anything)
+}
+
+func TestProcessMessageDecidedAfterWithdrawn(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 2})
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	alertQueue := new(mocks.AlertQueue)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, "abc").Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Polling}, nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, "abc", mock.Anything).Return(
+		&sharedmodels.TransitionError{ApplicationID: "abc", From: sharedmodels.Withdrawn, To: sharedmodels.Completed})
+	repository.On("RecordReconciliation", mock.Anything, "abc", mock.Anything).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Completed)), nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	alertQueue.On("PublishAlert", mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, httpClient, new(mocks.RetryQueue), alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the decision of the bank is recorded for the reconciliation, and an alert raised
+	repository.AssertCalled(t, "RecordReconciliation", mock.Anything, "abc", mock.MatchedBy(func(reconciliation sharedmodels.Reconciliation) bool {
+		return reconciliation.BankDecision == sharedmodels.Completed
+	}))
+	alertQueue.AssertCalled(t, "PublishAlert", mock.MatchedBy(func(alert sharedmodels.AlertMessage) bool {
+		return alert.Type == sharedmodels.ApplicationWithdrawnAlert && alert.OurApplicationID == "abc"
+	}))
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	deliveryHandler.AssertNotCalled(t, "DeadLetter", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageTimedOutAfterWithdrawn(t *testing.T) {
+	delivery := getDeliveryWithMessage(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 4})
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	alertQueue := new(mocks.AlertQueue)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, "abc").Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Polling}, nil)
+	repository.On("UpdateApplicationStatusWithEvent", mock.Anything, "abc", mock.Anything).Return(
+		&sharedmodels.TransitionError{ApplicationID: "abc", From: sharedmodels.Withdrawn, To: sharedmodels.TimedOut})
+	repository.On("RecordReconciliation", mock.Anything, "abc", mock.Anything).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	alertQueue.On("PublishAlert", mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{PollMaxAttempts: 5}, deliveryHandler, httpClient, new(mocks.RetryQueue), alertQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that a withdrawn alert is raised rather than a timed out alert, as the bank has not decided the application
+	repository.AssertCalled(t, "RecordReconciliation", mock.Anything, "abc", mock.MatchedBy(func(reconciliation sharedmodels.Reconciliation) bool {
+		return len(reconciliation.BankDecision) == 0
+	}))
+	alertQueue.AssertCalled(t, "PublishAlert", mock.MatchedBy(func(alert sharedmodels.AlertMessage) bool {
+		return alert.Type == sharedmodels.ApplicationWithdrawnAlert
+	}))
+	alertQueue.AssertNumberOfCalls(t, "PublishAlert", 1)
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageGetApplicationInternalDbError(t *testing.T) {
+	delivery := getValidDelivery()
+	// Setup
+	wg := &sync.WaitGroup{}
+	inChan := make(chan amqp.Delivery)
+	cfg := sharedconfig.Config{}
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything, isStatusChange(sharedmodels.Failed)).Return(nil)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, wg, inChan, cfg, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ without polling the bank
+	httpClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageContinuesTraceOfDelivery(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	delivery := getValidDelivery()
+	delivery.Headers = amqp.Table{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	retryQueue := new(mocks.RetryQueue)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the poll of the bank, and the next poll, are part of the trace of the create request
+	inTrace := mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanContextFromContext(ctx).TraceID().String() == "0af7651916cd43dd8448eb211c80319c"
+	})
+	httpClient.AssertCalled(t, "Get", inTrace, mock.Anything)
+	retryQueue.AssertCalled(t, "SchedulePoll", inTrace, mock.Anything, mock.Anything)
+}
+
+// getRepository returns a Repository mock of a submitted application, which accepts marking it as polling or failed
+func getRepository() *shareddb.Repository {
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, mock.Anything).Return(&sharedmodels.ApplicationEntry{Status: sharedmodels.Submitted}, nil)
+	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything, isStatusChange(sharedmodels.Polling)).Return(nil)
+	repository.On("UpdateApplicationStatus", mock.Anything, mock.Anything, isStatusChange(sharedmodels.Failed)).Return(nil)
+	return repository
+}
+
+// isStatusChange matches a StatusChange to the given status, made by this service
+func isStatusChange(status sharedmodels.Status) interface{} {
+	return mock.MatchedBy(func(change sharedmodels.StatusChange) bool {
+		return change.Status == status && change.Source == sharedmodels.PollApplicationService
+	})
 }
 
 func mockLoanStatusResp(status string) *http.ClientResponse {
@@ -155,12 +633,99 @@ func getSuccessResponse(status string) []byte {
 	return bytes
 }
 
+func TestProcessMessageBarePollLoanMessage(t *testing.T) {
+	body, _ := json.Marshal(sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def"})
+	delivery := getDeliveryWithBody(body)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	retryQueue := new(mocks.RetryQueue)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that a message published before envelopes were introduced is still polled, starting a new correlation
+	retryQueue.AssertCalled(t, "SchedulePoll", mock.Anything, mock.MatchedBy(func(message sharedmodels.PollLoanMessage) bool {
+		return message.OurApplicationID == "abc" && message.Attempt == 1
+	}), "")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageUnsupportedVersion(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion+1,
+		sharedmodels.CreateApplicationService, "", sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def"})
+	body, _ := json.Marshal(envelope)
+	delivery := getDeliveryWithBody(body)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	repository := new(shareddb.Repository)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ, and the application is left to be replayed
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	httpClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+	repository.AssertNotCalled(t, "UpdateApplicationStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageProtobufMessage(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "correlation", sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def"})
+	publishing, _ := messagequeue.NewPublishing(messagequeue.ProtobufCodec{}, envelope)
+	delivery := getDeliveryWithBody(publishing.Body)
+	delivery.ContentType = publishing.ContentType
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	retryQueue := new(mocks.RetryQueue)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	httpClient.On("Get", mock.Anything, mock.Anything).Return(mockLoanStatusResp(string(sharedmodels.Pending)), nil)
+	retryQueue.On("SchedulePoll", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(getRepository(), &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, httpClient, retryQueue, new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that a protobuf message is polled like a JSON message
+	retryQueue.AssertCalled(t, "SchedulePoll", mock.Anything, mock.MatchedBy(func(message sharedmodels.PollLoanMessage) bool {
+		return message.OurApplicationID == "abc" && message.Attempt == 1
+	}), "correlation")
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageUnsupportedContentType(t *testing.T) {
+	delivery := getDeliveryWithBody([]byte("<poll/>"))
+	delivery.ContentType = "text/xml"
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	httpClient := new(sharedhttp.Client)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	worker := NewRabbitMQWorker(new(shareddb.Repository), &sync.WaitGroup{}, make(chan amqp.Delivery), sharedconfig.Config{}, deliveryHandler, httpClient, new(mocks.RetryQueue), new(mocks.AlertQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+	httpClient.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
+
 func getValidDelivery() amqp.Delivery {
-	msg := sharedmodels.PollLoanMessage{
+	return getDeliveryWithMessage(sharedmodels.PollLoanMessage{
 		OurApplicationID:  "abc",
 		BankApplicationID: "def",
-	}
-	bytes, _ := json.Marshal(msg)
+	})
+}
+
+// getDeliveryWithMessage returns a delivery of msg, in an envelope of the current schema version
+func getDeliveryWithMessage(msg sharedmodels.PollLoanMessage) amqp.Delivery {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "correlation", msg)
+	bytes, _ := json.Marshal(envelope)
 
 	return getDeliveryWithBody(bytes)
 }
@@ -0,0 +1,110 @@
+package repositorys
+
+import (
+	"context"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+	"strconv"
+)
+
+//RetryQueue defines an interface for scheduling a loan application to be polled again later.
+type RetryQueue interface {
+	SchedulePoll(ctx context.Context, message sharedmodels.PollLoanMessage, correlationID string) error
+}
+
+/*
+RabbitRetryQueue schedules polls using RabbitMQ wait queues.
+
+There is a wait queue for each step of the backoff, named WaitQueueName(pollQueue, step).
+Nothing consumes from the wait queues. Instead, each message is published with a TTL, and
+when it expires RabbitMQ dead-letters it back onto the poll queue, where it is consumed as usual.
+
+RabbitMQ only expires messages at the head of a queue, so a message with a short TTL cannot
+overtake one with a long TTL. Using a queue per step means that every message on a wait queue
+has roughly the same TTL.
+*/
+type RabbitRetryQueue struct {
+	publisher messagequeue.Publisher
+	codec     messagequeue.Codec
+	backoff   sharedhelpers.Backoff
+	cfg       sharedconfig.Config
+}
+
+//NewRabbitRetryQueue returns a RabbitRetryQueue. It publishes on a channel managed by connection,
+//which declares the wait queues each time it is opened. Messages are published in the format of cfg.MessageContentType.
+func NewRabbitRetryQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitRetryQueue {
+	codec, err := messagequeue.CodecFor(cfg.MessageContentType)
+	sharedhelpers.FailOnError(err, "Retry publisher has no codec for the message content type")
+
+	backoff := sharedhelpers.Backoff{Base: cfg.PollBackoffBase, Max: cfg.PollBackoffMax, Jitter: cfg.PollBackoffJitter}
+	declare := func(ch *amqp.Channel) error {
+		for step := 0; step <= backoff.Steps(); step++ {
+			_, err := ch.QueueDeclare(
+				WaitQueueName(cfg.PollApplicationQueueName, step), // name
+				true,  // durable (survive restarts)
+				false, // do not delete when unused
+				false, // exclusive
+				false, // no-wait
+				amqp.Table{
+					// Expired messages are routed back to the poll queue via the default exchange
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": cfg.PollApplicationQueueName,
+				})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	publisher, err := messagequeue.NewReconnectingPublisher(connection, "retry publisher", cfg.PublishConfirmTimeout, declare)
+	sharedhelpers.FailOnError(err, "Failed to open a retry channel to RabbitMQ")
+	return &RabbitRetryQueue{publisher: publisher, codec: codec, backoff: backoff, cfg: cfg}
+}
+
+//WaitQueueName returns the name of the wait queue used for the given step of the backoff.
+func WaitQueueName(pollQueueName string, step int) string {
+	return fmt.Sprintf("%s.wait.%d", pollQueueName, step)
+}
+
+/*
+SchedulePoll publishes message to a wait queue, from which it is returned to the poll queue
+after a delay determined by message.Attempt. Every attempt after the last step of the backoff
+is delayed by PollBackoffMax. The message is published in a new Envelope, which keeps correlationID,
+and continues the trace in ctx. The trace context is kept when the message is returned to the poll queue.
+
+A nil error means that the broker has confirmed the message, and it is safe to ack the current delivery.
+*/
+func (queue RabbitRetryQueue) SchedulePoll(ctx context.Context, message sharedmodels.PollLoanMessage, correlationID string) error {
+	step := message.Attempt - 1
+	if step < 0 {
+		step = 0
+	}
+	if step > queue.backoff.Steps() {
+		step = queue.backoff.Steps()
+	}
+	delay := queue.backoff.Delay(step)
+
+	envelope, err := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.PollApplicationService, correlationID, message)
+	if err != nil {
+		return err
+	}
+	publishing, err := messagequeue.NewPublishing(queue.codec, envelope)
+	if err != nil {
+		return err
+	}
+	publishing.Expiration = strconv.FormatInt(delay.Milliseconds(), 10)
+
+	waitQueue := WaitQueueName(queue.cfg.PollApplicationQueueName, step)
+	_, span := messagequeue.StartPublishSpan(ctx, waitQueue, &publishing)
+	err = queue.publisher.Publish("", waitQueue, publishing)
+	tracing.End(span, err)
+
+	return err
+}
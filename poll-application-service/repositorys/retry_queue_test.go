@@ -0,0 +1,78 @@
+package repositorys
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	messagequeue "service-shared/message-queue"
+	sharedmq "service-shared/mocks/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+	"time"
+)
+
+func TestSchedulePollUsesWaitQueueForAttempt(t *testing.T) {
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", "", "poll.wait.2", mock.Anything).Return(nil)
+	queue := getRetryQueue(publisher)
+	message := sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 3}
+
+	err := queue.SchedulePoll(context.Background(), message, "correlation")
+
+	assert.Nil(t, err)
+	publishing := publisher.Calls[0].Arguments.Get(2).(amqp.Publishing)
+	assert.Equal(t, "4000", publishing.Expiration)
+	envelope, err := messagequeue.UnmarshalEnvelope(amqp.Delivery{ContentType: publishing.ContentType, Body: publishing.Body})
+	assert.Nil(t, err)
+	published, err := sharedmodels.DecodePollLoanMessage(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, message, published)
+	// Each poll is a new message, in the same correlation
+	assert.Equal(t, "correlation", envelope.CorrelationID)
+	assert.Equal(t, envelope.MessageID, publishing.MessageId)
+	assert.Equal(t, sharedmodels.PollLoanMessageType, publishing.Type)
+}
+
+func TestSchedulePollUsesLastWaitQueueAfterMax(t *testing.T) {
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", "", "poll.wait.3", mock.Anything).Return(nil)
+	queue := getRetryQueue(publisher)
+
+	err := queue.SchedulePoll(context.Background(), sharedmodels.PollLoanMessage{Attempt: 50}, "correlation")
+
+	assert.Nil(t, err)
+	publishing := publisher.Calls[0].Arguments.Get(2).(amqp.Publishing)
+	assert.Equal(t, "5000", publishing.Expiration)
+}
+
+func TestSchedulePollUsesCodec(t *testing.T) {
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", "", "poll.wait.0", mock.Anything).Return(nil)
+	queue := getRetryQueue(publisher)
+	queue.codec = messagequeue.ProtobufCodec{}
+	message := sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "def", Attempt: 1,
+		FirstSeen: time.Date(2022, 8, 1, 9, 30, 0, 0, time.UTC)}
+
+	err := queue.SchedulePoll(context.Background(), message, "correlation")
+
+	assert.Nil(t, err)
+	publishing := publisher.Calls[0].Arguments.Get(2).(amqp.Publishing)
+	assert.Equal(t, messagequeue.ProtobufContentType, publishing.ContentType)
+	envelope, err := messagequeue.ProtobufCodec{}.Unmarshal(publishing.Body)
+	assert.Nil(t, err)
+	published, err := sharedmodels.DecodePollLoanMessage(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, message, published)
+}
+
+func getRetryQueue(publisher *sharedmq.Publisher) RabbitRetryQueue {
+	return RabbitRetryQueue{
+		publisher: publisher,
+		codec:     messagequeue.JSONCodec{},
+		backoff:   sharedhelpers.Backoff{Base: time.Second, Max: 5 * time.Second},
+		cfg:       sharedconfig.Config{PollApplicationQueueName: "poll"},
+	}
+}
@@ -10,10 +10,12 @@ import (
 //MongoCaller represents operations called on a mongo collection. It is a wrapper interface to aid testing.
 type MongoCaller interface {
 	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
 	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
 	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (cur *mongo.Cursor, err error)
 	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
 	UpdateByID(ctx context.Context, id interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 }
 
 type MongoCollection struct {
@@ -28,6 +30,10 @@ func (m MongoCollection) InsertOne(ctx context.Context, document interface{}, op
 	return m.collection.InsertOne(ctx, document, opts...)
 }
 
+func (m MongoCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	return m.collection.InsertMany(ctx, documents, opts...)
+}
+
 func (m MongoCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
 	return m.collection.FindOne(ctx, filter, opts...)
 }
@@ -43,3 +49,40 @@ func (m MongoCollection) DeleteOne(ctx context.Context, filter interface{}, opts
 func (m MongoCollection) UpdateByID(ctx context.Context, id interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
 	return m.collection.UpdateByID(ctx, id, update, opts...)
 }
+
+func (m MongoCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return m.collection.UpdateOne(ctx, filter, update, opts...)
+}
+
+//Transactor runs a function inside a database transaction. It is a wrapper interface to aid testing.
+//Any MongoCaller operation given the sessCtx passed to fn takes part in the transaction.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(sessCtx context.Context) error) error
+}
+
+type MongoTransactor struct {
+	client *mongo.Client
+}
+
+func NewMongoTransactor(client *mongo.Client) MongoTransactor {
+	return MongoTransactor{client: client}
+}
+
+/*
+WithTransaction starts a session and runs fn inside a transaction, committing it if fn returns nil.
+Note that mongo only supports transactions on a replica set, see docker-compose for how the
+application-db is configured as a single node replica set.
+*/
+func (m MongoTransactor) WithTransaction(ctx context.Context, fn func(sessCtx context.Context) error) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+
+	return err
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
@@ -9,63 +10,205 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"regexp"
 	sharedconfig "service-shared/shared-config"
 	sharedhelpers "service-shared/shared-helpers"
 	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
 	"time"
 )
 
 const (
 	internalErrorResponse = "sorry, an internal system error occurred"
-	timeout               = 15
+	exportBatchSize       = 500
 )
 
 var (
-	ctx           = context.Background()
 	InternalError = errors.New(internalErrorResponse)
+
+	//ErrIdempotencyKeyExists is returned by CreateApplication when the idempotency key has already been used
+	ErrIdempotencyKeyExists = errors.New("the idempotency key has already been used")
+	//ErrIdempotencyKeyNotFound is returned by GetIdempotencyKey when the key has not been used, or has expired
+	ErrIdempotencyKeyNotFound = errors.New("the idempotency key does not exist")
+
+	//errNoTransition aborts the transaction of UpdateApplicationStatusWithEvent when the status cannot be changed
+	errNoTransition = errors.New("the application cannot move to the new status")
 )
 
-//Repository presents an abstraction for working with a database repository.
-//Any database satisfying this contract can be used to store loan applications.
+/*
+Repository presents an abstraction for working with a database repository.
+Any database satisfying this contract can be used to store loan applications.
+
+Each method takes a context, so that a cancelled request or a shutdown deadline
+stops the database operation. Implementations may apply a shorter timeout.
+*/
 type Repository interface {
-	CreateApplication(firstName, lastName string) (string, error)
-	GetApplication(applicationID string) (*sharedmodels.ApplicationEntry, error)
-	GetApplicationsWithStatus(status sharedmodels.Status) ([]sharedmodels.ApplicationEntry, error)
-	UpdateApplicationStatus(applicationID string, status sharedmodels.Status) error
-	RemoveApplication(applicationID string) error
+	CreateApplication(ctx context.Context, application sharedmodels.NewApplication, idempotencyKey *sharedmodels.IdempotencyKeyEntry) (string, error)
+	CreateApplications(ctx context.Context, applications []sharedmodels.NewApplication) ([]string, error)
+	GetApplication(ctx context.Context, applicationID string) (*sharedmodels.ApplicationEntry, error)
+	GetApplicationsWithStatus(ctx context.Context, status sharedmodels.Status, page sharedmodels.PageRequest) ([]sharedmodels.ApplicationEntry, string, error)
+	SearchApplications(ctx context.Context, filter sharedmodels.ApplicationFilter, page sharedmodels.PageRequest) ([]sharedmodels.ApplicationEntry, string, error)
+	ExportApplications(ctx context.Context, filter sharedmodels.ApplicationFilter, fn func(entry sharedmodels.ApplicationEntry) error) error
+	UpdateApplicationStatus(ctx context.Context, applicationID string, change sharedmodels.StatusChange) error
+	UpdateApplicationStatusWithEvent(ctx context.Context, applicationID string, change sharedmodels.StatusChange) error
+	RecordSubmission(ctx context.Context, applicationID, bankApplicationID string, submittedAt time.Time) error
+	RecordReconciliation(ctx context.Context, applicationID string, reconciliation sharedmodels.Reconciliation) error
+	RemoveApplication(ctx context.Context, applicationID string) error
+	GetUnsentOutboxEntries(ctx context.Context, limit int) ([]sharedmodels.OutboxEntry, error)
+	MarkOutboxEntrySent(ctx context.Context, entryID string) error
+	GetUnsentEventOutboxEntries(ctx context.Context, limit int) ([]sharedmodels.EventOutboxEntry, error)
+	MarkEventOutboxEntrySent(ctx context.Context, entryID string) error
+	GetIdempotencyKey(ctx context.Context, key string) (*sharedmodels.IdempotencyKeyEntry, error)
+	RecordWebhookAttempt(ctx context.Context, attempt sharedmodels.WebhookAttemptEntry) error
 }
 
 type MongoRepository struct {
-	mongoCaller MongoCaller
+	mongoCaller       MongoCaller
+	outboxCaller      MongoCaller
+	idempotencyCaller MongoCaller
+	webhookCaller     MongoCaller
+	eventOutboxCaller MongoCaller
+	transactor        Transactor
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	exportTimeout     time.Duration
 }
 
-func NewMongoRepository(mongoCaller MongoCaller) Repository {
-	return &MongoRepository{mongoCaller: mongoCaller}
+/*
+Collections holds a MongoCaller for each collection which a MongoRepository can use. A service only needs to
+set the collections used by the methods that it calls, for example the webhook dispatcher only sets Applications
+and Webhooks. Calling a method which uses a collection that has not been set panics.
+*/
+type Collections struct {
+	Applications MongoCaller // loan applications, used by the methods which read or change an application
+	Outbox       MongoCaller // create application messages, used by CreateApplication(s) and the outbox methods
+	Idempotency  MongoCaller // idempotency keys, used by CreateApplication and GetIdempotencyKey
+	Webhooks     MongoCaller // webhook delivery attempts, used by RecordWebhookAttempt
+	EventOutbox  MongoCaller // status changed events, used by UpdateApplicationStatusWithEvent and the event outbox methods
+}
+
+//NewMongoRepository creates a MongoRepository, which uses collections for the collections it reads and writes.
+//Writes spanning more than one are made using the transactor.
+//Reads and writes are limited to cfg.DBReadTimeout and cfg.DBWriteTimeout respectively, and exports to cfg.DBExportTimeout.
+//Each call to the repository is recorded as a span of the trace in its context.
+func NewMongoRepository(collections Collections, transactor Transactor, cfg sharedconfig.Config) Repository {
+	return tracedRepository{repository: &MongoRepository{
+		mongoCaller:       collections.Applications,
+		outboxCaller:      collections.Outbox,
+		idempotencyCaller: collections.Idempotency,
+		webhookCaller:     collections.Webhooks,
+		eventOutboxCaller: collections.EventOutbox,
+		transactor:        transactor,
+		readTimeout:       cfg.DBReadTimeout,
+		writeTimeout:      cfg.DBWriteTimeout,
+		exportTimeout:     cfg.DBExportTimeout,
+	}}
 }
 
 /*
 CreateApplication creates an entry in the DB for a loan application
-The objectID generated by mongo is made up of 12 bytes using the following scheme:
+The objectID is made up of 12 bytes using the following scheme:
 				- The first 4 bytes are a timestamp
 				- The next 5 bytes are a random value generated once per process. It is unique to the machine AND process
 				- The next 3 bytes are an incrementing counter, initialized to a random value.
 This makes collisions here very unlikely, but still, we check and retry if it happens.
+
+In the same transaction, a CreateLoanMessage for the application is written to the outbox. It is the
+responsibility of an outbox relay to publish this message to the create application queue. This means
+that an application can never be stored without eventually reaching the bank API.
+
+If idempotencyKey is not nil, it is completed with the new application ID and written in the same
+transaction. If the key has already been used, nothing is written and ErrIdempotencyKeyExists is returned.
+
+The CallbackURL of application may be empty, otherwise a webhook is sent to it once the application is decided.
 */
-func (mongoRepo MongoRepository) CreateApplication(firstName, lastName string) (string, error) {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
+func (mongoRepo MongoRepository) CreateApplication(ctx context.Context, application sharedmodels.NewApplication, idempotencyKey *sharedmodels.IdempotencyKeyEntry) (string, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
 	defer cancel()
 	for {
-		result, err := mongoRepo.mongoCaller.InsertOne(context, getApplicationEntry(firstName, lastName, sharedmodels.Pending))
+		entry := getApplicationEntry(primitive.NewObjectID(), application, sharedmodels.Queued)
+		err := mongoRepo.transactor.WithTransaction(timeoutCtx, func(sessCtx context.Context) error {
+			if idempotencyKey != nil {
+				idempotencyKey.ApplicationID = entry.ID.Hex()
+				idempotencyKey.Status = entry.Status
+				idempotencyKey.CreatedAt = time.Now().UTC()
+				if _, err := mongoRepo.idempotencyCaller.InsertOne(sessCtx, idempotencyKey); err != nil {
+					if mongo.IsDuplicateKeyError(err) {
+						return ErrIdempotencyKeyExists
+					}
+					return err
+				}
+			}
+
+			if _, err := mongoRepo.mongoCaller.InsertOne(sessCtx, entry); err != nil {
+				return err
+			}
+
+			_, err := mongoRepo.outboxCaller.InsertOne(sessCtx, getOutboxEntry(ctx, entry))
+			return err
+		})
 		if err != nil {
+			if errors.Is(err, ErrIdempotencyKeyExists) {
+				return "", err
+			}
 			if mongo.IsDuplicateKeyError(err) {
 				log.Println("Duplicate key error while creating new application. Retrying")
 				continue
 			}
 
+			log.Printf("Internal error creating application : %s\n", err)
 			return "", InternalError
 		}
 
-		return result.InsertedID.(primitive.ObjectID).Hex(), nil
+		return entry.ID.Hex(), nil
+	}
+}
+
+/*
+CreateApplications creates an entry in the DB for each of applications, using InsertMany, along with an outbox
+entry for each so that a CreateLoanMessage is published per application. Everything is written in one transaction,
+so either every application is created or none are. The IDs of the new applications are returned in the same
+order as applications.
+
+As with CreateApplication, the transaction is retried with new IDs in the unlikely event that one collides.
+*/
+func (mongoRepo MongoRepository) CreateApplications(ctx context.Context, applications []sharedmodels.NewApplication) ([]string, error) {
+	if len(applications) == 0 {
+		return nil, nil
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+	for {
+		ids := make([]string, len(applications))
+		entries := make([]interface{}, len(applications))
+		outboxEntries := make([]interface{}, len(applications))
+		for i, application := range applications {
+			entry := getApplicationEntry(primitive.NewObjectID(), application, sharedmodels.Queued)
+			ids[i] = entry.ID.Hex()
+			entries[i] = entry
+			outboxEntries[i] = getOutboxEntry(ctx, entry)
+		}
+
+		err := mongoRepo.transactor.WithTransaction(timeoutCtx, func(sessCtx context.Context) error {
+			if _, err := mongoRepo.mongoCaller.InsertMany(sessCtx, entries); err != nil {
+				return err
+			}
+
+			_, err := mongoRepo.outboxCaller.InsertMany(sessCtx, outboxEntries)
+			return err
+		})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				log.Println("Duplicate key error while creating a batch of applications. Retrying")
+				continue
+			}
+
+			log.Printf("Internal error creating a batch of %d applications : %s\n", len(applications), err)
+			return nil, InternalError
+		}
+
+		return ids, nil
 	}
 }
 
@@ -75,8 +218,8 @@ The provided applicationID is expected to conform to the requirements of primiti
 
 In case of an unrecoverable DB error, returns InternalError.
 */
-func (mongoRepo MongoRepository) GetApplication(applicationID string) (*sharedmodels.ApplicationEntry, error) {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
+func (mongoRepo MongoRepository) GetApplication(ctx context.Context, applicationID string) (*sharedmodels.ApplicationEntry, error) {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.readTimeout)
 	defer cancel()
 	objID, err := primitive.ObjectIDFromHex(applicationID)
 	if err != nil {
@@ -99,49 +242,347 @@ func (mongoRepo MongoRepository) GetApplication(applicationID string) (*sharedmo
 }
 
 /*
-GetApplicationsWithStatus retrieves all applications matching a given status from the database.
+GetApplicationsWithStatus retrieves a page of applications matching a given status from the database,
+sorted by creation time. If there are more applications after the page, the token for the next page
+is also returned, otherwise it is empty.
 
+Returns ErrInvalidPageToken if page.PageToken was not returned by an earlier call.
 In case of an unrecoverable DB error, returns InternalError.
 */
-func (mongoRepo MongoRepository) GetApplicationsWithStatus(status sharedmodels.Status) ([]sharedmodels.ApplicationEntry, error) {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
+func (mongoRepo MongoRepository) GetApplicationsWithStatus(ctx context.Context, status sharedmodels.Status, page sharedmodels.PageRequest) ([]sharedmodels.ApplicationEntry, string, error) {
+	return mongoRepo.findPage(ctx, bson.M{"status": status}, page, fmt.Sprintf("applications with status %s", status))
+}
+
+/*
+SearchApplications retrieves a page of the applications matching every part of filter from the database,
+sorted by creation time. If there are more applications after the page, the token for the next page
+is also returned, otherwise it is empty.
+
+The creation date range is compared with the time in each application ID, so that applications stored
+before CreatedAt was recorded can also be found. This has a resolution of one second.
+
+Returns ErrInvalidPageToken if page.PageToken was not returned by an earlier call.
+In case of an unrecoverable DB error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) SearchApplications(ctx context.Context, filter sharedmodels.ApplicationFilter, page sharedmodels.PageRequest) ([]sharedmodels.ApplicationEntry, string, error) {
+	return mongoRepo.findPage(ctx, getSearchFilter(filter), page, "applications matching search")
+}
+
+/*
+ExportApplications calls fn with each application matching filter, oldest first. The applications are
+streamed from a cursor, so only one batch is held in memory at a time, however many match.
+
+If fn returns an error, the export stops and that error is returned.
+In case of an unrecoverable DB error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) ExportApplications(ctx context.Context, filter sharedmodels.ApplicationFilter, fn func(entry sharedmodels.ApplicationEntry) error) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.exportTimeout)
 	defer cancel()
-	cursor, err := mongoRepo.mongoCaller.Find(context, sharedmodels.ApplicationEntry{Status: status})
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(exportBatchSize)
+
+	cursor, err := mongoRepo.mongoCaller.Find(context, getSearchFilter(filter), findOptions)
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get applications with status %s - the error was %s", status, err))
-		return nil, InternalError
+		log.Printf("Unable to export applications - the error was %s\n", err)
+		return InternalError
+	}
+	defer cursor.Close(context)
+
+	for cursor.Next(context) {
+		var entry sharedmodels.ApplicationEntry
+		if err = cursor.Decode(&entry); err != nil {
+			log.Printf("Unable to decode exported application - the error was %s\n", err)
+			return InternalError
+		}
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		log.Printf("Encountered an error exporting applications - the error was %s\n", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+//findPage retrieves a page of the applications matching filter, sorted by ID. description is used when logging errors.
+func (mongoRepo MongoRepository) findPage(ctx context.Context, filter bson.M, page sharedmodels.PageRequest, description string) ([]sharedmodels.ApplicationEntry, string, error) {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.readTimeout)
+	defer cancel()
+	direction, after := 1, "$gt"
+	if page.Sort == sharedmodels.Newest {
+		direction, after = -1, "$lt"
+	}
+	if len(page.PageToken) > 0 {
+		lastID, err := decodePageToken(page.PageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		idFilter, ok := filter["_id"].(bson.M)
+		if !ok {
+			idFilter = bson.M{}
+		}
+		idFilter[after] = lastID
+		filter["_id"] = idFilter
+	}
+	// Fetch one more than the limit, to find out whether there is a next page
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: direction}}).
+		SetLimit(int64(page.Limit + 1))
+
+	cursor, err := mongoRepo.mongoCaller.Find(context, filter, findOptions)
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get %s - the error was %s", description, err))
+		return nil, "", InternalError
 	}
 
 	var result []sharedmodels.ApplicationEntry
 	if err = cursor.All(context, &result); err != nil {
-		log.Printf("Encountered an error obtaining all applications with status %s - the error was %s\n", status, err)
-		return nil, InternalError
+		log.Printf("Encountered an error obtaining %s - the error was %s\n", description, err)
+		return nil, "", InternalError
 	}
 
-	return result, nil
+	if len(result) <= page.Limit {
+		return result, "", nil
+	}
+	result = result[:page.Limit]
+	return result, encodePageToken(result[len(result)-1].ID), nil
+}
+
+//getSearchFilter returns a mongo filter which matches every part of filter
+func getSearchFilter(filter sharedmodels.ApplicationFilter) bson.M {
+	search := bson.M{}
+	if len(filter.LastName) > 0 {
+		search["lastname"] = filter.LastName
+	}
+	if len(filter.FirstNamePrefix) > 0 {
+		// An anchored, case sensitive prefix can use the index on names
+		search["firstname"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.FirstNamePrefix)}
+	}
+	if len(filter.Statuses) > 0 {
+		search["status"] = bson.M{"$in": filter.Statuses}
+	}
+
+	created := bson.M{}
+	if filter.CreatedFrom != nil {
+		created["$gte"] = objectIDAt(*filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		created["$lt"] = objectIDAt(*filter.CreatedTo)
+	}
+	if len(created) > 0 {
+		search["_id"] = created
+	}
+
+	return search
+}
+
+//objectIDAt returns the lowest ObjectID created at t. Unlike primitive.NewObjectIDFromTimestamp, the rest of the ID is zero.
+func objectIDAt(t time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(t.Unix()))
+	return id
 }
 
 /*
-UpdateApplicationStatus updates the status of an application with the provided status string
+UpdateApplicationStatus updates the status of an application to change.Status, and appends change
+to its history. If change.At is not set, it is set to now. If the new status is terminal, the time
+of the change is also recorded as the time the application was decided.
+
+The update is conditional on the current status, so that it is atomic with checking the transition.
+If the application cannot move from its current status to change.Status, for example because a late
+or duplicate poll tries to change a decided application, nothing is written and a
+*sharedmodels.TransitionError is returned.
 
 In case of an unrecoverable error, returns InternalError.
 */
-func (mongoRepo MongoRepository) UpdateApplicationStatus(applicationID string, status sharedmodels.Status) error {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
+func (mongoRepo MongoRepository) UpdateApplicationStatus(ctx context.Context, applicationID string, change sharedmodels.StatusChange) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
 	defer cancel()
 	objID, _ := primitive.ObjectIDFromHex(applicationID)
-	entry := sharedmodels.ApplicationEntry{ID: objID, Status: status}
-	update := bson.M{
-		"$set": entry,
+	if change.At.IsZero() {
+		change.At = time.Now().UTC()
 	}
+	filter, update := getStatusUpdate(objID, change)
 
-	_, err := mongoRepo.mongoCaller.UpdateByID(context, objID, update)
+	result, err := mongoRepo.mongoCaller.UpdateOne(context, filter, update)
 	if err != nil {
 		log.Printf("Internal error updating application status : %s\n", err)
 		return InternalError
 	}
+	if result.MatchedCount == 0 {
+		return mongoRepo.getTransitionError(context, objID, applicationID, change.Status)
+	}
 
-	return err
+	return nil
+}
+
+/*
+UpdateApplicationStatusWithEvent is UpdateApplicationStatus, which also writes a StatusChangedEvent for the change
+to the event outbox in the same transaction. The event is published later by a relay, so it is not lost if the
+service stops, or RabbitMQ is unavailable, once the status has been updated.
+
+If the application cannot move to change.Status, neither is written and a *sharedmodels.TransitionError is returned.
+In case of an unrecoverable error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) UpdateApplicationStatusWithEvent(ctx context.Context, applicationID string, change sharedmodels.StatusChange) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+	objID, _ := primitive.ObjectIDFromHex(applicationID)
+	if change.At.IsZero() {
+		change.At = time.Now().UTC()
+	}
+	filter, update := getStatusUpdate(objID, change)
+
+	err := mongoRepo.transactor.WithTransaction(timeoutCtx, func(sessCtx context.Context) error {
+		result, err := mongoRepo.mongoCaller.UpdateOne(sessCtx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errNoTransition
+		}
+
+		_, err = mongoRepo.eventOutboxCaller.InsertOne(sessCtx, getEventOutboxEntry(ctx, applicationID, change))
+		return err
+	})
+	if errors.Is(err, errNoTransition) {
+		return mongoRepo.getTransitionError(timeoutCtx, objID, applicationID, change.Status)
+	}
+	if err != nil {
+		log.Printf("Internal error updating application status with an event : %s\n", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+//getStatusUpdate returns the filter and update which move the application objID to change.Status, if it may move there.
+func getStatusUpdate(objID primitive.ObjectID, change sharedmodels.StatusChange) (bson.M, bson.M) {
+	filter := bson.M{"_id": objID, "status": bson.M{"$in": sharedmodels.StatusesBefore(change.Status)}}
+	set := bson.M{"status": change.Status}
+	if change.Status.IsTerminal() {
+		set["decided_at"] = change.At
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"history": change},
+	}
+
+	return filter, update
+}
+
+//getTransitionError returns why an application could not move to status next, after a conditional update matched nothing.
+func (mongoRepo MongoRepository) getTransitionError(ctx context.Context, objID primitive.ObjectID, applicationID string, next sharedmodels.Status) error {
+	var current sharedmodels.ApplicationEntry
+	err := mongoRepo.mongoCaller.FindOne(ctx, sharedmodels.ApplicationEntry{ID: objID}).Decode(&current)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errors.New(fmt.Sprintf("The application_id %s does not exist", applicationID))
+		}
+		log.Printf("Internal error reading status of application %s : %s\n", applicationID, err)
+		return InternalError
+	}
+
+	return &sharedmodels.TransitionError{ApplicationID: applicationID, From: current.Status, To: next}
+}
+
+/*
+RecordSubmission records that an application was created with the bank API, under bankApplicationID,
+at submittedAt. The status of the application is set to Submitted, and the submission is appended to its history.
+
+A submission is only recorded once, and only for an application which may move to Submitted,
+so it is safe to call again for a redelivered message.
+
+An application which was withdrawn while it was being submitted stays withdrawn, but the bankApplicationID
+is still recorded, so that the application can be reconciled with the bank.
+In case of an unrecoverable error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) RecordSubmission(ctx context.Context, applicationID, bankApplicationID string, submittedAt time.Time) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+	objID, _ := primitive.ObjectIDFromHex(applicationID)
+	filter := bson.M{
+		"_id":                 objID,
+		"bank_application_id": bson.M{"$exists": false},
+		"status":              bson.M{"$in": sharedmodels.StatusesBefore(sharedmodels.Submitted)},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": sharedmodels.Submitted, "bank_application_id": bankApplicationID, "submitted_at": submittedAt},
+		"$push": bson.M{"history": sharedmodels.StatusChange{
+			Status: sharedmodels.Submitted,
+			Source: sharedmodels.CreateApplicationService,
+			Reason: "Submitted to the bank API",
+			At:     submittedAt,
+		}},
+	}
+
+	result, err := mongoRepo.mongoCaller.UpdateOne(context, filter, update)
+	if err != nil {
+		log.Printf("Internal error recording submission of application %s : %s\n", applicationID, err)
+		return InternalError
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	filter = bson.M{
+		"_id":                 objID,
+		"bank_application_id": bson.M{"$exists": false},
+		"status":              sharedmodels.Withdrawn,
+	}
+	update = bson.M{
+		"$set": bson.M{"bank_application_id": bankApplicationID, "submitted_at": submittedAt},
+		"$push": bson.M{"history": sharedmodels.StatusChange{
+			Status: sharedmodels.Withdrawn,
+			Source: sharedmodels.CreateApplicationService,
+			Reason: "Submitted to the bank API after the application was withdrawn",
+			At:     submittedAt,
+		}},
+	}
+	_, err = mongoRepo.mongoCaller.UpdateOne(context, filter, update)
+	if err != nil {
+		log.Printf("Internal error recording submission of withdrawn application %s : %s\n", applicationID, err)
+		return InternalError
+	}
+
+	return nil
+}
+
+/*
+RecordReconciliation records on a withdrawn application that it must be reconciled with the bank.
+
+It is safe to call again for a redelivered message. The earliest RequiredAt is kept, and a BankDecision which has
+already been recorded is not cleared by a later call without one.
+Returns an error if the application does not exist or has not been withdrawn.
+In case of an unrecoverable error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) RecordReconciliation(ctx context.Context, applicationID string, reconciliation sharedmodels.Reconciliation) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+	objID, _ := primitive.ObjectIDFromHex(applicationID)
+	filter := bson.M{"_id": objID, "status": sharedmodels.Withdrawn}
+	set := bson.M{"reconciliation.reason": reconciliation.Reason}
+	if len(reconciliation.BankDecision) > 0 {
+		set["reconciliation.bank_decision"] = reconciliation.BankDecision
+	}
+	update := bson.M{
+		"$set": set,
+		"$min": bson.M{"reconciliation.required_at": reconciliation.RequiredAt},
+	}
+
+	result, err := mongoRepo.mongoCaller.UpdateOne(context, filter, update)
+	if err != nil {
+		log.Printf("Internal error recording reconciliation of application %s : %s\n", applicationID, err)
+		return InternalError
+	}
+	if result.MatchedCount == 0 {
+		return errors.New(fmt.Sprintf("The application_id %s does not exist or has not been withdrawn", applicationID))
+	}
+
+	return nil
 }
 
 /*
@@ -149,8 +590,8 @@ RemoveApplication deletes an application from the database given its application
 
 In case of an unrecoverable error, returns InternalError.
 */
-func (mongoRepo MongoRepository) RemoveApplication(applicationID string) error {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
+func (mongoRepo MongoRepository) RemoveApplication(ctx context.Context, applicationID string) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
 	defer cancel()
 	primitiveID, _ := primitive.ObjectIDFromHex(applicationID)
 
@@ -162,17 +603,203 @@ func (mongoRepo MongoRepository) RemoveApplication(applicationID string) error {
 	return err
 }
 
-func getApplicationEntry(firstName, lastName string, status sharedmodels.Status) sharedmodels.ApplicationEntry {
+/*
+GetUnsentOutboxEntries retrieves up to limit outbox entries which have not yet been published,
+oldest first.
+
+In case of an unrecoverable DB error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) GetUnsentOutboxEntries(ctx context.Context, limit int) ([]sharedmodels.OutboxEntry, error) {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.readTimeout)
+	defer cancel()
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := mongoRepo.outboxCaller.Find(context, bson.M{"sent": false}, findOptions)
+	if err != nil {
+		log.Printf("Unable to get unsent outbox entries - the error was %s\n", err)
+		return nil, InternalError
+	}
+
+	var result []sharedmodels.OutboxEntry
+	if err = cursor.All(context, &result); err != nil {
+		log.Printf("Encountered an error obtaining unsent outbox entries - the error was %s\n", err)
+		return nil, InternalError
+	}
+
+	return result, nil
+}
+
+/*
+MarkOutboxEntrySent marks an outbox entry as published so that it is not relayed again.
+The entry is removed by the TTL index on 'sent_at' once it has been sent for OutboxRetention.
+
+Returns an error if entryID is not a valid ID. In case of an unrecoverable error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) MarkOutboxEntrySent(ctx context.Context, entryID string) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+	objID, err := primitive.ObjectIDFromHex(entryID)
+	if err != nil {
+		return fmt.Errorf("%w : the outbox entry ID %s is not valid", err, entryID)
+	}
+	update := bson.M{
+		"$set": bson.M{"sent": true, "sent_at": time.Now().UTC()},
+	}
+
+	_, err = mongoRepo.outboxCaller.UpdateByID(context, objID, update)
+	if err != nil {
+		log.Printf("Internal error marking outbox entry %s as sent : %s\n", entryID, err)
+		return InternalError
+	}
+
+	return nil
+}
+
+/*
+GetUnsentEventOutboxEntries retrieves up to limit status events which have not yet been published, oldest first.
+
+In case of an unrecoverable DB error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) GetUnsentEventOutboxEntries(ctx context.Context, limit int) ([]sharedmodels.EventOutboxEntry, error) {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.readTimeout)
+	defer cancel()
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := mongoRepo.eventOutboxCaller.Find(context, bson.M{"sent": false}, findOptions)
+	if err != nil {
+		log.Printf("Unable to get unsent status events - the error was %s\n", err)
+		return nil, InternalError
+	}
+
+	var result []sharedmodels.EventOutboxEntry
+	if err = cursor.All(context, &result); err != nil {
+		log.Printf("Encountered an error obtaining unsent status events - the error was %s\n", err)
+		return nil, InternalError
+	}
+
+	return result, nil
+}
+
+/*
+MarkEventOutboxEntrySent marks a status event as published so that it is not relayed again.
+The entry is removed by the TTL index on 'sent_at' once it has been sent for OutboxRetention.
+
+Returns an error if entryID is not a valid ID. In case of an unrecoverable error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) MarkEventOutboxEntrySent(ctx context.Context, entryID string) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+	objID, err := primitive.ObjectIDFromHex(entryID)
+	if err != nil {
+		return fmt.Errorf("%w : the status event ID %s is not valid", err, entryID)
+	}
+	update := bson.M{
+		"$set": bson.M{"sent": true, "sent_at": time.Now().UTC()},
+	}
+
+	_, err = mongoRepo.eventOutboxCaller.UpdateByID(context, objID, update)
+	if err != nil {
+		log.Printf("Internal error marking status event %s as sent : %s\n", entryID, err)
+		return InternalError
+	}
+
+	return nil
+}
+
+/*
+GetIdempotencyKey retrieves the entry recorded for an idempotency key by CreateApplication.
+
+Returns ErrIdempotencyKeyNotFound if the key has not been used, or has expired.
+In case of an unrecoverable DB error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) GetIdempotencyKey(ctx context.Context, key string) (*sharedmodels.IdempotencyKeyEntry, error) {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.readTimeout)
+	defer cancel()
+
+	var entry sharedmodels.IdempotencyKeyEntry
+	err := mongoRepo.idempotencyCaller.FindOne(context, bson.M{"key": key}).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrIdempotencyKeyNotFound
+		}
+
+		log.Printf("Unable to get idempotency key %s - the error was %s\n", key, err)
+		return nil, InternalError
+	}
+
+	return &entry, nil
+}
+
+/*
+RecordWebhookAttempt records an attempt to deliver a webhook, whether or not it succeeded, so that
+support staff can see why a receiver did not get one.
+
+In case of an unrecoverable DB error, returns InternalError.
+*/
+func (mongoRepo MongoRepository) RecordWebhookAttempt(ctx context.Context, attempt sharedmodels.WebhookAttemptEntry) error {
+	context, cancel := context.WithTimeout(ctx, mongoRepo.writeTimeout)
+	defer cancel()
+
+	_, err := mongoRepo.webhookCaller.InsertOne(context, attempt)
+	if err != nil {
+		log.Printf("Internal error recording webhook attempt %d for application %s : %s\n", attempt.Attempt, attempt.ApplicationID, err)
+		return InternalError
+	}
+
+	return nil
+}
+
+func getApplicationEntry(id primitive.ObjectID, application sharedmodels.NewApplication, status sharedmodels.Status) sharedmodels.ApplicationEntry {
+	now := time.Now().UTC()
 	return sharedmodels.ApplicationEntry{
-		Status:    status,
-		FirstName: firstName,
-		LastName:  lastName,
+		ID:          id,
+		Status:      status,
+		FirstName:   application.FirstName,
+		LastName:    application.LastName,
+		CreatedAt:   &now,
+		CallbackURL: application.CallbackURL,
+		LoanDetails: application.LoanDetails,
+		History: []sharedmodels.StatusChange{
+			{Status: status, Source: sharedmodels.APIGatewayService, Reason: "Application received", At: now},
+		},
 	}
 }
 
-//InitClient initialises a mongo client
-func InitClient(cfg sharedconfig.Config) (*mongo.Client, error) {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
+func getOutboxEntry(ctx context.Context, entry sharedmodels.ApplicationEntry) sharedmodels.OutboxEntry {
+	return sharedmodels.OutboxEntry{
+		Message: sharedmodels.CreateLoanMessage{
+			ApplicationID: entry.ID.Hex(),
+			FirstName:     entry.FirstName,
+			LastName:      entry.LastName,
+			LoanDetails:   entry.LoanDetails,
+		},
+		Sent:         false,
+		CreatedAt:    time.Now().UTC(),
+		TraceContext: tracing.Inject(ctx),
+	}
+}
+
+func getEventOutboxEntry(ctx context.Context, applicationID string, change sharedmodels.StatusChange) sharedmodels.EventOutboxEntry {
+	return sharedmodels.EventOutboxEntry{
+		Event: sharedmodels.StatusChangedEvent{
+			ApplicationID: applicationID,
+			Status:        change.Status,
+			Reason:        change.Reason,
+			At:            change.At,
+		},
+		Sent:         false,
+		CreatedAt:    time.Now().UTC(),
+		TraceContext: tracing.Inject(ctx),
+	}
+}
+
+//InitClient initialises a mongo client, waiting up to cfg.DBConnectTimeout for the db to respond
+func InitClient(ctx context.Context, cfg sharedconfig.Config) (*mongo.Client, error) {
+	context, cancel := context.WithTimeout(ctx, cfg.DBConnectTimeout)
 	defer cancel()
 
 	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
@@ -195,22 +822,153 @@ func InitClient(cfg sharedconfig.Config) (*mongo.Client, error) {
 InitIndexes sets up indexes on a mongo collection. Right now, this is intended
 to be used in a single collection, that being the loan application collection.
 
-It creates an index on the 'status' field to improve query performance when
-attempting to query for all applications matching a provided status.
-*/
-func InitIndexes(collection *mongo.Collection) {
-	context, cancel := context.WithTimeout(ctx, timeout*time.Second)
-	defer cancel()
+It creates compound indexes which are used to retrieve a page of applications sorted by creation time:
+- on the 'status' and '_id' fields, for applications matching a provided status
+- on the 'lastname', 'firstname' and '_id' fields, for searching by last name, and optionally first name prefix
+- on the 'firstname' and '_id' fields, for searching by first name prefix alone
 
-	model := mongo.IndexModel{
-		Keys:    bson.D{{Key: "status", Value: 1}},
-		Options: options.Index().SetUnique(false),
+Searching by creation date alone uses the default index on '_id'.
+
+The indexes are created at startup, so ctx should carry a deadline such as DBConnectTimeout.
+*/
+func InitIndexes(ctx context.Context, collection *mongo.Collection) {
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetUnique(false),
+		},
+		{
+			Keys:    bson.D{{Key: "lastname", Value: 1}, {Key: "firstname", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetUnique(false),
+		},
+		{
+			Keys:    bson.D{{Key: "firstname", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetUnique(false),
+		},
 	}
 
 	indexView := collection.Indexes()
 
-	_, err := indexView.CreateOne(context, model)
+	_, err := indexView.CreateMany(ctx, models)
 	if err != nil {
 		sharedhelpers.FailOnError(err, "Failed to initialise indexes")
 	}
 }
+
+/*
+InitOutboxIndexes sets up indexes on the outbox collection, or the event outbox collection.
+
+It creates a compound index on the 'sent' and 'created_at' fields, which is used by
+the relay of the collection to find the oldest unsent entries, and a TTL index on the 'sent_at' field,
+so that mongo removes entries once they have been sent for retention. Unsent entries have no 'sent_at',
+so they are never removed. If retention has changed since the TTL index was created, the expiry of the
+index is updated. As with InitIndexes, ctx should carry a deadline.
+*/
+func InitOutboxIndexes(ctx context.Context, collection *mongo.Collection, retention time.Duration) {
+	err := updateIndexExpiry(ctx, collection, "sent_at", retention)
+	sharedhelpers.FailOnError(err, "Failed to update the expiry of sent outbox entries")
+
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sent", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetUnique(false),
+		},
+		{
+			Keys:    bson.D{{Key: "sent_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	}
+
+	_, err = collection.Indexes().CreateMany(ctx, models)
+	sharedhelpers.FailOnError(err, "Failed to initialise outbox indexes")
+}
+
+/*
+InitIdempotencyIndexes sets up indexes on the idempotency key collection.
+
+It creates a unique index on the 'key' field, so that a key can only be recorded once even when
+two requests using it race, and a TTL index on the 'created_at' field, so that mongo removes
+keys once they are older than ttl. If ttl has changed since the TTL index was created, the expiry
+of the index is updated. As with InitIndexes, ctx should carry a deadline.
+*/
+func InitIdempotencyIndexes(ctx context.Context, collection *mongo.Collection, ttl time.Duration) {
+	err := updateIndexExpiry(ctx, collection, "created_at", ttl)
+	sharedhelpers.FailOnError(err, "Failed to update the expiry of idempotency keys")
+
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+		},
+	}
+
+	_, err = collection.Indexes().CreateMany(ctx, models)
+	sharedhelpers.FailOnError(err, "Failed to initialise idempotency key indexes")
+}
+
+//indexSpecification is the part of an index, as listed by mongo, which updateIndexExpiry needs
+type indexSpecification struct {
+	Key                bson.D `bson:"key"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+/*
+updateIndexExpiry changes the expiry of the TTL index on field to ttl, if the index exists with a different expiry.
+
+Creating an index which already exists with different options fails, so a TTL index cannot be changed by
+creating it again. Instead its expiry is changed in place with collMod, which keeps the index.
+*/
+func updateIndexExpiry(ctx context.Context, collection *mongo.Collection, field string, ttl time.Duration) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []indexSpecification
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	expireAfterSeconds := int64(ttl.Seconds())
+	for _, index := range indexes {
+		if !expiryDiffers(index, field, expireAfterSeconds) {
+			continue
+		}
+
+		log.Printf("Changing the expiry of the index on %s.%s from %ds to %ds\n", collection.Name(), field, *index.ExpireAfterSeconds, expireAfterSeconds)
+		return collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "keyPattern", Value: index.Key},
+				{Key: "expireAfterSeconds", Value: expireAfterSeconds},
+			}},
+		}).Err()
+	}
+
+	return nil
+}
+
+//expiryDiffers returns true if index is a TTL index on field alone, which expires documents after other than expireAfterSeconds
+func expiryDiffers(index indexSpecification, field string, expireAfterSeconds int64) bool {
+	return len(index.Key) == 1 && index.Key[0].Key == field &&
+		index.ExpireAfterSeconds != nil && *index.ExpireAfterSeconds != expireAfterSeconds
+}
+
+/*
+InitWebhookIndexes sets up indexes on the webhook delivery collection.
+
+It creates a compound index on the 'application_id' and 'attempted_at' fields, which is used to
+list the attempts made for an application in order. As with InitIndexes, ctx should carry a deadline.
+*/
+func InitWebhookIndexes(ctx context.Context, collection *mongo.Collection) {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "application_id", Value: 1}, {Key: "attempted_at", Value: 1}},
+		Options: options.Index().SetUnique(false),
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	sharedhelpers.FailOnError(err, "Failed to initialise webhook delivery indexes")
+}
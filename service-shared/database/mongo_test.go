@@ -1,14 +1,19 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	mocks "service-shared/mocks/database"
+	sharedconfig "service-shared/shared-config"
 	shared_models "service-shared/shared-models"
 	"testing"
+	"time"
 )
 
 const (
@@ -22,8 +27,8 @@ func TestCreateApplicationInternalError(t *testing.T) {
 	mongo := new(mocks.MongoCaller)
 	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(nil, errors.New(""))
 
-	repo := NewMongoRepository(mongo)
-	_, err := repo.CreateApplication(firstName, lastName)
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+	_, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
 
 	assert.Equal(t, InternalError, err)
 }
@@ -31,36 +36,215 @@ func TestCreateApplicationInternalError(t *testing.T) {
 func TestCreateApplicationDuplicateKeyErr(t *testing.T) {
 	// Setup
 	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
 	dupeKeyError := getDuplicatekeyError()
 
 	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(nil, dupeKeyError).Once()
 	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
 
-	repo := NewMongoRepository(mongo)
-	resp, err := repo.CreateApplication(firstName, lastName)
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	resp, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resp)
 	mongo.AssertNumberOfCalls(t, "InsertOne", 2)
+	outbox.AssertNumberOfCalls(t, "InsertOne", 1)
 }
 
 func TestCreateApplicationSuccess(t *testing.T) {
 	// Setup
 	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
 
 	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
 
-	repo := NewMongoRepository(mongo)
-	resp, err := repo.CreateApplication(firstName, lastName)
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	resp, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resp)
 	mongo.AssertNumberOfCalls(t, "InsertOne", 1)
+	// The outbox entry must refer to the application which was created
+	outbox.AssertCalled(t, "InsertOne", mock.Anything, mock.MatchedBy(func(entry shared_models.OutboxEntry) bool {
+		return entry.Message.ApplicationID == resp && !entry.Sent
+	}))
+}
+
+func TestCreateApplicationWithCallbackURL(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	application := getNewApplication()
+	application.CallbackURL = "https://example.com/loans"
+	_, err := repo.CreateApplication(context.Background(), application, nil)
+
+	assert.Nil(t, err)
+	mongo.AssertCalled(t, "InsertOne", mock.Anything, mock.MatchedBy(func(entry shared_models.ApplicationEntry) bool {
+		return entry.CallbackURL == "https://example.com/loans"
+	}))
+}
+
+func TestCreateApplicationWritesLoanDetails(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	id, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
+
+	assert.Nil(t, err)
+	mongo.AssertCalled(t, "InsertOne", mock.Anything, mock.MatchedBy(func(entry shared_models.ApplicationEntry) bool {
+		return entry.LoanDetails == getNewApplication().LoanDetails
+	}))
+	outbox.AssertCalled(t, "InsertOne", mock.Anything, mock.MatchedBy(func(entry shared_models.OutboxEntry) bool {
+		return entry.Message == shared_models.CreateLoanMessage{
+			ApplicationID: id,
+			FirstName:     firstName,
+			LastName:      lastName,
+			LoanDetails:   getNewApplication().LoanDetails,
+		}
+	}))
+}
+
+func TestCreateApplicationOutboxInternalError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	_, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestCreateApplicationRecordsIdempotencyKey(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+	idempotency := new(mocks.MongoCaller)
+
+	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	idempotency.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox, Idempotency: idempotency}, getTransactor(), getConfig())
+	key := &shared_models.IdempotencyKeyEntry{Key: "key", RequestHash: "hash"}
+	resp, err := repo.CreateApplication(context.Background(), getNewApplication(), key)
+
+	assert.Nil(t, err)
+	assert.Equal(t, resp, key.ApplicationID)
+	assert.Equal(t, shared_models.Queued, key.Status)
+	idempotency.AssertCalled(t, "InsertOne", mock.Anything, key)
+}
+
+func TestCreateApplicationIdempotencyKeyExists(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+	idempotency := new(mocks.MongoCaller)
+
+	idempotency.On("InsertOne", mock.Anything, mock.Anything).Return(nil, getDuplicatekeyError())
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox, Idempotency: idempotency}, getTransactor(), getConfig())
+	_, err := repo.CreateApplication(context.Background(), getNewApplication(), &shared_models.IdempotencyKeyEntry{Key: "key"})
+
+	// A reused key must not be retried like a colliding application ID, or create an application
+	assert.Equal(t, ErrIdempotencyKeyExists, err)
+	idempotency.AssertNumberOfCalls(t, "InsertOne", 1)
+	mongo.AssertNotCalled(t, "InsertOne", mock.Anything, mock.Anything)
+	outbox.AssertNotCalled(t, "InsertOne", mock.Anything, mock.Anything)
+}
+
+func TestCreateApplicationsInsertsEveryApplication(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+	applications := []shared_models.NewApplication{
+		getNewApplication(),
+		{FirstName: firstName, LastName: lastName, CallbackURL: "https://example.com/loans"},
+	}
+
+	mongo.On("InsertMany", mock.Anything, mock.Anything).Return(getInsertManyResult(), nil)
+	outbox.On("InsertMany", mock.Anything, mock.Anything).Return(getInsertManyResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	ids, err := repo.CreateApplications(context.Background(), applications)
+
+	assert.Nil(t, err)
+	assert.Len(t, ids, 2)
+	entries := mongo.Calls[0].Arguments.Get(1).([]interface{})
+	assert.Len(t, entries, 2)
+	for i, document := range entries {
+		entry := document.(shared_models.ApplicationEntry)
+		assert.Equal(t, ids[i], entry.ID.Hex())
+		assert.Equal(t, shared_models.Queued, entry.Status)
+		assert.Equal(t, applications[i].CallbackURL, entry.CallbackURL)
+	}
+	outboxEntries := outbox.Calls[0].Arguments.Get(1).([]interface{})
+	assert.Len(t, outboxEntries, 2)
+}
+
+func TestCreateApplicationsDuplicateKeyErr(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertMany", mock.Anything, mock.Anything).Return(nil, getDuplicatekeyError()).Once()
+	mongo.On("InsertMany", mock.Anything, mock.Anything).Return(getInsertManyResult(), nil)
+	outbox.On("InsertMany", mock.Anything, mock.Anything).Return(getInsertManyResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	ids, err := repo.CreateApplications(context.Background(), []shared_models.NewApplication{{FirstName: firstName, LastName: lastName}})
+
+	assert.Nil(t, err)
+	assert.Len(t, ids, 1)
+	mongo.AssertNumberOfCalls(t, "InsertMany", 2)
+	outbox.AssertNumberOfCalls(t, "InsertMany", 1)
+}
+
+func TestCreateApplicationsOutboxInternalError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertMany", mock.Anything, mock.Anything).Return(getInsertManyResult(), nil)
+	outbox.On("InsertMany", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	ids, err := repo.CreateApplications(context.Background(), []shared_models.NewApplication{{FirstName: firstName, LastName: lastName}})
+
+	assert.Equal(t, InternalError, err)
+	assert.Nil(t, ids)
+}
+
+func TestCreateApplicationsEmpty(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+	ids, err := repo.CreateApplications(context.Background(), nil)
+
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+	mongo.AssertNotCalled(t, "InsertMany", mock.Anything, mock.Anything)
 }
 
 func TestGetApplicationInvalidID(t *testing.T) {
-	repo := NewMongoRepository(new(mocks.MongoCaller))
-	_, err := repo.GetApplication("an-invalid-id")
+	repo := NewMongoRepository(Collections{}, getTransactor(), getConfig())
+	_, err := repo.GetApplication(context.Background(), "an-invalid-id")
 
 	assert.NotNil(t, err)
 }
@@ -70,9 +254,9 @@ func TestGetApplicationInternalError(t *testing.T) {
 	mongo := new(mocks.MongoCaller)
 	mongo.On("FindOne", mock.Anything, mock.Anything).Return(getSingleResult())
 
-	repo := NewMongoRepository(mongo)
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
 
-	_, err := repo.GetApplication(validApplicationID)
+	_, err := repo.GetApplication(context.Background(), validApplicationID)
 
 	assert.Equal(t, InternalError, err)
 }
@@ -80,11 +264,194 @@ func TestGetApplicationInternalError(t *testing.T) {
 func TestGetApplicationsWithStatusInternalError(t *testing.T) {
 	// Setup
 	mongo := new(mocks.MongoCaller)
-	mongo.On("Find", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	_, _, err := repo.GetApplicationsWithStatus(context.Background(), shared_models.Pending, getPageRequest(""))
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestGetApplicationsWithStatusUsesCallerContext(t *testing.T) {
+	// Setup
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	repo.GetApplicationsWithStatus(ctx, shared_models.Pending, getPageRequest(""))
+
+	// Assert that the query is made with a deadline, and is cancelled along with the caller's context
+	queryCtx := mongo.Calls[0].Arguments.Get(0).(context.Context)
+	_, hasDeadline := queryCtx.Deadline()
+	assert.True(t, hasDeadline)
+	assert.Equal(t, context.Canceled, queryCtx.Err())
+}
+
+func TestGetApplicationsWithStatusReturnsNextPageToken(t *testing.T) {
+	// Setup
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(getApplicationsCursor(ids), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
 
-	repo := NewMongoRepository(mongo)
+	entries, nextPageToken, err := repo.GetApplicationsWithStatus(context.Background(), shared_models.Pending, getPageRequest(""))
 
-	_, err := repo.GetApplicationsWithStatus(shared_models.Pending)
+	assert.Nil(t, err)
+	// One more application than the limit is fetched, and only used to tell that there is a next page
+	assert.Len(t, entries, 2)
+	findOptions := mongo.Calls[0].Arguments.Get(2).(*options.FindOptions)
+	assert.Equal(t, int64(3), *findOptions.Limit)
+	lastID, _ := decodePageToken(nextPageToken)
+	assert.Equal(t, ids[1], lastID)
+}
+
+func TestGetApplicationsWithStatusLastPage(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(getApplicationsCursor([]primitive.ObjectID{primitive.NewObjectID()}), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	entries, nextPageToken, err := repo.GetApplicationsWithStatus(context.Background(), shared_models.Pending, getPageRequest(""))
+
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Empty(t, nextPageToken)
+}
+
+func TestGetApplicationsWithStatusContinuesFromPageToken(t *testing.T) {
+	// Setup
+	lastID := primitive.NewObjectID()
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(getApplicationsCursor(nil), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	page := getPageRequest(encodePageToken(lastID))
+	page.Sort = shared_models.Newest
+	_, _, err := repo.GetApplicationsWithStatus(context.Background(), shared_models.Pending, page)
+
+	assert.Nil(t, err)
+	filter := mongo.Calls[0].Arguments.Get(1).(bson.M)
+	assert.Equal(t, bson.M{"$lt": lastID}, filter["_id"])
+	findOptions := mongo.Calls[0].Arguments.Get(2).(*options.FindOptions)
+	assert.Equal(t, bson.D{{Key: "_id", Value: -1}}, findOptions.Sort)
+}
+
+func TestGetApplicationsWithStatusInvalidPageToken(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	_, _, err := repo.GetApplicationsWithStatus(context.Background(), shared_models.Pending, getPageRequest("not a token"))
+
+	assert.Equal(t, ErrInvalidPageToken, err)
+	mongo.AssertNotCalled(t, "Find", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchApplicationsCombinesFilters(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(getApplicationsCursor(nil), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	from := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	lastID := primitive.NewObjectIDFromTimestamp(from.AddDate(0, 0, 1))
+	filter := shared_models.ApplicationFilter{
+		LastName:        lastName,
+		FirstNamePrefix: "Fi.",
+		CreatedFrom:     &from,
+		CreatedTo:       &to,
+		Statuses:        []shared_models.Status{shared_models.Completed, shared_models.Rejected},
+	}
+	_, _, err := repo.SearchApplications(context.Background(), filter, getPageRequest(encodePageToken(lastID)))
+
+	assert.Nil(t, err)
+	search := mongo.Calls[0].Arguments.Get(1).(bson.M)
+	assert.Equal(t, lastName, search["lastname"])
+	// The prefix is anchored, and escaped so that it is not treated as a pattern
+	assert.Equal(t, primitive.Regex{Pattern: `^Fi\.`}, search["firstname"])
+	assert.Equal(t, bson.M{"$in": filter.Statuses}, search["status"])
+	// The creation date range and page token are both applied to the ID
+	assert.Equal(t, bson.M{
+		"$gte": objectIDAt(from),
+		"$lt":  objectIDAt(to),
+		"$gt":  lastID,
+	}, search["_id"])
+	// An application created in the first second of the range is included
+	assert.True(t, primitive.NewObjectIDFromTimestamp(from).Hex() >= objectIDAt(from).Hex())
+}
+
+func TestSearchApplicationsInternalError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	_, _, err := repo.SearchApplications(context.Background(), shared_models.ApplicationFilter{LastName: lastName}, getPageRequest(""))
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestExportApplicationsStreamsEachApplication(t *testing.T) {
+	// Setup
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(getApplicationsCursor(ids), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	var exported []primitive.ObjectID
+	err := repo.ExportApplications(context.Background(), shared_models.ApplicationFilter{}, func(entry shared_models.ApplicationEntry) error {
+		exported = append(exported, entry.ID)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, ids, exported)
+	// The cursor is read in batches, rather than all at once
+	findOptions := mongo.Calls[0].Arguments.Get(2).(*options.FindOptions)
+	assert.Equal(t, int32(exportBatchSize), *findOptions.BatchSize)
+	assert.Nil(t, findOptions.Limit)
+}
+
+func TestExportApplicationsStopsOnError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(getApplicationsCursor([]primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	writeErr := errors.New("client went away")
+	calls := 0
+	err := repo.ExportApplications(context.Background(), shared_models.ApplicationFilter{}, func(entry shared_models.ApplicationEntry) error {
+		calls++
+		return writeErr
+	})
+
+	assert.Equal(t, writeErr, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestExportApplicationsInternalError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.ExportApplications(context.Background(), shared_models.ApplicationFilter{}, func(entry shared_models.ApplicationEntry) error {
+		return nil
+	})
 
 	assert.Equal(t, InternalError, err)
 }
@@ -92,31 +459,398 @@ func TestGetApplicationsWithStatusInternalError(t *testing.T) {
 func TestUpdateApplicationStatusInternalError(t *testing.T) {
 	// Setup
 	mongo := new(mocks.MongoCaller)
-	mongo.On("UpdateByID", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.UpdateApplicationStatus(context.Background(), validApplicationID, shared_models.StatusChange{Status: shared_models.Polling})
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestUpdateApplicationStatusAppendsHistory(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	change := shared_models.StatusChange{Status: shared_models.Completed, Source: shared_models.PollApplicationService}
+	err := repo.UpdateApplicationStatus(context.Background(), validApplicationID, change)
+
+	assert.Nil(t, err)
+	update := mongo.Calls[0].Arguments.Get(2).(bson.M)
+	set := update["$set"].(bson.M)
+	pushed := update["$push"].(bson.M)["history"].(shared_models.StatusChange)
+	assert.Equal(t, shared_models.Completed, set["status"])
+	// A terminal status records when the application was decided
+	assert.Equal(t, pushed.At, set["decided_at"])
+	assert.Equal(t, shared_models.PollApplicationService, pushed.Source)
+	assert.False(t, pushed.At.IsZero())
+	// The update only applies to an application which may move to the new status
+	filter := mongo.Calls[0].Arguments.Get(1).(bson.M)
+	assert.Equal(t, bson.M{"$in": shared_models.StatusesBefore(shared_models.Completed)}, filter["status"])
+}
+
+func TestUpdateApplicationStatusIllegalTransition(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUnmatchedUpdateResult(), nil)
+	mongo.On("FindOne", mock.Anything, mock.Anything).Return(getApplicationResult(shared_models.Completed))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	change := shared_models.StatusChange{Status: shared_models.Rejected, Source: shared_models.PollApplicationService}
+	err := repo.UpdateApplicationStatus(context.Background(), validApplicationID, change)
+
+	var transitionErr *shared_models.TransitionError
+	assert.True(t, errors.As(err, &transitionErr))
+	assert.Equal(t, shared_models.Completed, transitionErr.From)
+	assert.Equal(t, shared_models.Rejected, transitionErr.To)
+}
+
+func TestUpdateApplicationStatusNotFound(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUnmatchedUpdateResult(), nil)
+	mongo.On("FindOne", mock.Anything, mock.Anything).Return(getNoDocumentsResult())
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.UpdateApplicationStatus(context.Background(), validApplicationID, shared_models.StatusChange{Status: shared_models.Polling})
+
+	var transitionErr *shared_models.TransitionError
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &transitionErr))
+	assert.NotEqual(t, InternalError, err)
+}
+
+func TestUpdateApplicationStatusWithEventWritesEvent(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+	eventOutbox := new(mocks.MongoCaller)
+	eventOutbox.On("InsertOne", mock.Anything, mock.Anything).Return(nil, nil)
 
-	repo := NewMongoRepository(mongo)
+	repo := NewMongoRepository(Collections{Applications: mongo, EventOutbox: eventOutbox}, getTransactor(), getConfig())
 
-	err := repo.UpdateApplicationStatus(validApplicationID, shared_models.Pending)
+	change := shared_models.StatusChange{Status: shared_models.Rejected, Source: shared_models.PollApplicationService, Reason: "declined"}
+	err := repo.UpdateApplicationStatusWithEvent(context.Background(), validApplicationID, change)
+
+	assert.Nil(t, err)
+	entry := eventOutbox.Calls[0].Arguments.Get(1).(shared_models.EventOutboxEntry)
+	assert.Equal(t, validApplicationID, entry.Event.ApplicationID)
+	assert.Equal(t, shared_models.Rejected, entry.Event.Status)
+	assert.Equal(t, "declined", entry.Event.Reason)
+	assert.False(t, entry.Sent)
+}
+
+func TestUpdateApplicationStatusWithEventIllegalTransition(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUnmatchedUpdateResult(), nil)
+	mongo.On("FindOne", mock.Anything, mock.Anything).Return(getApplicationResult(shared_models.Withdrawn))
+	eventOutbox := new(mocks.MongoCaller)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, EventOutbox: eventOutbox}, getTransactor(), getConfig())
+
+	change := shared_models.StatusChange{Status: shared_models.Completed, Source: shared_models.PollApplicationService}
+	err := repo.UpdateApplicationStatusWithEvent(context.Background(), validApplicationID, change)
+
+	var transitionErr *shared_models.TransitionError
+	assert.True(t, errors.As(err, &transitionErr))
+	assert.Equal(t, shared_models.Withdrawn, transitionErr.From)
+	// No event is written for a change which did not happen
+	eventOutbox.AssertNotCalled(t, "InsertOne", mock.Anything, mock.Anything)
+}
+
+func TestUpdateApplicationStatusWithEventInternalError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+	eventOutbox := new(mocks.MongoCaller)
+	eventOutbox.On("InsertOne", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo, EventOutbox: eventOutbox}, getTransactor(), getConfig())
+
+	err := repo.UpdateApplicationStatusWithEvent(context.Background(), validApplicationID, shared_models.StatusChange{Status: shared_models.Completed})
 
 	assert.Equal(t, InternalError, err)
 }
 
+func TestRecordSubmissionOnlyOnce(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.RecordSubmission(context.Background(), validApplicationID, "bank-id", time.Now().UTC())
+
+	assert.Nil(t, err)
+	// The filter must not match an application whose submission has already been recorded
+	filter := mongo.Calls[0].Arguments.Get(1).(bson.M)
+	assert.Equal(t, bson.M{"$exists": false}, filter["bank_application_id"])
+	assert.Equal(t, bson.M{"$in": shared_models.StatusesBefore(shared_models.Submitted)}, filter["status"])
+}
+
+func TestRecordSubmissionOfWithdrawnApplication(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUnmatchedUpdateResult(), nil).Once()
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.RecordSubmission(context.Background(), validApplicationID, "bank-id", time.Now().UTC())
+
+	assert.Nil(t, err)
+	mongo.AssertNumberOfCalls(t, "UpdateOne", 2)
+	// The bank application ID is recorded, without changing the status of the withdrawn application
+	filter := mongo.Calls[1].Arguments.Get(1).(bson.M)
+	assert.Equal(t, shared_models.Withdrawn, filter["status"])
+	assert.Equal(t, bson.M{"$exists": false}, filter["bank_application_id"])
+	set := mongo.Calls[1].Arguments.Get(2).(bson.M)["$set"].(bson.M)
+	assert.Equal(t, "bank-id", set["bank_application_id"])
+	assert.NotContains(t, set, "status")
+}
+
+func TestRecordSubmissionInternalError(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.RecordSubmission(context.Background(), validApplicationID, "bank-id", time.Now().UTC())
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestRecordReconciliationKeepsEarlierState(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	requiredAt := time.Now().UTC()
+	err := repo.RecordReconciliation(context.Background(), validApplicationID, shared_models.Reconciliation{Reason: "withdrawn", RequiredAt: requiredAt})
+
+	assert.Nil(t, err)
+	filter := mongo.Calls[0].Arguments.Get(1).(bson.M)
+	assert.Equal(t, shared_models.Withdrawn, filter["status"])
+	update := mongo.Calls[0].Arguments.Get(2).(bson.M)
+	// The first time that reconciliation was required is kept, and a bank decision is not cleared
+	assert.Equal(t, bson.M{"reconciliation.required_at": requiredAt}, update["$min"])
+	assert.NotContains(t, update["$set"], "reconciliation.bank_decision")
+}
+
+func TestRecordReconciliationWithBankDecision(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUpdateResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	reconciliation := shared_models.Reconciliation{Reason: "withdrawn", BankDecision: shared_models.Completed, RequiredAt: time.Now().UTC()}
+	err := repo.RecordReconciliation(context.Background(), validApplicationID, reconciliation)
+
+	assert.Nil(t, err)
+	set := mongo.Calls[0].Arguments.Get(2).(bson.M)["$set"].(bson.M)
+	assert.Equal(t, shared_models.Completed, set["reconciliation.bank_decision"])
+}
+
+func TestRecordReconciliationNotWithdrawn(t *testing.T) {
+	// Setup
+	mongo := new(mocks.MongoCaller)
+	mongo.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything).Return(getUnmatchedUpdateResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+
+	err := repo.RecordReconciliation(context.Background(), validApplicationID, shared_models.Reconciliation{Reason: "withdrawn"})
+
+	assert.NotNil(t, err)
+	assert.NotEqual(t, InternalError, err)
+}
+
 func TestRemoveApplicationInternalError(t *testing.T) {
 	// Setup
 	mongo := new(mocks.MongoCaller)
 	mongo.On("DeleteOne", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
 
-	repo := NewMongoRepository(mongo)
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
 
-	err := repo.RemoveApplication(validApplicationID)
+	err := repo.RemoveApplication(context.Background(), validApplicationID)
 
 	assert.Equal(t, InternalError, err)
 }
 
+func TestGetUnsentOutboxEntriesInternalError(t *testing.T) {
+	// Setup
+	outbox := new(mocks.MongoCaller)
+	outbox.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Outbox: outbox}, getTransactor(), getConfig())
+
+	_, err := repo.GetUnsentOutboxEntries(context.Background(), 10)
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestMarkOutboxEntrySentInternalError(t *testing.T) {
+	// Setup
+	outbox := new(mocks.MongoCaller)
+	outbox.On("UpdateByID", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Outbox: outbox}, getTransactor(), getConfig())
+
+	err := repo.MarkOutboxEntrySent(context.Background(), validApplicationID)
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestMarkOutboxEntrySentInvalidID(t *testing.T) {
+	// Setup
+	outbox := new(mocks.MongoCaller)
+
+	repo := NewMongoRepository(Collections{Outbox: outbox}, getTransactor(), getConfig())
+
+	err := repo.MarkOutboxEntrySent(context.Background(), "not an ID")
+
+	assert.True(t, errors.Is(err, primitive.ErrInvalidHex))
+	outbox.AssertNotCalled(t, "UpdateByID", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMarkEventOutboxEntrySentInvalidID(t *testing.T) {
+	// Setup
+	eventOutbox := new(mocks.MongoCaller)
+
+	repo := NewMongoRepository(Collections{EventOutbox: eventOutbox}, getTransactor(), getConfig())
+
+	err := repo.MarkEventOutboxEntrySent(context.Background(), "not an ID")
+
+	assert.NotNil(t, err)
+	eventOutbox.AssertNotCalled(t, "UpdateByID", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetIdempotencyKeyNotFound(t *testing.T) {
+	// Setup
+	idempotency := new(mocks.MongoCaller)
+	idempotency.On("FindOne", mock.Anything, mock.Anything).Return(mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil))
+
+	repo := NewMongoRepository(Collections{Idempotency: idempotency}, getTransactor(), getConfig())
+
+	_, err := repo.GetIdempotencyKey(context.Background(), "key")
+
+	assert.Equal(t, ErrIdempotencyKeyNotFound, err)
+}
+
+func TestGetIdempotencyKeyInternalError(t *testing.T) {
+	// Setup
+	idempotency := new(mocks.MongoCaller)
+	idempotency.On("FindOne", mock.Anything, mock.Anything).Return(getSingleResult())
+
+	repo := NewMongoRepository(Collections{Idempotency: idempotency}, getTransactor(), getConfig())
+
+	_, err := repo.GetIdempotencyKey(context.Background(), "key")
+
+	assert.Equal(t, InternalError, err)
+}
+
+func TestRecordWebhookAttempt(t *testing.T) {
+	// Setup
+	webhooks := new(mocks.MongoCaller)
+	webhooks.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+
+	repo := NewMongoRepository(Collections{Webhooks: webhooks}, getTransactor(), getConfig())
+	attempt := shared_models.WebhookAttemptEntry{ApplicationID: validApplicationID, Attempt: 1, ResponseStatus: 200, Delivered: true}
+
+	err := repo.RecordWebhookAttempt(context.Background(), attempt)
+
+	assert.Nil(t, err)
+	webhooks.AssertCalled(t, "InsertOne", mock.Anything, attempt)
+}
+
+func TestRecordWebhookAttemptInternalError(t *testing.T) {
+	// Setup
+	webhooks := new(mocks.MongoCaller)
+	webhooks.On("InsertOne", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	repo := NewMongoRepository(Collections{Webhooks: webhooks}, getTransactor(), getConfig())
+
+	err := repo.RecordWebhookAttempt(context.Background(), shared_models.WebhookAttemptEntry{ApplicationID: validApplicationID})
+
+	assert.Equal(t, InternalError, err)
+}
+
+// getTransactor returns a Transactor which runs the transaction function without a real session
+func getTransactor() *mocks.Transactor {
+	transactor := new(mocks.Transactor)
+	transactor.On("WithTransaction", mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, fn func(sessCtx context.Context) error) error {
+			return fn(ctx)
+		})
+	return transactor
+}
+
+func getNewApplication() shared_models.NewApplication {
+	return shared_models.NewApplication{
+		FirstName: firstName,
+		LastName:  lastName,
+		LoanDetails: shared_models.LoanDetails{
+			LoanAmount:   1500000,
+			Currency:     "GBP",
+			TermMonths:   36,
+			Purpose:      shared_models.Car,
+			AnnualIncome: 4200000,
+			Email:        "first.last@example.com",
+			DateOfBirth:  "1990-01-31",
+		},
+	}
+}
+
+func getConfig() sharedconfig.Config {
+	return sharedconfig.Config{DBReadTimeout: time.Second, DBWriteTimeout: time.Second, DBExportTimeout: time.Minute}
+}
+
 func getInsertOneResult() *mongo.InsertOneResult {
 	return &mongo.InsertOneResult{InsertedID: primitive.ObjectID{}}
 }
 
+func getInsertManyResult() *mongo.InsertManyResult {
+	return &mongo.InsertManyResult{}
+}
+
+func getUpdateResult() *mongo.UpdateResult {
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}
+}
+
+func getPageRequest(pageToken string) shared_models.PageRequest {
+	return shared_models.PageRequest{Limit: 2, PageToken: pageToken, Sort: shared_models.Oldest}
+}
+
+func getApplicationsCursor(ids []primitive.ObjectID) *mongo.Cursor {
+	var documents []interface{}
+	for _, id := range ids {
+		documents = append(documents, shared_models.ApplicationEntry{ID: id, Status: shared_models.Pending})
+	}
+	cursor, _ := mongo.NewCursorFromDocuments(documents, nil, nil)
+	return cursor
+}
+
+func getUnmatchedUpdateResult() *mongo.UpdateResult {
+	return &mongo.UpdateResult{}
+}
+
+func getApplicationResult(status shared_models.Status) *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(shared_models.ApplicationEntry{Status: status}, nil, nil)
+}
+
+func getNoDocumentsResult() *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
 func getSingleResult() *mongo.SingleResult {
 	var err error
 	var i interface{}
@@ -134,3 +868,20 @@ func getDuplicatekeyError() error {
 		Raw:     nil,
 	}
 }
+
+func TestExpiryDiffers(t *testing.T) {
+	// Mongo lists the expiry as an int32
+	raw, _ := bson.Marshal(bson.D{
+		{Key: "key", Value: bson.D{{Key: "created_at", Value: int32(1)}}},
+		{Key: "expireAfterSeconds", Value: int32(86400)},
+	})
+	var ttlIndex indexSpecification
+	assert.Nil(t, bson.Unmarshal(raw, &ttlIndex))
+
+	assert.False(t, expiryDiffers(ttlIndex, "created_at", 86400))
+	assert.True(t, expiryDiffers(ttlIndex, "created_at", 3600))
+	// Only the TTL index on the field is changed
+	assert.False(t, expiryDiffers(ttlIndex, "sent_at", 3600))
+	assert.False(t, expiryDiffers(indexSpecification{Key: bson.D{{Key: "created_at", Value: 1}}}, "created_at", 3600))
+	assert.False(t, expiryDiffers(indexSpecification{Key: bson.D{{Key: "sent", Value: 1}, {Key: "created_at", Value: 1}}}, "created_at", 3600))
+}
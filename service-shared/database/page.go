@@ -0,0 +1,46 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//ErrInvalidPageToken is returned when a page token was not issued by this repository, or has been altered
+var ErrInvalidPageToken = errors.New("the page token is not valid")
+
+/*
+pageToken is the content of a page token. Applications are sorted by their ID, which starts with the time
+the application was created, so a page continues from the ID of the last application in the previous one.
+This means that a page is not affected by applications being added or changing status in the meantime.
+
+Clients must treat the token as opaque, so that what it contains can change.
+*/
+type pageToken struct {
+	After string `json:"after"`
+}
+
+func encodePageToken(after primitive.ObjectID) string {
+	token, _ := json.Marshal(pageToken{After: after.Hex()})
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func decodePageToken(token string) (primitive.ObjectID, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidPageToken
+	}
+
+	var content pageToken
+	if err = json.Unmarshal(decoded, &content); err != nil {
+		return primitive.NilObjectID, ErrInvalidPageToken
+	}
+
+	after, err := primitive.ObjectIDFromHex(content.After)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidPageToken
+	}
+
+	return after, nil
+}
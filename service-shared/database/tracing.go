@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	sharedmodels "service-shared/shared-models"
+	"service-shared/tracing"
+	"time"
+)
+
+//applicationIDKey is the attribute recording our ID of the application which a span is working on
+const applicationIDKey = attribute.Key("loan.application_id")
+
+/*
+tracedRepository records a span for each call to a Repository, named after the method of MongoRepository.
+The span is passed on in the context of the call, so the work of the repository is traced as part of it.
+*/
+type tracedRepository struct {
+	repository Repository
+}
+
+func startSpan(ctx context.Context, method string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, semconv.DBSystemMongoDB, semconv.DBOperationKey.String(method))
+	return tracing.Tracer().Start(ctx, "MongoRepository."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...))
+}
+
+func (traced tracedRepository) CreateApplication(ctx context.Context, application sharedmodels.NewApplication, idempotencyKey *sharedmodels.IdempotencyKeyEntry) (string, error) {
+	ctx, span := startSpan(ctx, "CreateApplication")
+	applicationID, err := traced.repository.CreateApplication(ctx, application, idempotencyKey)
+	span.SetAttributes(applicationIDKey.String(applicationID))
+	tracing.End(span, err)
+	return applicationID, err
+}
+
+func (traced tracedRepository) CreateApplications(ctx context.Context, applications []sharedmodels.NewApplication) ([]string, error) {
+	ctx, span := startSpan(ctx, "CreateApplications", attribute.Int("loan.application_count", len(applications)))
+	applicationIDs, err := traced.repository.CreateApplications(ctx, applications)
+	tracing.End(span, err)
+	return applicationIDs, err
+}
+
+func (traced tracedRepository) GetApplication(ctx context.Context, applicationID string) (*sharedmodels.ApplicationEntry, error) {
+	ctx, span := startSpan(ctx, "GetApplication", applicationIDKey.String(applicationID))
+	entry, err := traced.repository.GetApplication(ctx, applicationID)
+	tracing.End(span, err)
+	return entry, err
+}
+
+func (traced tracedRepository) GetApplicationsWithStatus(ctx context.Context, status sharedmodels.Status, page sharedmodels.PageRequest) ([]sharedmodels.ApplicationEntry, string, error) {
+	ctx, span := startSpan(ctx, "GetApplicationsWithStatus", attribute.String("loan.status", string(status)))
+	entries, nextPageToken, err := traced.repository.GetApplicationsWithStatus(ctx, status, page)
+	tracing.End(span, err)
+	return entries, nextPageToken, err
+}
+
+func (traced tracedRepository) SearchApplications(ctx context.Context, filter sharedmodels.ApplicationFilter, page sharedmodels.PageRequest) ([]sharedmodels.ApplicationEntry, string, error) {
+	ctx, span := startSpan(ctx, "SearchApplications")
+	entries, nextPageToken, err := traced.repository.SearchApplications(ctx, filter, page)
+	tracing.End(span, err)
+	return entries, nextPageToken, err
+}
+
+func (traced tracedRepository) ExportApplications(ctx context.Context, filter sharedmodels.ApplicationFilter, fn func(entry sharedmodels.ApplicationEntry) error) error {
+	ctx, span := startSpan(ctx, "ExportApplications")
+	err := traced.repository.ExportApplications(ctx, filter, fn)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) UpdateApplicationStatus(ctx context.Context, applicationID string, change sharedmodels.StatusChange) error {
+	ctx, span := startSpan(ctx, "UpdateApplicationStatus",
+		applicationIDKey.String(applicationID), attribute.String("loan.status", string(change.Status)))
+	err := traced.repository.UpdateApplicationStatus(ctx, applicationID, change)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) UpdateApplicationStatusWithEvent(ctx context.Context, applicationID string, change sharedmodels.StatusChange) error {
+	ctx, span := startSpan(ctx, "UpdateApplicationStatusWithEvent",
+		applicationIDKey.String(applicationID), attribute.String("loan.status", string(change.Status)))
+	err := traced.repository.UpdateApplicationStatusWithEvent(ctx, applicationID, change)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) RecordSubmission(ctx context.Context, applicationID, bankApplicationID string, submittedAt time.Time) error {
+	ctx, span := startSpan(ctx, "RecordSubmission", applicationIDKey.String(applicationID))
+	err := traced.repository.RecordSubmission(ctx, applicationID, bankApplicationID, submittedAt)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) RecordReconciliation(ctx context.Context, applicationID string, reconciliation sharedmodels.Reconciliation) error {
+	ctx, span := startSpan(ctx, "RecordReconciliation", applicationIDKey.String(applicationID))
+	err := traced.repository.RecordReconciliation(ctx, applicationID, reconciliation)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) RemoveApplication(ctx context.Context, applicationID string) error {
+	ctx, span := startSpan(ctx, "RemoveApplication", applicationIDKey.String(applicationID))
+	err := traced.repository.RemoveApplication(ctx, applicationID)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) GetUnsentOutboxEntries(ctx context.Context, limit int) ([]sharedmodels.OutboxEntry, error) {
+	ctx, span := startSpan(ctx, "GetUnsentOutboxEntries")
+	entries, err := traced.repository.GetUnsentOutboxEntries(ctx, limit)
+	tracing.End(span, err)
+	return entries, err
+}
+
+func (traced tracedRepository) MarkOutboxEntrySent(ctx context.Context, entryID string) error {
+	ctx, span := startSpan(ctx, "MarkOutboxEntrySent")
+	err := traced.repository.MarkOutboxEntrySent(ctx, entryID)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) GetUnsentEventOutboxEntries(ctx context.Context, limit int) ([]sharedmodels.EventOutboxEntry, error) {
+	ctx, span := startSpan(ctx, "GetUnsentEventOutboxEntries")
+	entries, err := traced.repository.GetUnsentEventOutboxEntries(ctx, limit)
+	tracing.End(span, err)
+	return entries, err
+}
+
+func (traced tracedRepository) MarkEventOutboxEntrySent(ctx context.Context, entryID string) error {
+	ctx, span := startSpan(ctx, "MarkEventOutboxEntrySent")
+	err := traced.repository.MarkEventOutboxEntrySent(ctx, entryID)
+	tracing.End(span, err)
+	return err
+}
+
+func (traced tracedRepository) GetIdempotencyKey(ctx context.Context, key string) (*sharedmodels.IdempotencyKeyEntry, error) {
+	ctx, span := startSpan(ctx, "GetIdempotencyKey")
+	entry, err := traced.repository.GetIdempotencyKey(ctx, key)
+	// Most keys are being used for the first time, so a missing key is not a failure
+	if errors.Is(err, ErrIdempotencyKeyNotFound) {
+		span.End()
+	} else {
+		tracing.End(span, err)
+	}
+	return entry, err
+}
+
+func (traced tracedRepository) RecordWebhookAttempt(ctx context.Context, attempt sharedmodels.WebhookAttemptEntry) error {
+	ctx, span := startSpan(ctx, "RecordWebhookAttempt", applicationIDKey.String(attempt.ApplicationID))
+	err := traced.repository.RecordWebhookAttempt(ctx, attempt)
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	mocks "service-shared/mocks/database"
+	shared_models "service-shared/shared-models"
+	"strings"
+	"testing"
+)
+
+func TestCreateApplicationRecordsSpan(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	_, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
+
+	assert.Nil(t, err)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "MongoRepository.CreateApplication", spans[0].Name)
+	assert.Equal(t, codes.Unset, spans[0].Status.Code)
+}
+
+func TestCreateApplicationStoresTraceContextInOutbox(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	mongo := new(mocks.MongoCaller)
+	outbox := new(mocks.MongoCaller)
+
+	mongo.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+	outbox.On("InsertOne", mock.Anything, mock.Anything).Return(getInsertOneResult(), nil)
+
+	repo := NewMongoRepository(Collections{Applications: mongo, Outbox: outbox}, getTransactor(), getConfig())
+	_, err := repo.CreateApplication(context.Background(), getNewApplication(), nil)
+
+	assert.Nil(t, err)
+	// The message is published later, as a child of the span which created the application
+	spanID := exporter.GetSpans()[0].SpanContext.SpanID().String()
+	outbox.AssertCalled(t, "InsertOne", mock.Anything, mock.MatchedBy(func(entry shared_models.OutboxEntry) bool {
+		return strings.Contains(entry.TraceContext["traceparent"], spanID)
+	}))
+}
+
+func TestInternalErrorRecordedOnSpan(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	mongo := new(mocks.MongoCaller)
+	mongo.On("FindOne", mock.Anything, mock.Anything).Return(getSingleResult())
+
+	repo := NewMongoRepository(Collections{Applications: mongo}, getTransactor(), getConfig())
+	_, err := repo.GetApplication(context.Background(), validApplicationID)
+
+	assert.NotNil(t, err)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
+
+func TestGetIdempotencyKeyNotFoundIsNotRecordedAsError(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	idempotency := new(mocks.MongoCaller)
+	idempotency.On("FindOne", mock.Anything, mock.Anything).Return(getNoDocumentsResult())
+
+	repo := NewMongoRepository(Collections{Idempotency: idempotency}, getTransactor(), getConfig())
+	_, err := repo.GetIdempotencyKey(context.Background(), "key")
+
+	assert.ErrorIs(t, err, ErrIdempotencyKeyNotFound)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Unset, spans[0].Status.Code)
+}
+
+func setUpTracing() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
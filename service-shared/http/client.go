@@ -1,50 +1,107 @@
 package http
 
 import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"service-shared/tracing"
 )
 
+//maxResponseBodyBytes is the most of a response body which is read. The bank and webhook receivers
+//only need to send a short body, and a webhook receiver is chosen by a client so cannot be trusted to.
+const maxResponseBodyBytes = 1 << 20
+
 type ClientResponse struct {
 	StatusCode   int
 	ResponseBody []byte
 }
 
-//Client acts as a wrapper interface to allow for easier unit testing
+//Client acts as a wrapper interface to allow for easier unit testing.
+//Requests are cancelled if ctx is done before the response has been read.
 type Client interface {
-	Post(url, contentType string, body io.Reader) (resp *ClientResponse, err error)
-	Get(url string) (resp *ClientResponse, err error)
+	Post(ctx context.Context, url, contentType string, body io.Reader) (resp *ClientResponse, err error)
+	PostWithHeaders(ctx context.Context, url string, headers map[string]string, body io.Reader) (resp *ClientResponse, err error)
+	Get(ctx context.Context, url string) (resp *ClientResponse, err error)
 }
 
+//DefaultClient sends requests with HttpClient. Each request is recorded as a client span, and carries
+//the trace context in its headers so that the receiver can continue the trace.
 type DefaultClient struct {
 	HttpClient *http.Client
 }
 
-func (client DefaultClient) Get(url string) (*ClientResponse, error) {
-	resp, err := client.HttpClient.Get(url)
+func (client DefaultClient) Get(ctx context.Context, url string) (*ClientResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	return createResponse(resp), nil
+	return client.do(req)
 }
 
-func (client DefaultClient) Post(url, contentType string, body io.Reader) (*ClientResponse, error) {
-	resp, err := client.HttpClient.Post(url, contentType, body)
+func (client DefaultClient) Post(ctx context.Context, url, contentType string, body io.Reader) (*ClientResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", contentType)
+
+	return client.do(req)
+}
+
+//PostWithHeaders sends a POST request with each of headers set, for example Content-Type and a signature.
+func (client DefaultClient) PostWithHeaders(ctx context.Context, url string, headers map[string]string, body io.Reader) (*ClientResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return client.do(req)
+}
+
+func (client DefaultClient) do(req *http.Request) (*ClientResponse, error) {
+	ctx, span := tracing.Tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(req)...))
+	defer span.End()
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		tracing.RecordError(span, err, "")
+		return nil, err
+	}
 	defer resp.Body.Close()
 
-	return createResponse(resp), nil
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(resp.StatusCode, trace.SpanKindClient))
+	response, err := createResponse(resp)
+	if err != nil {
+		tracing.RecordError(span, err, "")
+		return nil, err
+	}
+
+	return response, nil
 }
 
-func createResponse(resp *http.Response) *ClientResponse {
-	respBody, _ := ioutil.ReadAll(resp.Body)
+//createResponse reads up to maxResponseBodyBytes of the body of resp, the rest of a longer body is discarded.
+func createResponse(resp *http.Response) (*ClientResponse, error) {
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
 	return &ClientResponse{
 		StatusCode:   resp.StatusCode,
 		ResponseBody: respBody,
-	}
+	}, nil
 }
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostPropagatesTraceContext(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := DefaultClient{HttpClient: server.Client()}
+	resp, err := client.Post(context.Background(), server.URL, "application/json", strings.NewReader("{}"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "HTTP POST", spans[0].Name)
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind)
+	assert.Contains(t, traceparent, spans[0].SpanContext.SpanID().String())
+}
+
+func TestGetRecordsServerError(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := DefaultClient{HttpClient: server.Client()}
+	resp, err := client.Get(context.Background(), server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
+
+func TestGetLimitsResponseBody(t *testing.T) {
+	// Setup
+	setUpTracing()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", maxResponseBodyBytes+1)))
+	}))
+	defer server.Close()
+
+	client := DefaultClient{HttpClient: server.Client()}
+	resp, err := client.Get(context.Background(), server.URL)
+
+	assert.Nil(t, err)
+	assert.Len(t, resp.ResponseBody, maxResponseBodyBytes)
+}
+
+func TestGetResponseBodyReadError(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The connection is closed before the promised body has been sent
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := DefaultClient{HttpClient: server.Client()}
+	resp, err := client.Get(context.Background(), server.URL)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.216.34":      true,
+		"2606:4700::1111":    true,
+		"127.0.0.1":          false,
+		"::1":                false,
+		"10.0.0.1":           false,
+		"172.18.0.5":         false,
+		"192.168.1.1":        false,
+		"169.254.169.254":    false,
+		"fe80::1":            false,
+		"fd00::1":            false,
+		"0.0.0.0":            false,
+		"100.64.0.1":         false,
+		"::ffff:127.0.0.1":   false,
+		"64:ff9b::a9fe:a9fe": false,
+	} {
+		assert.Equal(t, public, IsPublicIP(net.ParseIP(address)), address)
+	}
+}
+
+func TestPublicOnlyClientRefusesLoopback(t *testing.T) {
+	// Setup
+	received := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+
+	client := DefaultClient{HttpClient: NewPublicOnlyClient()}
+	_, err := client.Post(context.Background(), server.URL, "application/json", strings.NewReader("{}"))
+
+	assert.True(t, errors.Is(err, ErrNonPublicAddress))
+	assert.False(t, received)
+}
+
+func TestPublicOnlyClientDoesNotFollowRedirects(t *testing.T) {
+	client := NewPublicOnlyClient()
+	req := httptest.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/", nil)
+
+	err := client.CheckRedirect(req, []*http.Request{httptest.NewRequest(http.MethodPost, "https://example.com/loans", nil)})
+
+	assert.Equal(t, http.ErrUseLastResponse, err)
+}
+
+func setUpTracing() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
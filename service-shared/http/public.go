@@ -0,0 +1,98 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+//ErrNonPublicAddress is returned when a client from NewPublicOnlyClient is asked to connect to an address which is not public.
+var ErrNonPublicAddress = errors.New("the address is not a public address")
+
+//nonPublicNetworks are reserved ranges which net.IP does not have a method for
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // carrier grade NAT
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved, including the broadcast address
+	"64:ff9b::/96",   // NAT64, which can reach any IPv4 address
+	"64:ff9b:1::/48", // local use NAT64
+	"2001:db8::/32",  // documentation
+	"fec0::/10",      // deprecated site local
+)
+
+/*
+IsPublicIP returns false if ip is a loopback, private, link-local, unspecified, multicast or otherwise reserved address.
+Link-local addresses include 169.254.169.254, which cloud providers serve instance metadata and credentials from.
+Private addresses include those of the other containers on a docker network.
+*/
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+NewPublicOnlyClient returns an http.Client for sending requests to URLs supplied by clients, such as webhooks.
+
+The address of each connection is checked after its host name has been resolved, so a host name which resolves to
+a loopback, private or link-local address is refused with ErrNonPublicAddress, as is an IP address in the URL.
+Redirects are not followed, because a public receiver could otherwise redirect the request to an internal address.
+The response to the request is returned instead. Proxies set in the environment are not used.
+*/
+func NewPublicOnlyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnlyControl,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+//publicOnlyControl refuses to connect to address unless it is a public IP address. It is called with the resolved address.
+func publicOnlyControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("%w : %s", ErrNonPublicAddress, host)
+	}
+
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
@@ -1,8 +1,13 @@
 package message_queue
 
 import (
+	"context"
 	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"service-shared/tracing"
+	"time"
 )
 
 //DeliveryHandler provides an abstraction for handling RabbitMQ deliveries.
@@ -11,9 +16,31 @@ type DeliveryHandler interface {
 	Ack(multiple bool, delivery amqp.Delivery) error
 	Nack(multiple bool, requeue bool, delivery amqp.Delivery) error
 	Reject(requeue bool, delivery amqp.Delivery) error
+	DeadLetter(reason string, delivery amqp.Delivery) error
 }
 
-type AmqpDeliveryHandler struct{}
+/*
+AmqpDeliveryHandler handles deliveries consumed from queueName.
+
+Dead-lettered deliveries are published to deadLetterExchange, from where they are routed
+to the dead letter queue for queueName. See DeclareQueue.
+*/
+type AmqpDeliveryHandler struct {
+	publisher          Publisher
+	deadLetterExchange string
+	queueName          string
+	serviceName        string
+}
+
+//NewAmqpDeliveryHandler returns an AmqpDeliveryHandler. The serviceName is recorded on any message which is dead-lettered.
+func NewAmqpDeliveryHandler(publisher Publisher, deadLetterExchange, queueName, serviceName string) AmqpDeliveryHandler {
+	return AmqpDeliveryHandler{
+		publisher:          publisher,
+		deadLetterExchange: deadLetterExchange,
+		queueName:          queueName,
+		serviceName:        serviceName,
+	}
+}
 
 func (handler AmqpDeliveryHandler) Ack(multiple bool, delivery amqp.Delivery) error {
 	return delivery.Ack(multiple)
@@ -27,6 +54,47 @@ func (handler AmqpDeliveryHandler) Reject(requeue bool, delivery amqp.Delivery)
 	return delivery.Reject(requeue)
 }
 
+/*
+DeadLetter moves a delivery to the dead letter queue, recording the reason, the service and
+the number of times that the message has failed in the message headers. This allows an operator
+to inspect, and later replay, the failed message.
+
+The message is republished to the dead letter exchange and the original delivery is acked once the
+broker has confirmed it. If the message cannot be republished, the delivery is nacked without requeue
+so that the broker dead-letters it instead. In this case the failure headers are not recorded, but the
+broker adds its own x-death header.
+*/
+func (handler AmqpDeliveryHandler) DeadLetter(reason string, delivery amqp.Delivery) error {
+	headers := amqp.Table{}
+	for key, value := range delivery.Headers {
+		headers[key] = value
+	}
+	headers[FailureReasonHeader] = reason
+	headers[FailureServiceHeader] = handler.serviceName
+	headers[FailureAttemptsHeader] = int32(FailureAttempts(delivery) + 1)
+	headers[FailedAtHeader] = time.Now().UTC()
+
+	err := handler.publisher.Publish(
+		handler.deadLetterExchange,
+		handler.queueName,
+		amqp.Publishing{
+			Headers:       headers,
+			DeliveryMode:  amqp.Persistent,
+			ContentType:   delivery.ContentType,
+			CorrelationId: delivery.CorrelationId,
+			MessageId:     delivery.MessageId,
+			Timestamp:     delivery.Timestamp,
+			Type:          delivery.Type,
+			Body:          delivery.Body,
+		})
+	if err != nil {
+		log.Printf("Could not publish message to the dead letter exchange, nacking it instead : %s\n", err)
+		return handler.Nack(false, false, delivery)
+	}
+
+	return handler.Ack(false, delivery)
+}
+
 /*
 CheckError is a helper function. In the event that an error occurs, it will
 print the provided message and send the amqp delivery to the DLQ
@@ -34,11 +102,60 @@ Returns true iff err is not nil.
 */
 func CheckError(err error, msg string, delivery amqp.Delivery, handler DeliveryHandler) bool {
 	if err != nil {
-		fmt.Printf("%s : %s\n", msg, err)
-		// Future improvement : Setup queue so that Nack with requeue=false goes to DLQ
-		handler.Nack(false, false, delivery)
+		reason := FailureReason(err, msg)
+		fmt.Println(reason)
+		handler.DeadLetter(reason, delivery)
 		return true
 	}
 
 	return false
 }
+
+//FailureReason returns the reason recorded by CheckError when it dead-letters a delivery because of err.
+func FailureReason(err error, msg string) string {
+	if len(msg) > 0 {
+		return fmt.Sprintf("%s : %s", msg, err)
+	}
+
+	return err.Error()
+}
+
+/*
+CheckErrorContext is CheckError for work done using ctx. If ctx has been cancelled, for example
+because the service is shutting down, the error is most likely due to the cancellation rather than
+the message. In that case the delivery is requeued instead of being dead-lettered.
+The error is also recorded on the span of ctx, such as the one started by StartConsumeSpan.
+Returns true iff err is not nil.
+*/
+func CheckErrorContext(ctx context.Context, err error, msg string, delivery amqp.Delivery, handler DeliveryHandler) bool {
+	tracing.RecordError(trace.SpanFromContext(ctx), err, msg)
+	if err != nil && ctx.Err() != nil {
+		fmt.Printf("Requeueing message after the context was cancelled : %s\n", err)
+		handler.Nack(false, true, delivery)
+		return true
+	}
+
+	return CheckError(err, msg, delivery, handler)
+}
+
+//DeadLetterCallback is called by CheckErrorContextOnDeadLetter with the reason that a delivery was dead-lettered.
+type DeadLetterCallback func(ctx context.Context, reason string) error
+
+/*
+CheckErrorContextOnDeadLetter is CheckErrorContext, which also calls onDeadLetter once the delivery has been
+dead-lettered, for example to mark the application of the message as failed. onDeadLetter is not called when the
+delivery is requeued because ctx was cancelled. It is best effort, an error from it is logged.
+Returns true iff err is not nil.
+*/
+func CheckErrorContextOnDeadLetter(ctx context.Context, err error, msg string, delivery amqp.Delivery, handler DeliveryHandler, onDeadLetter DeadLetterCallback) bool {
+	if !CheckErrorContext(ctx, err, msg, delivery, handler) {
+		return false
+	}
+
+	if ctx.Err() == nil {
+		if callbackErr := onDeadLetter(ctx, FailureReason(err, msg)); callbackErr != nil {
+			log.Printf("Dead-lettered message %s, but could not handle it : %s\n", delivery.MessageId, callbackErr)
+		}
+	}
+	return true
+}
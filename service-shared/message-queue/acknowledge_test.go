@@ -1,24 +1,53 @@
 package message_queue
 
 import (
+	"context"
 	"errors"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	mocks "service-shared/mocks/message-queue"
 	"testing"
 )
 
+const (
+	queueName          = "queue"
+	deadLetterExchange = "dead_letters"
+	serviceName        = "test-service"
+)
+
+// fakeAcknowledger records how a delivery was acknowledged
+type fakeAcknowledger struct {
+	acked  bool
+	nacked bool
+}
+
+func (ack *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	ack.acked = true
+	return nil
+}
+
+func (ack *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	ack.nacked = true
+	return nil
+}
+
+func (ack *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	ack.nacked = true
+	return nil
+}
+
 func TestCheckErrorWhenError(t *testing.T) {
 	err := errors.New("")
 	msg := ""
 	delivery := amqp.Delivery{}
 	handler := new(mocks.DeliveryHandler)
-	handler.On("Nack", false, false, delivery).Return(nil)
+	handler.On("DeadLetter", mock.Anything, delivery).Return(nil)
 
 	hadError := CheckError(err, msg, delivery, handler)
 
 	assert.True(t, hadError)
-	handler.AssertCalled(t, "Nack", false, false, delivery)
+	handler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
 }
 
 func TestCheckErrorWhenNoError(t *testing.T) {
@@ -26,10 +55,144 @@ func TestCheckErrorWhenNoError(t *testing.T) {
 	msg := ""
 	delivery := amqp.Delivery{}
 	handler := new(mocks.DeliveryHandler)
-	handler.On("Nack", false, delivery).Return(nil)
+	handler.On("DeadLetter", mock.Anything, delivery).Return(nil)
 
 	hadError := CheckError(err, msg, delivery, handler)
 
 	assert.False(t, hadError)
-	handler.AssertNotCalled(t, "Nack", false, delivery)
+	handler.AssertNotCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestCheckErrorRecordsFailureReason(t *testing.T) {
+	err := errors.New("connection refused")
+	delivery := amqp.Delivery{}
+	handler := new(mocks.DeliveryHandler)
+	handler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	CheckError(err, "Could not contact the bank", delivery, handler)
+
+	handler.AssertCalled(t, "DeadLetter", FailureReason(err, "Could not contact the bank"), delivery)
+	assert.Equal(t, "Could not contact the bank : connection refused", FailureReason(err, "Could not contact the bank"))
+	assert.Equal(t, "connection refused", FailureReason(err, ""))
+}
+
+func TestCheckErrorContextRequeuesWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	delivery := amqp.Delivery{}
+	handler := new(mocks.DeliveryHandler)
+	handler.On("Nack", false, true, delivery).Return(nil)
+
+	hadError := CheckErrorContext(ctx, errors.New(""), "", delivery, handler)
+
+	assert.True(t, hadError)
+	handler.AssertCalled(t, "Nack", false, true, delivery)
+	handler.AssertNotCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestCheckErrorContextDeadLettersWhenNotCancelled(t *testing.T) {
+	delivery := amqp.Delivery{}
+	handler := new(mocks.DeliveryHandler)
+	handler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	hadError := CheckErrorContext(context.Background(), errors.New(""), "", delivery, handler)
+
+	assert.True(t, hadError)
+	handler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestCheckErrorContextOnDeadLetterCallsBack(t *testing.T) {
+	delivery := amqp.Delivery{}
+	handler := new(mocks.DeliveryHandler)
+	handler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	var reason string
+
+	hadError := CheckErrorContextOnDeadLetter(context.Background(), errors.New("bank unavailable"), "Could not poll", delivery, handler,
+		func(ctx context.Context, deadLetterReason string) error {
+			reason = deadLetterReason
+			return errors.New("the callback is best effort")
+		})
+
+	assert.True(t, hadError)
+	handler.AssertCalled(t, "DeadLetter", "Could not poll : bank unavailable", delivery)
+	assert.Equal(t, "Could not poll : bank unavailable", reason)
+}
+
+func TestCheckErrorContextOnDeadLetterNotCalledWhenRequeued(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	delivery := amqp.Delivery{}
+	handler := new(mocks.DeliveryHandler)
+	handler.On("Nack", false, true, delivery).Return(nil)
+	called := false
+
+	hadError := CheckErrorContextOnDeadLetter(ctx, errors.New(""), "", delivery, handler, func(ctx context.Context, reason string) error {
+		called = true
+		return nil
+	})
+
+	assert.True(t, hadError)
+	assert.False(t, called)
+}
+
+func TestCheckErrorContextOnDeadLetterNoError(t *testing.T) {
+	handler := new(mocks.DeliveryHandler)
+	called := false
+
+	hadError := CheckErrorContextOnDeadLetter(context.Background(), nil, "", amqp.Delivery{}, handler, func(ctx context.Context, reason string) error {
+		called = true
+		return nil
+	})
+
+	assert.False(t, hadError)
+	assert.False(t, called)
+}
+
+func TestDeadLetterRecordsFailure(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{Acknowledger: acknowledger, Body: []byte("body")}
+	publisher := new(mocks.Publisher)
+	publisher.On("Publish", deadLetterExchange, queueName, mock.Anything).Return(nil)
+
+	handler := NewAmqpDeliveryHandler(publisher, deadLetterExchange, queueName, serviceName)
+	err := handler.DeadLetter("reason", delivery)
+
+	assert.Nil(t, err)
+	assert.True(t, acknowledger.acked)
+	publisher.AssertCalled(t, "Publish", deadLetterExchange, queueName, mock.MatchedBy(func(msg amqp.Publishing) bool {
+		return msg.Headers[FailureReasonHeader] == "reason" &&
+			msg.Headers[FailureServiceHeader] == serviceName &&
+			msg.Headers[FailureAttemptsHeader] == int32(1) &&
+			string(msg.Body) == "body"
+	}))
+}
+
+func TestDeadLetterIncrementsAttempts(t *testing.T) {
+	delivery := amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		Headers:      amqp.Table{FailureAttemptsHeader: int32(2)},
+	}
+	publisher := new(mocks.Publisher)
+	publisher.On("Publish", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	handler := NewAmqpDeliveryHandler(publisher, deadLetterExchange, queueName, serviceName)
+	handler.DeadLetter("reason", delivery)
+
+	publisher.AssertCalled(t, "Publish", mock.Anything, mock.Anything, mock.MatchedBy(func(msg amqp.Publishing) bool {
+		return msg.Headers[FailureAttemptsHeader] == int32(3)
+	}))
+}
+
+func TestDeadLetterNacksWhenPublishFails(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{Acknowledger: acknowledger}
+	publisher := new(mocks.Publisher)
+	publisher.On("Publish", mock.Anything, mock.Anything, mock.Anything).Return(ErrPublishNacked)
+
+	handler := NewAmqpDeliveryHandler(publisher, deadLetterExchange, queueName, serviceName)
+	handler.DeadLetter("reason", delivery)
+
+	// The broker dead-letters the message instead
+	assert.True(t, acknowledger.nacked)
+	assert.False(t, acknowledger.acked)
 }
@@ -0,0 +1,86 @@
+package message_queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	sharedmodels "service-shared/shared-models"
+)
+
+// Content types of the messages on the create and poll queues
+const (
+	JSONContentType     = "application/json"
+	ProtobufContentType = "application/x-protobuf"
+)
+
+//ErrUnsupportedContentType is returned for a message in a format without a Codec.
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+/*
+Codec converts an Envelope to and from the body of an AMQP message. The content type of a Codec
+is set as the ContentType of each message it marshals, so that consumers can pick the Codec which
+unmarshals it with CodecFor.
+*/
+type Codec interface {
+	ContentType() string
+	Marshal(envelope sharedmodels.Envelope) ([]byte, error)
+	Unmarshal(body []byte) (sharedmodels.Envelope, error)
+}
+
+/*
+CodecFor returns the Codec for contentType. Messages published before content types were set
+have no content type, and are JSON.
+
+Returns an error wrapping ErrUnsupportedContentType if there is no Codec for contentType.
+*/
+func CodecFor(contentType string) (Codec, error) {
+	switch contentType {
+	case JSONContentType, "":
+		return JSONCodec{}, nil
+	case ProtobufContentType:
+		return ProtobufCodec{}, nil
+	}
+
+	return nil, fmt.Errorf("%w : %s", ErrUnsupportedContentType, contentType)
+}
+
+/*
+UnmarshalEnvelope unmarshals the body of delivery with the Codec for its ContentType. Consumers should
+use it rather than a particular Codec, so that they accept every format while publishers are migrated.
+*/
+func UnmarshalEnvelope(delivery amqp.Delivery) (sharedmodels.Envelope, error) {
+	codec, err := CodecFor(delivery.ContentType)
+	if err != nil {
+		return sharedmodels.Envelope{}, err
+	}
+
+	return codec.Unmarshal(delivery.Body)
+}
+
+//JSONCodec marshals an Envelope as JSON.
+type JSONCodec struct{}
+
+func (codec JSONCodec) ContentType() string {
+	return JSONContentType
+}
+
+func (codec JSONCodec) Marshal(envelope sharedmodels.Envelope) ([]byte, error) {
+	return json.Marshal(envelope)
+}
+
+/*
+Unmarshal reads body into an Envelope. A body without an envelope is a bare payload, published before
+envelopes were introduced, and is returned as the Payload of an Envelope without a SchemaVersion.
+*/
+func (codec JSONCodec) Unmarshal(body []byte) (sharedmodels.Envelope, error) {
+	var envelope sharedmodels.Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return sharedmodels.Envelope{}, err
+	}
+	if envelope.SchemaVersion == 0 && len(envelope.Payload) == 0 {
+		return sharedmodels.Envelope{Payload: body}, nil
+	}
+
+	return envelope, nil
+}
@@ -0,0 +1,173 @@
+package message_queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"service-shared/message-queue/pb"
+	sharedmodels "service-shared/shared-models"
+	"time"
+)
+
+/*
+ProtobufCodec marshals an Envelope as the pb.Envelope message defined in pb/messages.proto, with its
+payload as the message for its Type.
+
+The Payload of an Envelope is JSON whichever format it was published in, so ProtobufCodec converts the
+payload between the two. Fields in the .proto files are only ever added, never renumbered, so a payload
+of an earlier schema version is read with the current message, and then upgraded like a JSON payload.
+*/
+type ProtobufCodec struct{}
+
+func (codec ProtobufCodec) ContentType() string {
+	return ProtobufContentType
+}
+
+func (codec ProtobufCodec) Marshal(envelope sharedmodels.Envelope) ([]byte, error) {
+	var payload proto.Message
+	switch envelope.Type {
+	case sharedmodels.CreateLoanMessageType:
+		var message sharedmodels.CreateLoanMessage
+		if err := json.Unmarshal(envelope.Payload, &message); err != nil {
+			return nil, err
+		}
+		payload = createLoanMessageToProto(message)
+	case sharedmodels.PollLoanMessageType:
+		var message sharedmodels.PollLoanMessage
+		if err := json.Unmarshal(envelope.Payload, &message); err != nil {
+			return nil, err
+		}
+		payload = pollLoanMessageToProto(message)
+	default:
+		return nil, errors.New(fmt.Sprintf("No protobuf message for type %s", envelope.Type))
+	}
+
+	body, err := proto.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(&pb.Envelope{
+		SchemaVersion: int32(envelope.SchemaVersion),
+		MessageId:     envelope.MessageID,
+		CorrelationId: envelope.CorrelationID,
+		ProducedAt:    timestampToProto(envelope.ProducedAt),
+		Producer:      envelope.Producer,
+		Type:          envelope.Type,
+		Payload:       body,
+	})
+}
+
+func (codec ProtobufCodec) Unmarshal(body []byte) (sharedmodels.Envelope, error) {
+	var envelope pb.Envelope
+	if err := proto.Unmarshal(body, &envelope); err != nil {
+		return sharedmodels.Envelope{}, err
+	}
+
+	var payload interface{}
+	switch envelope.Type {
+	case sharedmodels.CreateLoanMessageType:
+		var message pb.CreateLoanMessage
+		if err := proto.Unmarshal(envelope.Payload, &message); err != nil {
+			return sharedmodels.Envelope{}, err
+		}
+		payload = createLoanMessageFromProto(&message)
+	case sharedmodels.PollLoanMessageType:
+		var message pb.PollLoanMessage
+		if err := proto.Unmarshal(envelope.Payload, &message); err != nil {
+			return sharedmodels.Envelope{}, err
+		}
+		payload = pollLoanMessageFromProto(&message)
+	default:
+		return sharedmodels.Envelope{}, errors.New(fmt.Sprintf("No protobuf message for type %s", envelope.Type))
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return sharedmodels.Envelope{}, err
+	}
+
+	return sharedmodels.Envelope{
+		SchemaVersion: int(envelope.SchemaVersion),
+		MessageID:     envelope.MessageId,
+		CorrelationID: envelope.CorrelationId,
+		ProducedAt:    timestampFromProto(envelope.GetProducedAt()),
+		Producer:      envelope.Producer,
+		Type:          envelope.Type,
+		Payload:       jsonPayload,
+	}, nil
+}
+
+func createLoanMessageToProto(message sharedmodels.CreateLoanMessage) *pb.CreateLoanMessage {
+	return &pb.CreateLoanMessage{
+		ApplicationId: message.ApplicationID,
+		FirstName:     message.FirstName,
+		LastName:      message.LastName,
+		LoanDetails: &pb.LoanDetails{
+			LoanAmount:   message.LoanAmount,
+			Currency:     message.Currency,
+			TermMonths:   int32(message.TermMonths),
+			Purpose:      string(message.Purpose),
+			AnnualIncome: message.AnnualIncome,
+			Email:        message.Email,
+			DateOfBirth:  message.DateOfBirth,
+		},
+	}
+}
+
+func createLoanMessageFromProto(message *pb.CreateLoanMessage) sharedmodels.CreateLoanMessage {
+	details := message.GetLoanDetails()
+	return sharedmodels.CreateLoanMessage{
+		ApplicationID: message.GetApplicationId(),
+		FirstName:     message.GetFirstName(),
+		LastName:      message.GetLastName(),
+		LoanDetails: sharedmodels.LoanDetails{
+			LoanAmount:   details.GetLoanAmount(),
+			Currency:     details.GetCurrency(),
+			TermMonths:   int(details.GetTermMonths()),
+			Purpose:      sharedmodels.LoanPurpose(details.GetPurpose()),
+			AnnualIncome: details.GetAnnualIncome(),
+			Email:        details.GetEmail(),
+			DateOfBirth:  details.GetDateOfBirth(),
+		},
+	}
+}
+
+func pollLoanMessageToProto(message sharedmodels.PollLoanMessage) *pb.PollLoanMessage {
+	return &pb.PollLoanMessage{
+		OurId:         message.OurApplicationID,
+		ApplicationId: message.BankApplicationID,
+		Attempt:       int32(message.Attempt),
+		FirstSeen:     timestampToProto(message.FirstSeen),
+	}
+}
+
+func pollLoanMessageFromProto(message *pb.PollLoanMessage) sharedmodels.PollLoanMessage {
+	return sharedmodels.PollLoanMessage{
+		OurApplicationID:  message.GetOurId(),
+		BankApplicationID: message.GetApplicationId(),
+		Attempt:           int(message.GetAttempt()),
+		FirstSeen:         timestampFromProto(message.GetFirstSeen()),
+	}
+}
+
+//timestampToProto returns nil for the zero time, so that a time which is not set is not sent as 0001-01-01.
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+//timestampFromProto returns the zero time for a timestamp which is not set, rather than the Unix epoch given by AsTime.
+//For example, a poll message without FirstSeen must not appear to have been first seen in 1970.
+func timestampFromProto(timestamp *timestamppb.Timestamp) time.Time {
+	if timestamp == nil {
+		return time.Time{}
+	}
+
+	return timestamp.AsTime()
+}
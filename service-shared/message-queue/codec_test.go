@@ -0,0 +1,188 @@
+package message_queue
+
+import (
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"service-shared/message-queue/pb"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+	"time"
+)
+
+func getCreateLoanEnvelope() sharedmodels.Envelope {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.CreateLoanMessageType, sharedmodels.CreateLoanMessageVersion,
+		sharedmodels.APIGatewayService, "", getCreateLoanMessage())
+	return envelope
+}
+
+func getCreateLoanMessage() sharedmodels.CreateLoanMessage {
+	return sharedmodels.CreateLoanMessage{
+		ApplicationID: "abc",
+		FirstName:     "First",
+		LastName:      "Last",
+		LoanDetails: sharedmodels.LoanDetails{
+			LoanAmount:   2500000,
+			Currency:     "GBP",
+			TermMonths:   36,
+			Purpose:      sharedmodels.Car,
+			AnnualIncome: 4500000,
+			Email:        "first.last@example.com",
+			DateOfBirth:  "1990-01-31",
+		},
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	contentTypes := map[string]Codec{
+		"":                  JSONCodec{},
+		JSONContentType:     JSONCodec{},
+		ProtobufContentType: ProtobufCodec{},
+	}
+
+	for contentType, expected := range contentTypes {
+		codec, err := CodecFor(contentType)
+
+		assert.Nil(t, err, contentType)
+		assert.Equal(t, expected, codec, contentType)
+	}
+}
+
+func TestCodecForUnsupportedContentType(t *testing.T) {
+	_, err := CodecFor("text/xml")
+
+	assert.True(t, errors.Is(err, ErrUnsupportedContentType))
+}
+
+func TestCodecsRoundTripCreateLoanMessage(t *testing.T) {
+	envelope := getCreateLoanEnvelope()
+
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		body, err := codec.Marshal(envelope)
+		assert.Nil(t, err, codec.ContentType())
+
+		decoded, err := codec.Unmarshal(body)
+		assert.Nil(t, err, codec.ContentType())
+		message, err := sharedmodels.DecodeCreateLoanMessage(decoded)
+		assert.Nil(t, err, codec.ContentType())
+		assert.Equal(t, getCreateLoanMessage(), message, codec.ContentType())
+		assert.Equal(t, envelope.MessageID, decoded.MessageID, codec.ContentType())
+		assert.Equal(t, envelope.CorrelationID, decoded.CorrelationID, codec.ContentType())
+		assert.Equal(t, envelope.SchemaVersion, decoded.SchemaVersion, codec.ContentType())
+		assert.Equal(t, envelope.Producer, decoded.Producer, codec.ContentType())
+		assert.True(t, envelope.ProducedAt.Equal(decoded.ProducedAt), codec.ContentType())
+	}
+}
+
+func TestCodecsRoundTripPollLoanMessage(t *testing.T) {
+	expected := sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "bank", Attempt: 3,
+		FirstSeen: time.Date(2022, 8, 1, 9, 30, 0, 0, time.UTC)}
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "correlation", expected)
+
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		body, err := codec.Marshal(envelope)
+		assert.Nil(t, err, codec.ContentType())
+
+		decoded, err := codec.Unmarshal(body)
+		assert.Nil(t, err, codec.ContentType())
+		message, err := sharedmodels.DecodePollLoanMessage(decoded)
+		assert.Nil(t, err, codec.ContentType())
+		assert.Equal(t, expected, message, codec.ContentType())
+		assert.Equal(t, "correlation", decoded.CorrelationID, codec.ContentType())
+	}
+}
+
+func TestProtobufCodecUpgradesV1(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.CreateLoanMessageType, sharedmodels.CreateLoanMessageV1,
+		sharedmodels.APIGatewayService, "", map[string]string{"application_id": "abc", "first_name": "First", "last_name": "Last"})
+	body, _ := ProtobufCodec{}.Marshal(envelope)
+
+	decoded, err := ProtobufCodec{}.Unmarshal(body)
+	assert.Nil(t, err)
+	message, err := sharedmodels.DecodeCreateLoanMessage(decoded)
+
+	assert.Nil(t, err)
+	assert.Equal(t, sharedmodels.CreateLoanMessage{ApplicationID: "abc", FirstName: "First", LastName: "Last"}, message)
+}
+
+func TestProtobufCodecMissingTimestampsAreZero(t *testing.T) {
+	// A producer which does not set the timestamps sends neither of them
+	payload, _ := proto.Marshal(&pb.PollLoanMessage{OurId: "abc", ApplicationId: "bank", Attempt: 1})
+	body, _ := proto.Marshal(&pb.Envelope{SchemaVersion: 1, Type: sharedmodels.PollLoanMessageType, Payload: payload})
+
+	decoded, err := ProtobufCodec{}.Unmarshal(body)
+	assert.Nil(t, err)
+	message, err := sharedmodels.DecodePollLoanMessage(decoded)
+
+	assert.Nil(t, err)
+	assert.True(t, decoded.ProducedAt.IsZero())
+	assert.True(t, message.FirstSeen.IsZero())
+}
+
+func TestProtobufCodecDoesNotSendZeroTimestamps(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "", sharedmodels.PollLoanMessage{OurApplicationID: "abc"})
+	envelope.ProducedAt = time.Time{}
+	body, _ := ProtobufCodec{}.Marshal(envelope)
+
+	var sent pb.Envelope
+	assert.Nil(t, proto.Unmarshal(body, &sent))
+	var message pb.PollLoanMessage
+	assert.Nil(t, proto.Unmarshal(sent.Payload, &message))
+
+	assert.Nil(t, sent.GetProducedAt())
+	assert.Nil(t, message.GetFirstSeen())
+}
+
+func TestProtobufCodecUnknownType(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope("unknown", 1, sharedmodels.APIGatewayService, "", map[string]string{})
+
+	_, err := ProtobufCodec{}.Marshal(envelope)
+
+	assert.NotNil(t, err)
+}
+
+func TestProtobufCodecInvalidBody(t *testing.T) {
+	_, err := ProtobufCodec{}.Unmarshal([]byte("{invalidprotobuf,"))
+
+	assert.NotNil(t, err)
+}
+
+func TestJSONCodecBareMessage(t *testing.T) {
+	body := []byte(`{"our_id":"abc","application_id":"bank","attempt":3}`)
+
+	envelope, err := JSONCodec{}.Unmarshal(body)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, envelope.SchemaVersion)
+	assert.Empty(t, envelope.Type)
+	message, err := sharedmodels.DecodePollLoanMessage(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, sharedmodels.PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "bank", Attempt: 3}, message)
+}
+
+func TestUnmarshalEnvelopeAcceptsEitherFormat(t *testing.T) {
+	envelope := getCreateLoanEnvelope()
+	jsonBody, _ := JSONCodec{}.Marshal(envelope)
+	protobufBody, _ := ProtobufCodec{}.Marshal(envelope)
+	deliveries := []amqp.Delivery{
+		{Body: jsonBody},
+		{ContentType: JSONContentType, Body: jsonBody},
+		{ContentType: ProtobufContentType, Body: protobufBody},
+	}
+
+	for _, delivery := range deliveries {
+		decoded, err := UnmarshalEnvelope(delivery)
+
+		assert.Nil(t, err, delivery.ContentType)
+		assert.Equal(t, envelope.MessageID, decoded.MessageID, delivery.ContentType)
+	}
+}
+
+func TestUnmarshalEnvelopeUnsupportedContentType(t *testing.T) {
+	_, err := UnmarshalEnvelope(amqp.Delivery{ContentType: "text/xml", Body: []byte("<loan/>")})
+
+	assert.True(t, errors.Is(err, ErrUnsupportedContentType))
+}
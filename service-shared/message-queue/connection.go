@@ -0,0 +1,178 @@
+package message_queue
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
+	sharedhelpers "service-shared/shared-helpers"
+	"sync"
+	"time"
+)
+
+//ChannelSetup prepares a newly opened channel, for example by declaring queues and starting to consume.
+//It is run each time the channel is opened, including after a reconnect, so it must be safe to repeat.
+type ChannelSetup func(ch *amqp.Channel) error
+
+//managedChannel is a channel which the ConnectionManager reopens whenever it closes.
+type managedChannel struct {
+	name  string
+	setup ChannelSetup
+}
+
+/*
+ConnectionManager owns a connection to RabbitMQ, and transparently recovers it.
+
+The manager watches the connection using NotifyClose. If the broker closes it, or the network
+drops, the manager re-dials with an exponential backoff until it succeeds, then reopens every
+channel opened with OpenChannel, running each channel's setup again. A channel which is closed
+on its own, for example by a channel exception, is reopened in the same way.
+
+This means that consumers resume consuming, and publishers resume publishing, after a broker
+restart without the service having to restart.
+*/
+type ConnectionManager struct {
+	url     string
+	backoff sharedhelpers.Backoff
+
+	mu       *sync.Mutex
+	conn     *amqp.Connection
+	channels []*managedChannel
+	closed   bool
+}
+
+//NewConnectionManager returns a ConnectionManager for the broker at url. Call Connect before opening channels.
+func NewConnectionManager(url string, backoff sharedhelpers.Backoff) *ConnectionManager {
+	return &ConnectionManager{url: url, backoff: backoff, mu: &sync.Mutex{}}
+}
+
+//Connect dials the broker, retrying with backoff until it succeeds, and starts watching the connection.
+func (manager *ConnectionManager) Connect() {
+	conn := manager.dial()
+
+	manager.mu.Lock()
+	manager.conn = conn
+	manager.mu.Unlock()
+
+	go manager.watch(conn)
+}
+
+/*
+OpenChannel opens a channel and runs setup on it. The channel is reopened, and setup run again,
+whenever the channel or connection closes unexpectedly.
+
+An error is returned if the channel cannot be opened or set up the first time. In that
+case the channel is not managed.
+*/
+func (manager *ConnectionManager) OpenChannel(name string, setup ChannelSetup) error {
+	managed := &managedChannel{name: name, setup: setup}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if err := manager.open(manager.conn, managed); err != nil {
+		return err
+	}
+	manager.channels = append(manager.channels, managed)
+
+	return nil
+}
+
+//Close closes the connection, and stops the manager from reconnecting.
+func (manager *ConnectionManager) Close() error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.closed = true
+	if manager.conn == nil {
+		return nil
+	}
+
+	return manager.conn.Close()
+}
+
+func (manager *ConnectionManager) isClosed() bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.closed
+}
+
+//open opens a channel on conn and runs its setup. The channel is watched so that it is reopened if it closes.
+func (manager *ConnectionManager) open(conn *amqp.Connection, managed *managedChannel) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	// Register before setup, so that a channel exception during setup is not missed
+	closes := ch.NotifyClose(make(chan *amqp.Error, 1))
+	if err := managed.setup(ch); err != nil {
+		ch.Close()
+		return err
+	}
+
+	go manager.watchChannel(conn, managed, closes)
+	return nil
+}
+
+func (manager *ConnectionManager) dial() *amqp.Connection {
+	for attempt := 0; ; attempt++ {
+		conn, err := amqp.Dial(manager.url)
+		if err == nil {
+			return conn
+		}
+
+		delay := manager.backoff.Delay(attempt)
+		log.Printf("Failed to connect to RabbitMQ, retrying in %s : %s\n", delay, err)
+		time.Sleep(delay)
+	}
+}
+
+//watch waits for conn to close, then reconnects and reopens every managed channel.
+func (manager *ConnectionManager) watch(conn *amqp.Connection) {
+	reason, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+	if !ok || manager.isClosed() {
+		// The connection was closed by Close
+		return
+	}
+	log.Printf("Lost connection to RabbitMQ, reconnecting : %s\n", reason)
+
+	conn = manager.dial()
+	manager.mu.Lock()
+	if manager.closed {
+		manager.mu.Unlock()
+		conn.Close()
+		return
+	}
+	manager.conn = conn
+	channels := append([]*managedChannel{}, manager.channels...)
+	manager.mu.Unlock()
+	log.Printf("Reconnected to RabbitMQ\n")
+
+	go manager.watch(conn)
+	for _, managed := range channels {
+		manager.reopen(conn, managed)
+	}
+}
+
+//watchChannel waits for a channel to close. If only the channel closed, rather than its connection, the channel is reopened.
+func (manager *ConnectionManager) watchChannel(conn *amqp.Connection, managed *managedChannel, closes chan *amqp.Error) {
+	reason, ok := <-closes
+	if !ok || conn.IsClosed() || manager.isClosed() {
+		// Either the channel was closed by us, or watch will reopen it along with the connection
+		return
+	}
+	log.Printf("RabbitMQ channel %s closed, reopening : %s\n", managed.name, reason)
+
+	manager.reopen(conn, managed)
+}
+
+//reopen reopens a managed channel on conn, retrying with backoff until it succeeds or conn closes.
+func (manager *ConnectionManager) reopen(conn *amqp.Connection, managed *managedChannel) {
+	for attempt := 0; !conn.IsClosed() && !manager.isClosed(); attempt++ {
+		err := manager.open(conn, managed)
+		if err == nil {
+			return
+		}
+
+		delay := manager.backoff.Delay(attempt)
+		log.Printf("Failed to reopen RabbitMQ channel %s, retrying in %s : %s\n", managed.name, delay, err)
+		time.Sleep(delay)
+	}
+}
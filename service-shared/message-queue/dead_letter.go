@@ -0,0 +1,85 @@
+package message_queue
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers describing why a message was dead-lettered. They are set by AmqpDeliveryHandler.DeadLetter
+const (
+	FailureReasonHeader   = "x-failure-reason"
+	FailureServiceHeader  = "x-failure-service"
+	FailureAttemptsHeader = "x-failure-attempts"
+	FailedAtHeader        = "x-failed-at"
+)
+
+//DeadLetterQueueName returns the name of the queue which holds dead-lettered messages from queueName.
+func DeadLetterQueueName(queueName string) string {
+	return queueName + ".dlq"
+}
+
+/*
+DeclareQueue declares a durable queue named queueName, along with its dead letter queue.
+
+Messages which are nacked or rejected without requeue, or which are explicitly dead-lettered
+by a DeliveryHandler, are routed via deadLetterExchange to the queue named DeadLetterQueueName(queueName).
+The routing key used for the dead letter exchange is the name of the original queue.
+
+Note that RabbitMQ will refuse to redeclare an existing queue with different arguments. Publishers
+and consumers of the same queue must therefore both declare it using this function.
+*/
+func DeclareQueue(ch *amqp.Channel, queueName, deadLetterExchange string) (amqp.Queue, error) {
+	err := ch.ExchangeDeclare(
+		deadLetterExchange, // name
+		"direct",           // kind
+		true,               // durable (survive restarts)
+		false,              // do not delete when unused
+		false,              // internal
+		false,              // no-wait
+		nil)                // args
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	deadLetterQueue, err := ch.QueueDeclare(
+		DeadLetterQueueName(queueName), // name
+		true,                           // durable (survive restarts)
+		false,                          // do not delete when unused
+		false,                          // exclusive
+		false,                          // no-wait
+		nil)                            // args
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	err = ch.QueueBind(
+		deadLetterQueue.Name, // queue
+		queueName,            // routing key
+		deadLetterExchange,   // exchange
+		false,                // no-wait
+		nil)                  // args
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	return ch.QueueDeclare(
+		queueName, // name
+		true,      // durable (survive restarts)
+		false,     // do not delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		amqp.Table{"x-dead-letter-exchange": deadLetterExchange})
+}
+
+//FailureAttempts returns how many times a delivery has previously been dead-lettered.
+func FailureAttempts(delivery amqp.Delivery) int {
+	switch attempts := delivery.Headers[FailureAttemptsHeader].(type) {
+	case int:
+		return attempts
+	case int32:
+		return int(attempts)
+	case int64:
+		return int(attempts)
+	}
+
+	return 0
+}
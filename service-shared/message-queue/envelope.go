@@ -0,0 +1,29 @@
+package message_queue
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	sharedmodels "service-shared/shared-models"
+)
+
+/*
+NewPublishing returns a persistent amqp.Publishing of envelope, marshalled by codec. The MessageId, CorrelationId,
+Type, Timestamp and AppId properties are copied from the envelope, so that a message can be traced without decoding
+its body.
+*/
+func NewPublishing(codec Codec, envelope sharedmodels.Envelope) (amqp.Publishing, error) {
+	body, err := codec.Marshal(envelope)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   codec.ContentType(),
+		MessageId:     envelope.MessageID,
+		CorrelationId: envelope.CorrelationID,
+		Type:          envelope.Type,
+		Timestamp:     envelope.ProducedAt,
+		AppId:         envelope.Producer,
+		Body:          body,
+	}, nil
+}
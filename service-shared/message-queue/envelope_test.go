@@ -0,0 +1,39 @@
+package message_queue
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+)
+
+func TestNewPublishingCopiesEnvelopeProperties(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "correlation", sharedmodels.PollLoanMessage{OurApplicationID: "abc"})
+
+	publishing, err := NewPublishing(JSONCodec{}, envelope)
+
+	assert.Nil(t, err)
+	assert.Equal(t, amqp.Persistent, publishing.DeliveryMode)
+	assert.Equal(t, JSONContentType, publishing.ContentType)
+	assert.Equal(t, envelope.MessageID, publishing.MessageId)
+	assert.Equal(t, "correlation", publishing.CorrelationId)
+	assert.Equal(t, sharedmodels.PollLoanMessageType, publishing.Type)
+	assert.Equal(t, envelope.ProducedAt, publishing.Timestamp)
+	assert.Equal(t, sharedmodels.CreateApplicationService, publishing.AppId)
+	published, _ := JSONCodec{}.Unmarshal(publishing.Body)
+	assert.Equal(t, envelope.MessageID, published.MessageID)
+}
+
+func TestNewPublishingSetsCodecContentType(t *testing.T) {
+	envelope, _ := sharedmodels.NewEnvelope(sharedmodels.PollLoanMessageType, sharedmodels.PollLoanMessageVersion,
+		sharedmodels.CreateApplicationService, "", sharedmodels.PollLoanMessage{OurApplicationID: "abc"})
+
+	publishing, err := NewPublishing(ProtobufCodec{}, envelope)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ProtobufContentType, publishing.ContentType)
+	published, err := UnmarshalEnvelope(amqp.Delivery{ContentType: publishing.ContentType, Body: publishing.Body})
+	assert.Nil(t, err)
+	assert.Equal(t, envelope.MessageID, published.MessageID)
+}
@@ -1,85 +1,136 @@
 package message_queue
 
 import (
+	"context"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
-	sharedhelpers "service-shared/shared-helpers"
+	"sync"
 )
 
 //Consumer provides an interface for consumer services
 type Consumer interface {
-	Consume()
+	Consume(ctx context.Context) error
 }
 
 /*
 RabbitMQConsumer represents a type capable of consuming off a RabbitMQ queue.
-Currently, only the QueueName and PrefetchSize are configurable via this struct.
-Of course, this could be extended in future to allow configuring of the queue or
-channel args etc.
+Currently, only the QueueName, PrefetchSize and dead letter exchange are configurable
+via this struct. Of course, this could be extended in future to allow configuring of
+the queue or channel args etc.
 */
 type RabbitMQConsumer struct {
-	connection   *amqp.Connection
-	prefetchSize int
-	outChan      chan<- amqp.Delivery
-	queueName    string
+	manager            *ConnectionManager
+	prefetchSize       int
+	outChan            chan<- amqp.Delivery
+	queueName          string
+	deadLetterExchange string
 }
 
 //NewRabbitMQConsumer creates a RabbitMQConsumer
-func NewRabbitMQConsumer(conn *amqp.Connection, prefetchSize int, outChan chan<- amqp.Delivery, queueName string) RabbitMQConsumer {
+func NewRabbitMQConsumer(manager *ConnectionManager, prefetchSize int, outChan chan<- amqp.Delivery, queueName, deadLetterExchange string) RabbitMQConsumer {
 	return RabbitMQConsumer{
-		connection:   conn,
-		prefetchSize: prefetchSize,
-		outChan:      outChan,
-		queueName:    queueName,
+		manager:            manager,
+		prefetchSize:       prefetchSize,
+		outChan:            outChan,
+		queueName:          queueName,
+		deadLetterExchange: deadLetterExchange,
 	}
 }
 
 /*
-Consume sets up and iterates over messages from the channel.
+Consume starts consuming messages from the queue, on a channel managed by the ConnectionManager.
 Each message is delivered to RabbitMQConsumer.OutChan
 
 The intent is that there should be workers listening on this channel. Those
 workers are responsible for processing the contents of a delivered message.
+
+If the channel or connection is lost, the queue is declared and consumed from again once the
+ConnectionManager has reopened the channel. Messages which were delivered but not acked before
+the channel closed are redelivered by the broker, and acking them on the old channel fails.
+
+When ctx is cancelled the consumer is cancelled, so the broker stops delivering messages.
+Messages which have been delivered, but not yet passed to a worker, are requeued. Messages
+already passed to a worker are left for the worker to ack.
 */
-func (consumer RabbitMQConsumer) Consume() {
-	ch, err := consumer.connection.Channel()
-	sharedhelpers.FailOnError(err, "Consumer failed to open a channel to RabbitMQ")
-	defer ch.Close()
-
-	queue, err := ch.QueueDeclare(
-		consumer.queueName, // name
-		true,               // durable (survive restarts)
-		false,              // do not delete when unused
-		false,              // exclusive
-		false,              // no-wait
-		nil)                /// args
-	sharedhelpers.FailOnError(err, "Failed to declare the create application queue")
+func (consumer RabbitMQConsumer) Consume(ctx context.Context) error {
+	mu := &sync.Mutex{}
+	var current *amqp.Channel
+	err := consumer.manager.OpenChannel("consumer "+consumer.queueName, func(ch *amqp.Channel) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			// Do not resume consuming after a reconnect during shutdown
+			return nil
+		}
+
+		if err := consumer.setup(ctx, ch); err != nil {
+			return err
+		}
+		current = ch
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		if current == nil {
+			return
+		}
+		if err := current.Cancel(consumer.tag(), false); err != nil {
+			log.Printf("Failed to cancel the consumer of %s : %s\n", consumer.queueName, err)
+		}
+	}()
+
+	log.Printf(" [*] Waiting for messages. To exit press CTRL+C")
+	return nil
+}
+
+//tag returns the consumer tag. Each consumer has its own channel, so the tag only needs to be unique per queue.
+func (consumer RabbitMQConsumer) tag() string {
+	return consumer.queueName + "-consumer"
+}
+
+func (consumer RabbitMQConsumer) setup(ctx context.Context, ch *amqp.Channel) error {
+	queue, err := DeclareQueue(ch, consumer.queueName, consumer.deadLetterExchange)
+	if err != nil {
+		return err
+	}
 
 	// https://www.rabbitmq.com/consumer-prefetch.html
 	err = ch.Qos(
 		consumer.prefetchSize, // prefetch count
 		0,                     // prefetch size
 		false)                 // global
-	sharedhelpers.FailOnError(err, "Failed to set QoS")
+	if err != nil {
+		return err
+	}
 
 	msgs, err := ch.Consume(
-		queue.Name, // queue
-		"",         // consume
-		false,      // auto-ack
-		false,      // exclusive
-		false,      // no-local
-		false,      //no-wait
-		nil)        // args
-	sharedhelpers.FailOnError(err, "Failed to register consumer")
-
-	var forever chan struct{}
+		queue.Name,     // queue
+		consumer.tag(), // consume
+		false,          // auto-ack
+		false,          // exclusive
+		false,          // no-local
+		false,          //no-wait
+		nil)            // args
+	if err != nil {
+		return err
+	}
+
+	// msgs is closed when the consumer is cancelled or the channel closes
 	go func() {
 		for d := range msgs {
-			consumer.outChan <- d
+			select {
+			case consumer.outChan <- d:
+			case <-ctx.Done():
+				d.Nack(false, true)
+			}
 		}
 	}()
 
-	log.Printf(" [*] Waiting for messages. To exit press CTRL+C")
-
-	<-forever
+	return nil
 }
@@ -0,0 +1,4 @@
+//Package pb contains the Protocol Buffers messages generated from messages.proto.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative messages.proto
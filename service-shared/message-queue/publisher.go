@@ -0,0 +1,189 @@
+package message_queue
+
+import (
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPublishNacked     = errors.New("the broker did not accept the published message")
+	ErrPublishTimeout    = errors.New("timed out waiting for the broker to confirm the published message")
+	ErrConfirmsUnhandled = errors.New("the channel closed before the broker confirmed the published message")
+)
+
+//Publisher provides an abstraction for publishing messages to RabbitMQ.
+//It allows for easier testing via an interface which unit tests can mock.
+type Publisher interface {
+	Publish(exchange, routingKey string, msg amqp.Publishing) error
+}
+
+//confirmChannel represents the operations of an amqp.Channel used by a ConfirmingPublisher.
+type confirmChannel interface {
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+/*
+ConfirmingPublisher publishes messages on a channel in confirm mode. Each call to Publish
+waits until the broker acks or nacks the message, or until the timeout passes.
+
+This means that a nil error returned by Publish indicates that the broker has taken
+responsibility for the message. For a persistent message on a durable queue, this means
+that the message has been written to disk.
+
+Publishes are serialised, so a ConfirmingPublisher may be shared by multiple goroutines.
+*/
+type ConfirmingPublisher struct {
+	mu       *sync.Mutex
+	ch       confirmChannel
+	confirms chan amqp.Confirmation
+	timeout  time.Duration
+	// The broker numbers the messages published on a channel in confirm mode from 1
+	deliveryTag uint64
+}
+
+//NewConfirmingPublisher puts ch into confirm mode and returns a ConfirmingPublisher which publishes on it.
+func NewConfirmingPublisher(ch *amqp.Channel, timeout time.Duration) (*ConfirmingPublisher, error) {
+	return newConfirmingPublisher(ch, timeout)
+}
+
+func newConfirmingPublisher(ch confirmChannel, timeout time.Duration) (*ConfirmingPublisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+
+	return &ConfirmingPublisher{
+		mu: &sync.Mutex{},
+		ch: ch,
+		// Buffered so that confirms arriving after a timeout do not block the channel
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 64)),
+		timeout:  timeout,
+	}, nil
+}
+
+/*
+Publish publishes msg and waits for the broker to confirm it.
+
+Returns ErrPublishNacked if the broker nacks the message and ErrPublishTimeout if no
+confirmation arrives in time. In either case the message may or may not have been
+enqueued, so callers which retry must tolerate duplicates.
+*/
+func (publisher *ConfirmingPublisher) Publish(exchange, routingKey string, msg amqp.Publishing) error {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	if err := publisher.ch.Publish(exchange, routingKey, false, false, msg); err != nil {
+		return err
+	}
+	publisher.deliveryTag++
+
+	timer := time.NewTimer(publisher.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case confirm, ok := <-publisher.confirms:
+			if !ok {
+				return ErrConfirmsUnhandled
+			}
+			if confirm.DeliveryTag < publisher.deliveryTag {
+				// A late confirmation for a message which previously timed out
+				continue
+			}
+			if !confirm.Ack {
+				return ErrPublishNacked
+			}
+			return nil
+		case <-timer.C:
+			return ErrPublishTimeout
+		}
+	}
+}
+
+//ErrNotConnected is returned by a ReconnectingPublisher which could not publish before its channel was reopened.
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+/*
+ReconnectingPublisher is a Publisher whose channel is managed by a ConnectionManager.
+
+Each time the channel is opened, declare is run on it and a new ConfirmingPublisher replaces
+the previous one. If a publish fails because the channel has closed, Publish waits for the
+channel to be reopened and publishes again, for up to the confirm timeout. A message which was
+published when the channel closed may already have been enqueued, so it may be duplicated.
+*/
+type ReconnectingPublisher struct {
+	mu        *sync.Mutex
+	publisher Publisher
+	// replaced is closed, and then replaced, each time a new publisher is installed
+	replaced chan struct{}
+	timeout  time.Duration
+}
+
+//NewReconnectingPublisher opens a managed channel, and returns a ReconnectingPublisher which publishes on it.
+//declare is run each time the channel is opened, it may be nil.
+func NewReconnectingPublisher(
+	manager *ConnectionManager,
+	name string,
+	timeout time.Duration,
+	declare ChannelSetup) (*ReconnectingPublisher, error) {
+	reconnecting := newReconnectingPublisher(timeout)
+	err := manager.OpenChannel(name, func(ch *amqp.Channel) error {
+		if declare != nil {
+			if err := declare(ch); err != nil {
+				return err
+			}
+		}
+
+		publisher, err := NewConfirmingPublisher(ch, timeout)
+		if err != nil {
+			return err
+		}
+		reconnecting.install(publisher)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reconnecting, nil
+}
+
+func newReconnectingPublisher(timeout time.Duration) *ReconnectingPublisher {
+	return &ReconnectingPublisher{mu: &sync.Mutex{}, replaced: make(chan struct{}), timeout: timeout}
+}
+
+func (reconnecting *ReconnectingPublisher) install(publisher Publisher) {
+	reconnecting.mu.Lock()
+	defer reconnecting.mu.Unlock()
+	reconnecting.publisher = publisher
+	close(reconnecting.replaced)
+	reconnecting.replaced = make(chan struct{})
+}
+
+//Publish publishes msg using the current channel, waiting for the channel to be reopened if it has closed.
+func (reconnecting *ReconnectingPublisher) Publish(exchange, routingKey string, msg amqp.Publishing) error {
+	deadline := time.NewTimer(reconnecting.timeout)
+	defer deadline.Stop()
+	for {
+		reconnecting.mu.Lock()
+		publisher, replaced := reconnecting.publisher, reconnecting.replaced
+		reconnecting.mu.Unlock()
+
+		err := ErrNotConnected
+		if publisher != nil {
+			err = publisher.Publish(exchange, routingKey, msg)
+			if !errors.Is(err, amqp.ErrClosed) && !errors.Is(err, ErrConfirmsUnhandled) {
+				return err
+			}
+		}
+
+		select {
+		case <-replaced:
+			// The channel has been reopened, so publish again
+		case <-deadline.C:
+			return err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package message_queue
+
+import (
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// fakeConfirmChannel confirms each publish with the next value from acks
+type fakeConfirmChannel struct {
+	confirms   chan amqp.Confirmation
+	acks       []bool
+	publishErr error
+	published  uint64
+}
+
+func (ch *fakeConfirmChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (ch *fakeConfirmChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.confirms = confirm
+	return confirm
+}
+
+func (ch *fakeConfirmChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if ch.publishErr != nil {
+		return ch.publishErr
+	}
+	ch.published++
+	if len(ch.acks) > 0 {
+		ch.confirms <- amqp.Confirmation{DeliveryTag: ch.published, Ack: ch.acks[0]}
+		ch.acks = ch.acks[1:]
+	}
+	return nil
+}
+
+func TestPublishAcked(t *testing.T) {
+	publisher, _ := newConfirmingPublisher(&fakeConfirmChannel{acks: []bool{true}}, time.Second)
+
+	err := publisher.Publish("", "queue", amqp.Publishing{})
+
+	assert.Nil(t, err)
+}
+
+func TestPublishNacked(t *testing.T) {
+	publisher, _ := newConfirmingPublisher(&fakeConfirmChannel{acks: []bool{false}}, time.Second)
+
+	err := publisher.Publish("", "queue", amqp.Publishing{})
+
+	assert.Equal(t, ErrPublishNacked, err)
+}
+
+func TestPublishError(t *testing.T) {
+	publishErr := errors.New("")
+	publisher, _ := newConfirmingPublisher(&fakeConfirmChannel{publishErr: publishErr}, time.Second)
+
+	err := publisher.Publish("", "queue", amqp.Publishing{})
+
+	assert.Equal(t, publishErr, err)
+}
+
+func TestPublishTimeout(t *testing.T) {
+	publisher, _ := newConfirmingPublisher(&fakeConfirmChannel{}, time.Millisecond)
+
+	err := publisher.Publish("", "queue", amqp.Publishing{})
+
+	assert.Equal(t, ErrPublishTimeout, err)
+}
+
+func TestPublishIgnoresLateConfirmation(t *testing.T) {
+	ch := &fakeConfirmChannel{}
+	publisher, _ := newConfirmingPublisher(ch, time.Millisecond)
+
+	// The first publish times out, then the broker nacks it late
+	assert.Equal(t, ErrPublishTimeout, publisher.Publish("", "queue", amqp.Publishing{}))
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+
+	// The second publish must not see the confirmation for the first
+	ch.acks = []bool{true}
+	err := publisher.Publish("", "queue", amqp.Publishing{})
+
+	assert.Nil(t, err)
+}
+
+func TestReconnectingPublishUsesInstalledPublisher(t *testing.T) {
+	publisher, _ := newConfirmingPublisher(&fakeConfirmChannel{acks: []bool{true}}, time.Second)
+	reconnecting := newReconnectingPublisher(time.Second)
+	reconnecting.install(publisher)
+
+	err := reconnecting.Publish("", "queue", amqp.Publishing{})
+
+	assert.Nil(t, err)
+}
+
+func TestReconnectingPublishWaitsForReopen(t *testing.T) {
+	closed, _ := newConfirmingPublisher(&fakeConfirmChannel{publishErr: amqp.ErrClosed}, time.Second)
+	reopened, _ := newConfirmingPublisher(&fakeConfirmChannel{acks: []bool{true}}, time.Second)
+	reconnecting := newReconnectingPublisher(time.Second)
+	reconnecting.install(closed)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		reconnecting.install(reopened)
+	}()
+	err := reconnecting.Publish("", "queue", amqp.Publishing{})
+
+	assert.Nil(t, err)
+}
+
+func TestReconnectingPublishTimesOutWhileDisconnected(t *testing.T) {
+	reconnecting := newReconnectingPublisher(10 * time.Millisecond)
+
+	err := reconnecting.Publish("", "queue", amqp.Publishing{})
+
+	assert.Equal(t, ErrNotConnected, err)
+}
+
+func TestReconnectingPublishDoesNotRetryNack(t *testing.T) {
+	publisher, _ := newConfirmingPublisher(&fakeConfirmChannel{acks: []bool{false}}, time.Second)
+	reconnecting := newReconnectingPublisher(time.Second)
+	reconnecting.install(publisher)
+
+	err := reconnecting.Publish("", "queue", amqp.Publishing{})
+
+	assert.Equal(t, ErrPublishNacked, err)
+}
@@ -0,0 +1,73 @@
+package message_queue
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"service-shared/tracing"
+)
+
+//headerCarrier lets the trace context be injected into, and extracted from, the headers of an AMQP message.
+type headerCarrier amqp.Table
+
+func (carrier headerCarrier) Get(key string) string {
+	value, _ := carrier[key].(string)
+	return value
+}
+
+func (carrier headerCarrier) Set(key string, value string) {
+	carrier[key] = value
+}
+
+func (carrier headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+/*
+StartPublishSpan starts a producer span for publishing to queue, and injects its trace context into the headers of
+publishing. The consumer of the message continues the trace with StartConsumeSpan. The caller must end the span once
+the message has been published.
+*/
+func StartPublishSpan(ctx context.Context, queue string, publishing *amqp.Publishing) (context.Context, trace.Span) {
+	ctx, span := tracing.Tracer().Start(ctx, queue+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(queue, publishing.MessageId, publishing.CorrelationId)...))
+
+	if publishing.Headers == nil {
+		publishing.Headers = amqp.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(publishing.Headers))
+
+	return ctx, span
+}
+
+/*
+StartConsumeSpan starts a consumer span for processing delivery, which was consumed from queue. The span continues
+the trace injected into the headers of the message by StartPublishSpan, or starts a new trace for a message without one.
+The caller must end the span once the delivery has been handled.
+*/
+func StartConsumeSpan(ctx context.Context, queue string, delivery amqp.Delivery) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(delivery.Headers))
+	attributes := append(messagingAttributes(queue, delivery.MessageId, delivery.CorrelationId), semconv.MessagingOperationProcess)
+
+	return tracing.Tracer().Start(ctx, queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...))
+}
+
+func messagingAttributes(queue, messageID, correlationID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("rabbitmq"),
+		semconv.MessagingDestinationKey.String(queue),
+		semconv.MessagingDestinationKindQueue,
+		semconv.MessagingMessageIDKey.String(messageID),
+		semconv.MessagingConversationIDKey.String(correlationID),
+	}
+}
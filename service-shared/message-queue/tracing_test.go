@@ -0,0 +1,66 @@
+package message_queue
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestConsumeSpanContinuesPublishedTrace(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+	publishing := amqp.Publishing{MessageId: "message", CorrelationId: "correlation"}
+
+	_, publishSpan := StartPublishSpan(context.Background(), "create_queue", &publishing)
+	publishSpan.End()
+	delivery := amqp.Delivery{Headers: publishing.Headers, MessageId: publishing.MessageId}
+	_, consumeSpan := StartConsumeSpan(context.Background(), "create_queue", delivery)
+	consumeSpan.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "create_queue publish", spans[0].Name)
+	assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind)
+	assert.Equal(t, "create_queue process", spans[1].Name)
+	assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind)
+	// The consumer span is a child of the publisher span, in the same trace
+	assert.Equal(t, spans[0].SpanContext.TraceID(), spans[1].SpanContext.TraceID())
+	assert.Equal(t, spans[0].SpanContext.SpanID(), spans[1].Parent.SpanID())
+}
+
+func TestPublishSpanKeepsExistingHeaders(t *testing.T) {
+	// Setup
+	setUpTracing()
+	publishing := amqp.Publishing{Headers: amqp.Table{"x-retry-count": int32(1)}}
+
+	_, span := StartPublishSpan(context.Background(), "poll_queue", &publishing)
+	span.End()
+
+	assert.Equal(t, int32(1), publishing.Headers["x-retry-count"])
+	assert.Contains(t, publishing.Headers, "traceparent")
+}
+
+func TestConsumeSpanWithoutTraceContext(t *testing.T) {
+	// Setup
+	exporter := setUpTracing()
+
+	_, span := StartConsumeSpan(context.Background(), "create_queue", amqp.Delivery{})
+	span.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.False(t, spans[0].Parent.IsValid())
+}
+
+func setUpTracing() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
@@ -3,9 +3,13 @@
 package mocks
 
 import (
-	shared_models "service-shared/shared-models"
+	context "context"
 
 	mock "github.com/stretchr/testify/mock"
+
+	shared_models "service-shared/shared-models"
+
+	time "time"
 )
 
 // Repository is an autogenerated mock type for the Repository type
@@ -13,20 +17,43 @@ type Repository struct {
 	mock.Mock
 }
 
-// CreateApplication provides a mock function with given fields: firstName, lastName
-func (_m *Repository) CreateApplication(firstName string, lastName string) (string, error) {
-	ret := _m.Called(firstName, lastName)
+// CreateApplication provides a mock function with given fields: ctx, application, idempotencyKey
+func (_m *Repository) CreateApplication(ctx context.Context, application shared_models.NewApplication, idempotencyKey *shared_models.IdempotencyKeyEntry) (string, error) {
+	ret := _m.Called(ctx, application, idempotencyKey)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, string) string); ok {
-		r0 = rf(firstName, lastName)
+	if rf, ok := ret.Get(0).(func(context.Context, shared_models.NewApplication, *shared_models.IdempotencyKeyEntry) string); ok {
+		r0 = rf(ctx, application, idempotencyKey)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(firstName, lastName)
+	if rf, ok := ret.Get(1).(func(context.Context, shared_models.NewApplication, *shared_models.IdempotencyKeyEntry) error); ok {
+		r1 = rf(ctx, application, idempotencyKey)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateApplications provides a mock function with given fields: ctx, applications
+func (_m *Repository) CreateApplications(ctx context.Context, applications []shared_models.NewApplication) ([]string, error) {
+	ret := _m.Called(ctx, applications)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, []shared_models.NewApplication) []string); ok {
+		r0 = rf(ctx, applications)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []shared_models.NewApplication) error); ok {
+		r1 = rf(ctx, applications)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -34,13 +61,27 @@ func (_m *Repository) CreateApplication(firstName string, lastName string) (stri
 	return r0, r1
 }
 
-// GetApplication provides a mock function with given fields: applicationID
-func (_m *Repository) GetApplication(applicationID string) (*shared_models.ApplicationEntry, error) {
-	ret := _m.Called(applicationID)
+// ExportApplications provides a mock function with given fields: ctx, filter, fn
+func (_m *Repository) ExportApplications(ctx context.Context, filter shared_models.ApplicationFilter, fn func(entry shared_models.ApplicationEntry) error) error {
+	ret := _m.Called(ctx, filter, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, shared_models.ApplicationFilter, func(entry shared_models.ApplicationEntry) error) error); ok {
+		r0 = rf(ctx, filter, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetApplication provides a mock function with given fields: ctx, applicationID
+func (_m *Repository) GetApplication(ctx context.Context, applicationID string) (*shared_models.ApplicationEntry, error) {
+	ret := _m.Called(ctx, applicationID)
 
 	var r0 *shared_models.ApplicationEntry
-	if rf, ok := ret.Get(0).(func(string) *shared_models.ApplicationEntry); ok {
-		r0 = rf(applicationID)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *shared_models.ApplicationEntry); ok {
+		r0 = rf(ctx, applicationID)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*shared_models.ApplicationEntry)
@@ -48,8 +89,8 @@ func (_m *Repository) GetApplication(applicationID string) (*shared_models.Appli
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(applicationID)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, applicationID)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -57,22 +98,52 @@ func (_m *Repository) GetApplication(applicationID string) (*shared_models.Appli
 	return r0, r1
 }
 
-// GetApplicationsWithStatus provides a mock function with given fields: status
-func (_m *Repository) GetApplicationsWithStatus(status shared_models.Status) ([]shared_models.ApplicationEntry, error) {
-	ret := _m.Called(status)
+// GetApplicationsWithStatus provides a mock function with given fields: ctx, status, page
+func (_m *Repository) GetApplicationsWithStatus(ctx context.Context, status shared_models.Status, page shared_models.PageRequest) ([]shared_models.ApplicationEntry, string, error) {
+	ret := _m.Called(ctx, status, page)
 
 	var r0 []shared_models.ApplicationEntry
-	if rf, ok := ret.Get(0).(func(shared_models.Status) []shared_models.ApplicationEntry); ok {
-		r0 = rf(status)
+	if rf, ok := ret.Get(0).(func(context.Context, shared_models.Status, shared_models.PageRequest) []shared_models.ApplicationEntry); ok {
+		r0 = rf(ctx, status, page)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]shared_models.ApplicationEntry)
 		}
 	}
 
+	var r1 string
+	if rf, ok := ret.Get(1).(func(context.Context, shared_models.Status, shared_models.PageRequest) string); ok {
+		r1 = rf(ctx, status, page)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, shared_models.Status, shared_models.PageRequest) error); ok {
+		r2 = rf(ctx, status, page)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *Repository) GetIdempotencyKey(ctx context.Context, key string) (*shared_models.IdempotencyKeyEntry, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 *shared_models.IdempotencyKeyEntry
+	if rf, ok := ret.Get(0).(func(context.Context, string) *shared_models.IdempotencyKeyEntry); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*shared_models.IdempotencyKeyEntry)
+		}
+	}
+
 	var r1 error
-	if rf, ok := ret.Get(1).(func(shared_models.Status) error); ok {
-		r1 = rf(status)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -80,13 +151,173 @@ func (_m *Repository) GetApplicationsWithStatus(status shared_models.Status) ([]
 	return r0, r1
 }
 
-// RemoveApplication provides a mock function with given fields: applicationID
-func (_m *Repository) RemoveApplication(applicationID string) error {
-	ret := _m.Called(applicationID)
+// GetUnsentEventOutboxEntries provides a mock function with given fields: ctx, limit
+func (_m *Repository) GetUnsentEventOutboxEntries(ctx context.Context, limit int) ([]shared_models.EventOutboxEntry, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []shared_models.EventOutboxEntry
+	if rf, ok := ret.Get(0).(func(context.Context, int) []shared_models.EventOutboxEntry); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]shared_models.EventOutboxEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUnsentOutboxEntries provides a mock function with given fields: ctx, limit
+func (_m *Repository) GetUnsentOutboxEntries(ctx context.Context, limit int) ([]shared_models.OutboxEntry, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []shared_models.OutboxEntry
+	if rf, ok := ret.Get(0).(func(context.Context, int) []shared_models.OutboxEntry); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]shared_models.OutboxEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkEventOutboxEntrySent provides a mock function with given fields: ctx, entryID
+func (_m *Repository) MarkEventOutboxEntrySent(ctx context.Context, entryID string) error {
+	ret := _m.Called(ctx, entryID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, entryID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MarkOutboxEntrySent provides a mock function with given fields: ctx, entryID
+func (_m *Repository) MarkOutboxEntrySent(ctx context.Context, entryID string) error {
+	ret := _m.Called(ctx, entryID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, entryID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RecordReconciliation provides a mock function with given fields: ctx, applicationID, reconciliation
+func (_m *Repository) RecordReconciliation(ctx context.Context, applicationID string, reconciliation shared_models.Reconciliation) error {
+	ret := _m.Called(ctx, applicationID, reconciliation)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, shared_models.Reconciliation) error); ok {
+		r0 = rf(ctx, applicationID, reconciliation)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RecordSubmission provides a mock function with given fields: ctx, applicationID, bankApplicationID, submittedAt
+func (_m *Repository) RecordSubmission(ctx context.Context, applicationID string, bankApplicationID string, submittedAt time.Time) error {
+	ret := _m.Called(ctx, applicationID, bankApplicationID, submittedAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) error); ok {
+		r0 = rf(ctx, applicationID, bankApplicationID, submittedAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RecordWebhookAttempt provides a mock function with given fields: ctx, attempt
+func (_m *Repository) RecordWebhookAttempt(ctx context.Context, attempt shared_models.WebhookAttemptEntry) error {
+	ret := _m.Called(ctx, attempt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, shared_models.WebhookAttemptEntry) error); ok {
+		r0 = rf(ctx, attempt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RemoveApplication provides a mock function with given fields: ctx, applicationID
+func (_m *Repository) RemoveApplication(ctx context.Context, applicationID string) error {
+	ret := _m.Called(ctx, applicationID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, applicationID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchApplications provides a mock function with given fields: ctx, filter, page
+func (_m *Repository) SearchApplications(ctx context.Context, filter shared_models.ApplicationFilter, page shared_models.PageRequest) ([]shared_models.ApplicationEntry, string, error) {
+	ret := _m.Called(ctx, filter, page)
+
+	var r0 []shared_models.ApplicationEntry
+	if rf, ok := ret.Get(0).(func(context.Context, shared_models.ApplicationFilter, shared_models.PageRequest) []shared_models.ApplicationEntry); ok {
+		r0 = rf(ctx, filter, page)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]shared_models.ApplicationEntry)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(context.Context, shared_models.ApplicationFilter, shared_models.PageRequest) string); ok {
+		r1 = rf(ctx, filter, page)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, shared_models.ApplicationFilter, shared_models.PageRequest) error); ok {
+		r2 = rf(ctx, filter, page)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// UpdateApplicationStatus provides a mock function with given fields: ctx, applicationID, change
+func (_m *Repository) UpdateApplicationStatus(ctx context.Context, applicationID string, change shared_models.StatusChange) error {
+	ret := _m.Called(ctx, applicationID, change)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(applicationID)
+	if rf, ok := ret.Get(0).(func(context.Context, string, shared_models.StatusChange) error); ok {
+		r0 = rf(ctx, applicationID, change)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -94,13 +325,13 @@ func (_m *Repository) RemoveApplication(applicationID string) error {
 	return r0
 }
 
-// UpdateApplicationStatus provides a mock function with given fields: applicationID, status
-func (_m *Repository) UpdateApplicationStatus(applicationID string, status shared_models.Status) error {
-	ret := _m.Called(applicationID, status)
+// UpdateApplicationStatusWithEvent provides a mock function with given fields: ctx, applicationID, change
+func (_m *Repository) UpdateApplicationStatusWithEvent(ctx context.Context, applicationID string, change shared_models.StatusChange) error {
+	ret := _m.Called(ctx, applicationID, change)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, shared_models.Status) error); ok {
-		r0 = rf(applicationID, status)
+	if rf, ok := ret.Get(0).(func(context.Context, string, shared_models.StatusChange) error); ok {
+		r0 = rf(ctx, applicationID, change)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -4,9 +4,12 @@ package mocks
 
 import (
 	io "io"
+
 	http "service-shared/http"
 
 	mock "github.com/stretchr/testify/mock"
+
+	context "context"
 )
 
 // Client is an autogenerated mock type for the Client type
@@ -14,13 +17,36 @@ type Client struct {
 	mock.Mock
 }
 
-// Get provides a mock function with given fields: url
-func (_m *Client) Get(url string) (*http.ClientResponse, error) {
-	ret := _m.Called(url)
+// Get provides a mock function with given fields: ctx, url
+func (_m *Client) Get(ctx context.Context, url string) (*http.ClientResponse, error) {
+	ret := _m.Called(ctx, url)
+
+	var r0 *http.ClientResponse
+	if rf, ok := ret.Get(0).(func(context.Context, string) *http.ClientResponse); ok {
+		r0 = rf(ctx, url)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*http.ClientResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, url)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Post provides a mock function with given fields: ctx, url, contentType, body
+func (_m *Client) Post(ctx context.Context, url string, contentType string, body io.Reader) (*http.ClientResponse, error) {
+	ret := _m.Called(ctx, url, contentType, body)
 
 	var r0 *http.ClientResponse
-	if rf, ok := ret.Get(0).(func(string) *http.ClientResponse); ok {
-		r0 = rf(url)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, io.Reader) *http.ClientResponse); ok {
+		r0 = rf(ctx, url, contentType, body)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*http.ClientResponse)
@@ -28,8 +54,8 @@ func (_m *Client) Get(url string) (*http.ClientResponse, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(url)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, io.Reader) error); ok {
+		r1 = rf(ctx, url, contentType, body)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -37,13 +63,13 @@ func (_m *Client) Get(url string) (*http.ClientResponse, error) {
 	return r0, r1
 }
 
-// Post provides a mock function with given fields: url, contentType, body
-func (_m *Client) Post(url string, contentType string, body io.Reader) (*http.ClientResponse, error) {
-	ret := _m.Called(url, contentType, body)
+// PostWithHeaders provides a mock function with given fields: ctx, url, headers, body
+func (_m *Client) PostWithHeaders(ctx context.Context, url string, headers map[string]string, body io.Reader) (*http.ClientResponse, error) {
+	ret := _m.Called(ctx, url, headers, body)
 
 	var r0 *http.ClientResponse
-	if rf, ok := ret.Get(0).(func(string, string, io.Reader) *http.ClientResponse); ok {
-		r0 = rf(url, contentType, body)
+	if rf, ok := ret.Get(0).(func(context.Context, string, map[string]string, io.Reader) *http.ClientResponse); ok {
+		r0 = rf(ctx, url, headers, body)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*http.ClientResponse)
@@ -51,8 +77,8 @@ func (_m *Client) Post(url string, contentType string, body io.Reader) (*http.Cl
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string, io.Reader) error); ok {
-		r1 = rf(url, contentType, body)
+	if rf, ok := ret.Get(1).(func(context.Context, string, map[string]string, io.Reader) error); ok {
+		r1 = rf(ctx, url, headers, body)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -2,16 +2,29 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // Consumer is an autogenerated mock type for the Consumer type
 type Consumer struct {
 	mock.Mock
 }
 
-// Consume provides a mock function with given fields:
-func (_m *Consumer) Consume() {
-	_m.Called()
+// Consume provides a mock function with given fields: ctx
+func (_m *Consumer) Consume(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
 }
 
 type mockConstructorTestingTNewConsumer interface {
@@ -27,6 +27,20 @@ func (_m *DeliveryHandler) Ack(multiple bool, delivery amqp091.Delivery) error {
 	return r0
 }
 
+// DeadLetter provides a mock function with given fields: reason, delivery
+func (_m *DeliveryHandler) DeadLetter(reason string, delivery amqp091.Delivery) error {
+	ret := _m.Called(reason, delivery)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, amqp091.Delivery) error); ok {
+		r0 = rf(reason, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Nack provides a mock function with given fields: multiple, requeue, delivery
 func (_m *DeliveryHandler) Nack(multiple bool, requeue bool, delivery amqp091.Delivery) error {
 	ret := _m.Called(multiple, requeue, delivery)
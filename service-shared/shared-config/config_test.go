@@ -0,0 +1,45 @@
+package shared_config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	assert.Nil(t, Get().Validate())
+}
+
+func TestValidateBackoffBase(t *testing.T) {
+	cfg := Get()
+	cfg.PollBackoffBase = 0
+
+	err := cfg.Validate()
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "POLL_BACKOFF_BASE")
+}
+
+func TestValidateBackoffMaxBelowBase(t *testing.T) {
+	cfg := Get()
+	cfg.WebhookBackoffMax = cfg.WebhookBackoffBase - time.Second
+
+	err := cfg.Validate()
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "WEBHOOK_BACKOFF_MAX")
+}
+
+func TestValidateReconnectBase(t *testing.T) {
+	cfg := Get()
+	cfg.RabbitMQReconnectBase = -time.Second
+
+	assert.NotNil(t, cfg.Validate())
+}
+
+func TestValidateBackoffJitter(t *testing.T) {
+	cfg := Get()
+	cfg.PollBackoffJitter = 1.5
+
+	assert.NotNil(t, cfg.Validate())
+}
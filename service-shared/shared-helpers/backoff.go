@@ -0,0 +1,55 @@
+package shared_helpers
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+Backoff computes exponentially increasing delays, for example between retries.
+
+The delay for attempt n is Base * 2^n, capped at Max. The delay is then varied randomly
+by up to Jitter, a fraction between 0 and 1, so that retries which were scheduled at the
+same time are spread out.
+
+A Backoff whose Base is not positive has no delay, rather than one which can never reach Max.
+Services validate their backoffs when they read their config, see shared_config.Config.Validate.
+*/
+type Backoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+}
+
+//Delay returns the delay before the given attempt, counting from 0.
+func (backoff Backoff) Delay(attempt int) time.Duration {
+	if backoff.Base <= 0 {
+		return 0
+	}
+	delay := backoff.Base
+	for i := 0; i < attempt && delay < backoff.Max; i++ {
+		delay *= 2
+	}
+	if delay > backoff.Max {
+		delay = backoff.Max
+	}
+
+	if backoff.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * backoff.Jitter * float64(delay))
+	}
+
+	return delay
+}
+
+//Steps returns the number of attempts before the delay reaches Max. Every later attempt is delayed by Max.
+func (backoff Backoff) Steps() int {
+	if backoff.Base <= 0 {
+		return 0
+	}
+	steps := 0
+	for delay := backoff.Base; delay < backoff.Max; delay *= 2 {
+		steps++
+	}
+
+	return steps
+}
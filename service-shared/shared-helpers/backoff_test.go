@@ -0,0 +1,56 @@
+package shared_helpers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelayDoubles(t *testing.T) {
+	backoff := Backoff{Base: time.Second, Max: time.Minute}
+
+	assert.Equal(t, time.Second, backoff.Delay(0))
+	assert.Equal(t, 2*time.Second, backoff.Delay(1))
+	assert.Equal(t, 8*time.Second, backoff.Delay(3))
+}
+
+func TestBackoffDelayIsCapped(t *testing.T) {
+	backoff := Backoff{Base: time.Second, Max: 10 * time.Second}
+
+	assert.Equal(t, 10*time.Second, backoff.Delay(4))
+	assert.Equal(t, 10*time.Second, backoff.Delay(100))
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	backoff := Backoff{Base: 10 * time.Second, Max: time.Minute, Jitter: 0.2}
+
+	for i := 0; i < 100; i++ {
+		delay := backoff.Delay(0)
+		assert.GreaterOrEqual(t, delay, 8*time.Second)
+		assert.LessOrEqual(t, delay, 12*time.Second)
+	}
+}
+
+func TestBackoffSteps(t *testing.T) {
+	backoff := Backoff{Base: time.Second, Max: 10 * time.Second}
+
+	// 1s, 2s, 4s, 8s then 10s
+	assert.Equal(t, 4, backoff.Steps())
+}
+
+func TestBackoffWithoutBase(t *testing.T) {
+	for _, base := range []time.Duration{0, -time.Second} {
+		backoff := Backoff{Base: base, Max: 10 * time.Second, Jitter: 0.2}
+
+		// Must return, rather than doubling a delay which never reaches Max
+		assert.Equal(t, 0, backoff.Steps())
+		assert.Equal(t, time.Duration(0), backoff.Delay(3))
+	}
+}
+
+func TestBackoffMaxBelowBase(t *testing.T) {
+	backoff := Backoff{Base: 10 * time.Second, Max: time.Second}
+
+	assert.Equal(t, 0, backoff.Steps())
+	assert.Equal(t, time.Second, backoff.Delay(0))
+}
@@ -1,9 +1,29 @@
 package shared_helpers
 
-import "log"
+import (
+	"log"
+	"sync"
+	"time"
+)
 
 func FailOnError(err error, msg string) {
 	if err != nil {
 		log.Fatal(msg, err)
 	}
 }
+
+//WaitTimeout waits for wg, for up to timeout. Returns false if the timeout passed first.
+func WaitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,23 @@
+package shared_helpers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitTimeoutDone(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go wg.Done()
+
+	assert.True(t, WaitTimeout(wg, time.Second))
+}
+
+func TestWaitTimeoutTimesOut(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	assert.False(t, WaitTimeout(wg, 10*time.Millisecond))
+}
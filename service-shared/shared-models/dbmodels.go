@@ -1,11 +1,125 @@
 package shared_models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
 
-//ApplicationEntry represents an entry in the database for a loan application
+// The services which change an application, recorded as the Source of each StatusChange
+const (
+	APIGatewayService        = "api-gateway"
+	CreateApplicationService = "create-application-service"
+	PollApplicationService   = "poll-application-service"
+)
+
+/*
+ApplicationEntry represents an entry in the database for a loan application.
+
+BankApplicationID and SubmittedAt are set once the application has been created with the bank API,
+and DecidedAt once it reaches a terminal status. History is append-only, and records each step in
+the lifecycle of the application, oldest first. If CallbackURL is set, a webhook is sent to it once
+the application is completed or rejected.
+*/
 type ApplicationEntry struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"application_id"`
-	Status    Status             `bson:"status,omitempty" json:"status"`
-	FirstName string             `bson:"firstname,omitempty" json:"first_name"`
-	LastName  string             `bson:"lastname,omitempty" json:"last_name"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"application_id"`
+	Status            Status             `bson:"status,omitempty" json:"status"`
+	FirstName         string             `bson:"firstname,omitempty" json:"first_name"`
+	LastName          string             `bson:"lastname,omitempty" json:"last_name"`
+	BankApplicationID string             `bson:"bank_application_id,omitempty" json:"bank_application_id,omitempty"`
+	CreatedAt         *time.Time         `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	SubmittedAt       *time.Time         `bson:"submitted_at,omitempty" json:"submitted_at,omitempty"`
+	DecidedAt         *time.Time         `bson:"decided_at,omitempty" json:"decided_at,omitempty"`
+	History           []StatusChange     `bson:"history,omitempty" json:"history,omitempty"`
+	CallbackURL       string             `bson:"callback_url,omitempty" json:"callback_url,omitempty"`
+	Reconciliation    *Reconciliation    `bson:"reconciliation,omitempty" json:"reconciliation,omitempty"`
+	LoanDetails       `bson:",inline"`
+}
+
+/*
+Reconciliation records that an application must be reconciled with the bank, because it was withdrawn after it
+was submitted. BankDecision is set if the bank decided the application after it was withdrawn.
+RequiredAt is when the need to reconcile the application was first found.
+*/
+type Reconciliation struct {
+	Reason       string    `bson:"reason" json:"reason"`
+	BankDecision Status    `bson:"bank_decision,omitempty" json:"bank_decision,omitempty"`
+	RequiredAt   time.Time `bson:"required_at" json:"required_at"`
+}
+
+//NewApplication holds the details given by a client for an application which is to be created
+type NewApplication struct {
+	FirstName   string
+	LastName    string
+	CallbackURL string
+	LoanDetails
+}
+
+//StatusChange is an entry in the history of an application. Source is the service which made the change.
+type StatusChange struct {
+	Status Status    `bson:"status" json:"status"`
+	Source string    `bson:"source" json:"source"`
+	Reason string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
+/*
+OutboxEntry represents a CreateLoanMessage which is waiting to be published to the create application queue.
+It is written in the same transaction as the ApplicationEntry it refers to, so a loan application is never
+stored without a matching message. Sent is set once the message has been handed to the message queue.
+TraceContext holds the W3C trace context of the request which created the application, so that the message
+is published as part of the same trace.
+*/
+type OutboxEntry struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Message      CreateLoanMessage  `bson:"message"`
+	Sent         bool               `bson:"sent"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	SentAt       *time.Time         `bson:"sent_at,omitempty"`
+	TraceContext map[string]string  `bson:"trace_context,omitempty"`
+}
+
+/*
+EventOutboxEntry represents a StatusChangedEvent which is waiting to be published to the status event exchange.
+It is written in the same transaction as the change of status it announces, so that a decision is never recorded
+without its event, which is what triggers the webhook for the application.
+*/
+type EventOutboxEntry struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Event        StatusChangedEvent `bson:"event"`
+	Sent         bool               `bson:"sent"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	SentAt       *time.Time         `bson:"sent_at,omitempty"`
+	TraceContext map[string]string  `bson:"trace_context,omitempty"`
+}
+
+/*
+IdempotencyKeyEntry records a create application request made with an Idempotency-Key header.
+It is written in the same transaction as the ApplicationEntry it created, so that a retry of the
+request can be answered with the original application. RequestHash identifies the request body,
+so that a key reused for a different request can be rejected.
+*/
+type IdempotencyKeyEntry struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Key           string             `bson:"key"`
+	RequestHash   string             `bson:"request_hash"`
+	ApplicationID string             `bson:"application_id"`
+	Status        Status             `bson:"status"`
+	CreatedAt     time.Time          `bson:"created_at"`
+}
+
+/*
+WebhookAttemptEntry records an attempt to deliver a webhook for a decided application to its CallbackURL.
+ResponseStatus is the HTTP status returned by the receiver, and is zero if no response was received, in
+which case Error says why. Attempt counts from 1, and Delivered is set for the attempt which succeeded.
+*/
+type WebhookAttemptEntry struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	ApplicationID  string             `bson:"application_id"`
+	URL            string             `bson:"url"`
+	Status         Status             `bson:"status"`
+	Attempt        int                `bson:"attempt"`
+	ResponseStatus int                `bson:"response_status,omitempty"`
+	Error          string             `bson:"error,omitempty"`
+	Delivered      bool               `bson:"delivered"`
+	AttemptedAt    time.Time          `bson:"attempted_at"`
 }
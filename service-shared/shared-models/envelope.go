@@ -0,0 +1,121 @@
+package shared_models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// *** Envelope for the messages on the create and poll queues *** //
+
+// Types of message carried in an Envelope. The type is also set as the AMQP type of the message.
+const (
+	CreateLoanMessageType = "create_loan"
+	PollLoanMessageType   = "poll_loan"
+)
+
+//ErrUnsupportedSchemaVersion is returned when a message was published with a later schema than this build understands.
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+/*
+Envelope wraps the payload of a message on the create and poll queues, recording the schema of the payload
+and where it came from.
+
+MessageID identifies the message, and is kept if the same message is published again, so that consumers can
+recognise duplicates. CorrelationID is the MessageID of the first message published for a loan application, and
+is carried by every message published in response to it.
+
+The Payload is held as JSON whichever format the message is published in, so that the same upgrades apply
+to every format. Messages published before envelopes were introduced are the bare payload, which is read into
+an Envelope without a SchemaVersion or Type. Decoders treat them as the oldest version of their schema, so that
+they can still be consumed after an upgrade.
+*/
+type Envelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	MessageID     string          `json:"message_id"`
+	CorrelationID string          `json:"correlation_id"`
+	ProducedAt    time.Time       `json:"produced_at"`
+	Producer      string          `json:"producer"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+//Upgrade converts a payload from the schema version it is registered against to the next version.
+type Upgrade func(payload json.RawMessage) (json.RawMessage, error)
+
+/*
+NewEnvelope returns an Envelope of payload, with a new MessageID. If correlationID is empty, the message
+starts a new correlation, and its CorrelationID is its own MessageID.
+*/
+func NewEnvelope(messageType string, schemaVersion int, producer, correlationID string, payload interface{}) (Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	messageID := primitive.NewObjectID().Hex()
+	if len(correlationID) == 0 {
+		correlationID = messageID
+	}
+
+	return Envelope{
+		SchemaVersion: schemaVersion,
+		MessageID:     messageID,
+		CorrelationID: correlationID,
+		ProducedAt:    time.Now().UTC(),
+		Producer:      producer,
+		Type:          messageType,
+		Payload:       body,
+	}, nil
+}
+
+//DecodeCreateLoanMessage decodes the payload of envelope, upgrading it from the version it was published with.
+func DecodeCreateLoanMessage(envelope Envelope) (CreateLoanMessage, error) {
+	var message CreateLoanMessage
+	err := decode(envelope, CreateLoanMessageType, CreateLoanMessageVersion, createLoanMessageUpgrades, legacyCreateLoanVersion, &message)
+	return message, err
+}
+
+//DecodePollLoanMessage decodes the payload of envelope, upgrading it from the version it was published with.
+func DecodePollLoanMessage(envelope Envelope) (PollLoanMessage, error) {
+	var message PollLoanMessage
+	err := decode(envelope, PollLoanMessageType, PollLoanMessageVersion, pollLoanMessageUpgrades, legacyPollLoanVersion, &message)
+	return message, err
+}
+
+/*
+decode upgrades the payload of envelope one version at a time to currentVersion, before unmarshalling it into message.
+An envelope without a SchemaVersion holds a bare payload, of the version returned by legacyVersion.
+
+Returns an error wrapping ErrUnsupportedSchemaVersion if the message is newer than currentVersion, or if there is no
+upgrade from one of the versions in between.
+*/
+func decode(envelope Envelope, messageType string, currentVersion int, upgrades map[int]Upgrade, legacyVersion func([]byte) int, message interface{}) error {
+	version := envelope.SchemaVersion
+	if version == 0 && len(envelope.Type) == 0 {
+		version = legacyVersion(envelope.Payload)
+	} else if envelope.Type != messageType {
+		return errors.New(fmt.Sprintf("Expected a %s message, got %s", messageType, envelope.Type))
+	}
+	if version > currentVersion {
+		return fmt.Errorf("%w : %s version %d is newer than version %d", ErrUnsupportedSchemaVersion,
+			messageType, version, currentVersion)
+	}
+
+	payload := envelope.Payload
+	for ; version < currentVersion; version++ {
+		upgrade, ok := upgrades[version]
+		if !ok {
+			return fmt.Errorf("%w : no upgrade from %s version %d", ErrUnsupportedSchemaVersion, messageType, version)
+		}
+
+		var err error
+		if payload, err = upgrade(payload); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(payload, message)
+}
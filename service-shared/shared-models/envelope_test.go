@@ -0,0 +1,100 @@
+package shared_models
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewEnvelopeStartsCorrelation(t *testing.T) {
+	envelope, err := NewEnvelope(PollLoanMessageType, PollLoanMessageVersion, PollApplicationService, "", PollLoanMessage{OurApplicationID: "abc"})
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, envelope.MessageID)
+	assert.Equal(t, envelope.MessageID, envelope.CorrelationID)
+	assert.Equal(t, PollApplicationService, envelope.Producer)
+	assert.False(t, envelope.ProducedAt.IsZero())
+}
+
+func TestNewEnvelopeContinuesCorrelation(t *testing.T) {
+	first, _ := NewEnvelope(PollLoanMessageType, PollLoanMessageVersion, PollApplicationService, "correlation", PollLoanMessage{})
+	second, _ := NewEnvelope(PollLoanMessageType, PollLoanMessageVersion, PollApplicationService, "correlation", PollLoanMessage{})
+
+	assert.Equal(t, "correlation", first.CorrelationID)
+	assert.NotEqual(t, first.MessageID, second.MessageID)
+}
+
+func TestDecodeCreateLoanMessage(t *testing.T) {
+	expected := CreateLoanMessage{ApplicationID: "abc", FirstName: "First", LastName: "Last", LoanDetails: getLoanDetails()}
+	envelope, _ := NewEnvelope(CreateLoanMessageType, CreateLoanMessageVersion, APIGatewayService, "", expected)
+
+	message, err := DecodeCreateLoanMessage(envelope)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, message)
+}
+
+func TestDecodeCreateLoanMessageUpgradesV1(t *testing.T) {
+	envelope, _ := NewEnvelope(CreateLoanMessageType, CreateLoanMessageV1, APIGatewayService, "",
+		map[string]string{"application_id": "abc", "first_name": "First", "last_name": "Last"})
+
+	message, err := DecodeCreateLoanMessage(envelope)
+
+	assert.Nil(t, err)
+	assert.Equal(t, CreateLoanMessage{ApplicationID: "abc", FirstName: "First", LastName: "Last"}, message)
+}
+
+func TestDecodeBareCreateLoanMessage(t *testing.T) {
+	bodies := map[string]string{
+		`{"application_id":"abc","first_name":"First","last_name":"Last"}`:                              "",
+		`{"version":2,"application_id":"abc","first_name":"First","last_name":"Last","currency":"GBP"}`: "GBP",
+	}
+
+	for body, currency := range bodies {
+		message, err := DecodeCreateLoanMessage(Envelope{Payload: []byte(body)})
+
+		assert.Nil(t, err, body)
+		assert.Equal(t, "abc", message.ApplicationID)
+		assert.Equal(t, currency, message.Currency)
+	}
+}
+
+func TestDecodeCreateLoanMessageUnsupportedVersion(t *testing.T) {
+	envelope, _ := NewEnvelope(CreateLoanMessageType, CreateLoanMessageVersion+1, APIGatewayService, "", CreateLoanMessage{})
+
+	_, err := DecodeCreateLoanMessage(envelope)
+
+	assert.True(t, errors.Is(err, ErrUnsupportedSchemaVersion))
+}
+
+func TestDecodeCreateLoanMessageWrongType(t *testing.T) {
+	envelope, _ := NewEnvelope(PollLoanMessageType, PollLoanMessageVersion, PollApplicationService, "", PollLoanMessage{})
+
+	_, err := DecodeCreateLoanMessage(envelope)
+
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrUnsupportedSchemaVersion))
+}
+
+func TestDecodePollLoanMessage(t *testing.T) {
+	expected := PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "bank", Attempt: 2}
+	envelope, _ := NewEnvelope(PollLoanMessageType, PollLoanMessageVersion, CreateApplicationService, "correlation", expected)
+
+	message, err := DecodePollLoanMessage(envelope)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, message)
+}
+
+func TestDecodeBarePollLoanMessage(t *testing.T) {
+	message, err := DecodePollLoanMessage(Envelope{Payload: []byte(`{"our_id":"abc","application_id":"bank","attempt":3}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, PollLoanMessage{OurApplicationID: "abc", BankApplicationID: "bank", Attempt: 3}, message)
+}
+
+func TestDecodeInvalidJSON(t *testing.T) {
+	_, err := DecodePollLoanMessage(Envelope{Payload: []byte("{invalidjson,")})
+
+	assert.NotNil(t, err)
+}
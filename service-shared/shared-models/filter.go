@@ -0,0 +1,24 @@
+package shared_models
+
+import "time"
+
+/*
+ApplicationFilter describes the applications to find in a search. Every field which is set must match,
+so filters can be combined to narrow a search. An empty filter matches every application.
+
+LastName must match exactly, while FirstNamePrefix matches the start of the first name. Both are case sensitive.
+CreatedFrom is inclusive and CreatedTo is exclusive. An application matches any one of Statuses.
+*/
+type ApplicationFilter struct {
+	LastName        string
+	FirstNamePrefix string
+	CreatedFrom     *time.Time
+	CreatedTo       *time.Time
+	Statuses        []Status
+}
+
+//IsEmpty returns true if the filter matches every application
+func (filter ApplicationFilter) IsEmpty() bool {
+	return len(filter.LastName) == 0 && len(filter.FirstNamePrefix) == 0 &&
+		filter.CreatedFrom == nil && filter.CreatedTo == nil && len(filter.Statuses) == 0
+}
@@ -0,0 +1,98 @@
+package shared_models
+
+import (
+	"github.com/go-playground/validator/v10"
+	"time"
+)
+
+// Limits on the details of a loan application. Amounts are in minor units, eg pence.
+const (
+	MinLoanAmount   = 100000    // 1,000.00
+	MaxLoanAmount   = 100000000 // 1,000,000.00
+	MinTermMonths   = 6
+	MaxTermMonths   = 360
+	MaxAnnualIncome = 10000000000 // 100,000,000.00
+	MinApplicantAge = 18
+	MaxApplicantAge = 120
+
+	// DateOfBirthLayout is the layout of LoanDetails.DateOfBirth, a date without a time zone
+	DateOfBirthLayout = "2006-01-02"
+)
+
+/*
+LoanDetails are the details of the loan applied for, and of the applicant. LoanAmount and AnnualIncome are
+in minor units of Currency, an ISO 4217 code. TermMonths is how long the loan is repaid over.
+
+Applications created before these details were introduced do not have them, so every field is omitted when empty.
+The binding tags are checked by the api gateway, using the validations registered by RegisterValidations.
+*/
+type LoanDetails struct {
+	LoanAmount   int64       `bson:"loan_amount,omitempty" json:"loan_amount,omitempty" binding:"required,min=100000,max=100000000"`
+	Currency     string      `bson:"currency,omitempty" json:"currency,omitempty" binding:"required,iso4217"`
+	TermMonths   int         `bson:"term_months,omitempty" json:"term_months,omitempty" binding:"required,min=6,max=360"`
+	Purpose      LoanPurpose `bson:"purpose,omitempty" json:"purpose,omitempty" binding:"required,validpurpose"`
+	AnnualIncome int64       `bson:"annual_income,omitempty" json:"annual_income,omitempty" binding:"required,min=1,max=10000000000"`
+	Email        string      `bson:"email,omitempty" json:"email,omitempty" binding:"required,email,max=254"`
+	DateOfBirth  string      `bson:"date_of_birth,omitempty" json:"date_of_birth,omitempty" binding:"required,validdateofbirth"`
+}
+
+//LoanPurpose is what a loan is for
+type LoanPurpose string
+
+const (
+	HomeImprovement   LoanPurpose = "home_improvement"
+	Car               LoanPurpose = "car"
+	DebtConsolidation LoanPurpose = "debt_consolidation"
+	Education         LoanPurpose = "education"
+	Other             LoanPurpose = "other"
+)
+
+//LoanPurposes lists every valid LoanPurpose.
+var LoanPurposes = []LoanPurpose{HomeImprovement, Car, DebtConsolidation, Education, Other}
+
+func (p LoanPurpose) IsValid() bool {
+	switch p {
+	case HomeImprovement, Car, DebtConsolidation, Education, Other:
+		return true
+	}
+
+	return false
+}
+
+// ValidPurpose is a validator function used to ensure that string representations of a loan's purpose are valid.
+var ValidPurpose validator.Func = func(f1 validator.FieldLevel) bool {
+	purpose, ok := f1.Field().Interface().(Enum)
+	if ok {
+		return purpose.IsValid()
+	}
+
+	return false
+}
+
+// ValidDateOfBirth is a validator function used to ensure that an applicant's date of birth is a
+// date in the form YYYY-MM-DD, and that they are between MinApplicantAge and MaxApplicantAge.
+var ValidDateOfBirth validator.Func = func(f1 validator.FieldLevel) bool {
+	dateOfBirth, err := time.Parse(DateOfBirthLayout, f1.Field().String())
+	if err != nil {
+		return false
+	}
+
+	now := time.Now().UTC()
+	return !dateOfBirth.After(now.AddDate(-MinApplicantAge, 0, 0)) && dateOfBirth.After(now.AddDate(-MaxApplicantAge, 0, 0))
+}
+
+//RegisterValidations registers the custom validations used in binding tags, such as validstatus, with v.
+func RegisterValidations(v *validator.Validate) error {
+	validations := map[string]validator.Func{
+		"validstatus":      ValidStatus,
+		"validpurpose":     ValidPurpose,
+		"validdateofbirth": ValidDateOfBirth,
+	}
+	for tag, validation := range validations {
+		if err := v.RegisterValidation(tag, validation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
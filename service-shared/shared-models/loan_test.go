@@ -0,0 +1,83 @@
+package shared_models
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLoanPurposesAreValid(t *testing.T) {
+	for _, purpose := range LoanPurposes {
+		assert.True(t, purpose.IsValid(), purpose)
+	}
+	assert.False(t, LoanPurpose("holiday").IsValid())
+}
+
+func TestLoanDetailsValid(t *testing.T) {
+	validate := getValidator(t)
+
+	assert.Nil(t, validate.Struct(getLoanDetails()))
+}
+
+func TestLoanDetailsInvalid(t *testing.T) {
+	validate := getValidator(t)
+	invalid := map[string]func(details *LoanDetails){
+		"LoanAmount":   func(details *LoanDetails) { details.LoanAmount = MinLoanAmount - 1 },
+		"Currency":     func(details *LoanDetails) { details.Currency = "XYZ" },
+		"TermMonths":   func(details *LoanDetails) { details.TermMonths = MaxTermMonths + 1 },
+		"Purpose":      func(details *LoanDetails) { details.Purpose = "holiday" },
+		"AnnualIncome": func(details *LoanDetails) { details.AnnualIncome = 0 },
+		"Email":        func(details *LoanDetails) { details.Email = "first.last" },
+		"DateOfBirth":  func(details *LoanDetails) { details.DateOfBirth = "31/01/1990" },
+	}
+
+	for field, change := range invalid {
+		details := getLoanDetails()
+		change(&details)
+
+		err := validate.Struct(details)
+
+		if assert.IsType(t, validator.ValidationErrors{}, err, field) {
+			assert.Equal(t, field, err.(validator.ValidationErrors)[0].Field())
+		}
+	}
+}
+
+func TestValidDateOfBirthAge(t *testing.T) {
+	validate := getValidator(t)
+	now := time.Now().UTC()
+	dates := map[string]bool{
+		now.AddDate(-MinApplicantAge, 0, 0).Format(DateOfBirthLayout):  true,
+		now.AddDate(-MinApplicantAge, 0, 1).Format(DateOfBirthLayout):  false,
+		now.AddDate(-MaxApplicantAge, 0, 1).Format(DateOfBirthLayout):  true,
+		now.AddDate(-MaxApplicantAge, 0, -1).Format(DateOfBirthLayout): false,
+		now.AddDate(1, 0, 0).Format(DateOfBirthLayout):                 false,
+	}
+
+	for dateOfBirth, valid := range dates {
+		err := validate.Var(dateOfBirth, "validdateofbirth")
+
+		assert.Equal(t, valid, err == nil, dateOfBirth)
+	}
+}
+
+// getValidator returns a validator which reads binding tags, as the api gateway does, with the custom validations registered
+func getValidator(t *testing.T) *validator.Validate {
+	validate := validator.New()
+	validate.SetTagName("binding")
+	assert.Nil(t, RegisterValidations(validate))
+	return validate
+}
+
+func getLoanDetails() LoanDetails {
+	return LoanDetails{
+		LoanAmount:   1500000,
+		Currency:     "GBP",
+		TermMonths:   36,
+		Purpose:      Car,
+		AnnualIncome: 4200000,
+		Email:        "first.last@example.com",
+		DateOfBirth:  "1990-01-31",
+	}
+}
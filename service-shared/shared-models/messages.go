@@ -1,16 +1,72 @@
 package shared_models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // *** Model format for the message queue *** //
 
+// Versions of CreateLoanMessage
+const (
+	// CreateLoanMessageV1 has only the name of the applicant. Bare messages published before versions were introduced are V1
+	CreateLoanMessageV1 = 1
+	// CreateLoanMessageV2 adds the LoanDetails
+	CreateLoanMessageV2 = 2
+	// CreateLoanMessageVersion is the version published by this build
+	CreateLoanMessageVersion = CreateLoanMessageV2
+)
+
 /*
 CreateLoanMessage represents the data passed to the create loan queue. Consumers will pass this data to the bank API
 Here, the ApplicationID refers to the ID that we store in our database. Note that it is distinctly different from the
 application_id fields returned by the bank API.
+
+Messages may be in flight, or waiting in the outbox, while the services are upgraded, so each message is published
+in an Envelope recording the version of its schema. A consumer must handle every version up to the one it publishes,
+and should dead-letter a message with a later version rather than drop the fields it does not know.
 */
 type CreateLoanMessage struct {
 	ApplicationID string `json:"application_id" binding:"required"`
 	FirstName     string `json:"first_name" binding:"required"`
 	LastName      string `json:"last_name" binding:"required"`
+	LoanDetails   `bson:",inline"`
+}
+
+//createLoanMessageUpgrades upgrades a CreateLoanMessage payload to the next version
+var createLoanMessageUpgrades = map[int]Upgrade{
+	// The LoanDetails added by V2 are optional, so a V1 payload is a V2 payload without them
+	CreateLoanMessageV1: func(payload json.RawMessage) (json.RawMessage, error) { return payload, nil },
+}
+
+/*
+legacyCreateLoanVersion returns the version of a bare CreateLoanMessage, published before envelopes were introduced.
+Bare V2 messages recorded their version in the message itself, earlier messages have no version and are V1.
+*/
+func legacyCreateLoanVersion(body []byte) int {
+	var legacy struct {
+		Version int `json:"version"`
+	}
+	if json.Unmarshal(body, &legacy) != nil || legacy.Version == 0 {
+		return CreateLoanMessageV1
+	}
+
+	return legacy.Version
+}
+
+// Versions of PollLoanMessage
+const (
+	PollLoanMessageV1 = 1
+	// PollLoanMessageVersion is the version published by this build
+	PollLoanMessageVersion = PollLoanMessageV1
+)
+
+//pollLoanMessageUpgrades upgrades a PollLoanMessage payload to the next version
+var pollLoanMessageUpgrades = map[int]Upgrade{}
+
+//legacyPollLoanVersion returns the version of a bare PollLoanMessage, published before envelopes were introduced.
+func legacyPollLoanVersion(body []byte) int {
+	return PollLoanMessageV1
 }
 
 /*
@@ -19,8 +75,59 @@ A consumer is expected to use this data to poll the bank jobs API & update our D
 
 Note that OurApplicationID refers to the applicationID stored in our persistent storage,
 which is distinctly different from the application_id field returned by the bank API.
+
+Attempt is the number of times the application has already been polled, and is used to
+determine how long to wait before polling it again. FirstSeen is when the application was
+first submitted for polling. Together, they are used to stop polling an application which
+the bank never resolves.
 */
 type PollLoanMessage struct {
-	OurApplicationID  string `json:"our_id" binding:"required"`
-	BankApplicationID string `json:"application_id" binding:"required"`
+	OurApplicationID  string    `json:"our_id" binding:"required"`
+	BankApplicationID string    `json:"application_id" binding:"required"`
+	Attempt           int       `json:"attempt"`
+	FirstSeen         time.Time `json:"first_seen"`
+}
+
+// Types of AlertMessage
+const (
+	ApplicationTimedOutAlert = "application_timed_out"
+	// The application was withdrawn after it was submitted, so the bank may still decide it
+	ApplicationWithdrawnAlert = "application_withdrawn"
+)
+
+/*
+AlertMessage represents an event which requires the attention of an operator. Alerts are
+published to the alert exchange, and can be consumed by binding a queue to it.
+*/
+type AlertMessage struct {
+	Type              string    `json:"type"`
+	OurApplicationID  string    `json:"our_id"`
+	BankApplicationID string    `json:"application_id"`
+	Attempts          int       `json:"attempts"`
+	FirstSeen         time.Time `json:"first_seen"`
+	Reason            string    `json:"reason"`
+	RaisedAt          time.Time `json:"raised_at"`
+}
+
+/*
+StatusChangedEvent is published to the status event exchange when an application is decided, so that
+clients waiting on the application can be told straight away. The poll service writes each event to the event
+outbox along with the decision, and the webhook dispatcher consumes them from a durable queue, so that every
+decision is sent as a webhook. The application in the database remains the source of truth.
+*/
+type StatusChangedEvent struct {
+	ApplicationID string    `json:"application_id"`
+	Status        Status    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+/*
+WebhookMessage is consumed by the webhook dispatcher. It is a StatusChangedEvent, along with the number of
+times that the webhook has already been attempted. A status changed event from the exchange has no Attempt,
+so it is the first attempt.
+*/
+type WebhookMessage struct {
+	StatusChangedEvent
+	Attempt int `json:"attempt,omitempty"`
 }
@@ -0,0 +1,28 @@
+package shared_models
+
+//SortOrder is the order in which a page of applications is sorted by creation time
+type SortOrder string
+
+const (
+	//Oldest sorts the oldest applications first
+	Oldest SortOrder = "asc"
+	//Newest sorts the newest applications first
+	Newest SortOrder = "desc"
+)
+
+//IsValid returns true if s is a SortOrder
+func (s SortOrder) IsValid() bool {
+	return s == Oldest || s == Newest
+}
+
+/*
+PageRequest describes a page of applications to retrieve.
+
+Limit is the maximum number of applications in the page. PageToken is empty for the first page,
+otherwise it is the token returned with the previous page. Sort must be the same for every page.
+*/
+type PageRequest struct {
+	Limit     int
+	PageToken string
+	Sort      SortOrder
+}
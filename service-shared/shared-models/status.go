@@ -1,6 +1,9 @@
 package shared_models
 
-import "github.com/go-playground/validator/v10"
+import (
+	"fmt"
+	"github.com/go-playground/validator/v10"
+)
 
 //Enum defines an abstraction for validating whether a status is valid or not.
 type Enum interface {
@@ -9,21 +12,106 @@ type Enum interface {
 
 type Status string
 
+/*
+The lifecycle of an application is queued -> submitted -> polling -> completed | rejected | timed_out.
+An application which cannot be processed is marked as failed when its message is dead-lettered. It may
+continue from where it failed once the message is replayed.
+An application may be withdrawn at any point before it is decided.
+*/
 const (
+	// Queued is set by the api gateway when an application is stored, before it has reached the bank
+	Queued Status = "queued"
+	// Submitted is set by the create service once the application has been created with the bank
+	Submitted Status = "submitted"
+	// Polling is set by the poll service once the bank has reported that it is deciding the application
+	Polling Status = "polling"
+	// Pending is the status of applications stored before the statuses above were introduced
 	Pending   Status = "pending"
 	Completed Status = "completed"
 	Rejected  Status = "rejected"
+	// TimedOut is set by the poll service when the bank does not resolve an application in time
+	TimedOut Status = "timed_out"
+	// Failed is set by a consumer service when it dead-letters the message for an application
+	Failed Status = "failed"
+	// Withdrawn is set by the api gateway when the applicant withdraws an application before it is decided
+	Withdrawn Status = "withdrawn"
 )
 
+//Statuses lists every valid Status.
+var Statuses = []Status{Queued, Submitted, Polling, Pending, Completed, Rejected, TimedOut, Failed, Withdrawn}
+
 func (s Status) IsValid() bool {
 	switch s {
-	case Pending, Completed, Rejected:
+	case Queued, Submitted, Polling, Pending, Completed, Rejected, TimedOut, Failed, Withdrawn:
 		return true
 	}
 
 	return false
 }
 
+//IsTerminal returns true if the status is a final decision, or the application was withdrawn,
+//after which an application is no longer polled.
+func (s Status) IsTerminal() bool {
+	switch s {
+	case Completed, Rejected, TimedOut, Withdrawn:
+		return true
+	}
+
+	return false
+}
+
+/*
+transitions defines the statuses which an application may move to from each status. A status which
+is not a key, or which maps to no statuses, is final.
+
+A failed application may move to any status after failed in its lifecycle, as its message
+may have been replayed after any step. It may also fail again.
+
+Any application which has not been decided may be withdrawn. A withdrawn application is final, so a
+decision from the bank which arrives after it was withdrawn is not recorded.
+*/
+var transitions = map[Status][]Status{
+	Queued:    {Submitted, Failed, Withdrawn},
+	Submitted: {Polling, Completed, Rejected, TimedOut, Failed, Withdrawn},
+	Polling:   {Completed, Rejected, TimedOut, Failed, Withdrawn},
+	Pending:   {Submitted, Polling, Completed, Rejected, TimedOut, Failed, Withdrawn},
+	Failed:    {Submitted, Polling, Completed, Rejected, TimedOut, Failed, Withdrawn},
+}
+
+//CanTransitionTo returns true if an application with status s may move to status next.
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+//StatusesBefore returns every status from which an application may move to status next.
+func StatusesBefore(next Status) []Status {
+	var before []Status
+	for _, status := range Statuses {
+		if status.CanTransitionTo(next) {
+			before = append(before, status)
+		}
+	}
+
+	return before
+}
+
+//TransitionError is returned when an application cannot move from its current status to another.
+type TransitionError struct {
+	ApplicationID string
+	From          Status
+	To            Status
+}
+
+func (err *TransitionError) Error() string {
+	return fmt.Sprintf("application %s cannot move from status %s to %s", err.ApplicationID, err.From, err.To)
+}
+
 // ValidStatus is a validator function used to ensure that string representations of a loan's status are valid.
 var ValidStatus validator.Func = func(f1 validator.FieldLevel) bool {
 	statusString, ok := f1.Field().Interface().(Enum)
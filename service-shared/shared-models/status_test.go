@@ -26,9 +26,55 @@ func TestStatusRejectedIsValid(t *testing.T) {
 	assert.True(t, status.IsValid())
 }
 
+func TestStatusTimedOutIsValid(t *testing.T) {
+	statusStr := "timed_out"
+	status := Status(statusStr)
+
+	assert.True(t, status.IsValid())
+}
+
+func TestStatusesAreValid(t *testing.T) {
+	for _, status := range Statuses {
+		assert.True(t, status.IsValid(), status)
+	}
+	assert.Contains(t, Statuses, Status("failed"))
+}
+
 func TestStatusUnexpectedIsInvalid(t *testing.T) {
 	statusStr := "some unexpected status"
 	status := Status(statusStr)
 
 	assert.False(t, status.IsValid())
+}
+
+func TestStatusIsTerminal(t *testing.T) {
+	assert.False(t, Pending.IsTerminal())
+	assert.True(t, Completed.IsTerminal())
+	assert.True(t, Rejected.IsTerminal())
+	assert.True(t, TimedOut.IsTerminal())
+	assert.True(t, Withdrawn.IsTerminal())
+	// A failed application can continue once its message is replayed
+	assert.False(t, Failed.IsTerminal())
+}
+
+func TestStatusTransitions(t *testing.T) {
+	assert.True(t, Queued.CanTransitionTo(Submitted))
+	assert.True(t, Polling.CanTransitionTo(Completed))
+	// A late or duplicate poll must not change a decided application
+	assert.False(t, Completed.CanTransitionTo(Rejected))
+	assert.False(t, Rejected.CanTransitionTo(Polling))
+	assert.False(t, TimedOut.CanTransitionTo(Completed))
+	assert.False(t, Polling.CanTransitionTo(Queued))
+	// A replayed message may continue a failed application
+	assert.True(t, Failed.CanTransitionTo(Polling))
+	// Only an application which has not been decided can be withdrawn, and only once
+	assert.True(t, Polling.CanTransitionTo(Withdrawn))
+	assert.False(t, Completed.CanTransitionTo(Withdrawn))
+	assert.False(t, Withdrawn.CanTransitionTo(Withdrawn))
+	assert.False(t, Withdrawn.CanTransitionTo(Completed))
+}
+
+func TestStatusesBefore(t *testing.T) {
+	assert.ElementsMatch(t, []Status{Queued, Pending, Failed}, StatusesBefore(Submitted))
+	assert.Empty(t, StatusesBefore(Queued))
 }
\ No newline at end of file
@@ -0,0 +1,118 @@
+//Package tracing sets up OpenTelemetry tracing for the services, and provides helpers for recording spans.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	sharedconfig "service-shared/shared-config"
+	"time"
+)
+
+// Exporters which can be selected with TRACE_EXPORTER
+const (
+	NoExporter     = "none"
+	StdoutExporter = "stdout"
+	OTLPExporter   = "otlp"
+)
+
+const instrumentationName = "service-shared/tracing"
+
+//ErrUnsupportedExporter is returned by Init when TraceExporter is not one of the supported exporters.
+var ErrUnsupportedExporter = errors.New("unsupported trace exporter")
+
+/*
+Init sets up the global TracerProvider, which records spans for serviceName and exports them to
+cfg.TraceExporter, and the W3C trace context propagator. The propagator is set up even when no exporter
+is configured, so that a trace passing through the service is not broken.
+
+The returned function flushes any spans which have not been exported, waiting up to cfg.ShutdownTimeout.
+It should be called as the service stops.
+*/
+func Init(ctx context.Context, cfg sharedconfig.Config, serviceName string) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil || exporter == nil {
+		return func() {}, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to flush spans : %s\n", err)
+		}
+	}, nil
+}
+
+func newExporter(ctx context.Context, cfg sharedconfig.Config) (sdktrace.SpanExporter, error) {
+	switch cfg.TraceExporter {
+	case NoExporter, "":
+		return nil, nil
+	case StdoutExporter:
+		return stdouttrace.New()
+	case OTLPExporter:
+		// The collector is expected to run alongside the services, so the connection is not encrypted
+		return otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(cfg.TraceOTLPEndpoint), otlptracegrpc.WithInsecure())
+	}
+
+	return nil, fmt.Errorf("%w : %s", ErrUnsupportedExporter, cfg.TraceExporter)
+}
+
+//Tracer returns the tracer used to start the spans of the services.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+//End ends span, first recording err, if it is not nil, and marking the span as failed.
+func End(span trace.Span, err error) {
+	RecordError(span, err, "")
+	span.End()
+}
+
+//RecordError records err against span and marks the span as failed, with description if it is not empty.
+//Nothing is recorded if err is nil.
+func RecordError(span trace.Span, err error, description string) {
+	if err == nil {
+		return
+	}
+	if len(description) == 0 {
+		description = err.Error()
+	}
+
+	span.RecordError(err, trace.WithTimestamp(time.Now()))
+	span.SetStatus(codes.Error, description)
+}
+
+/*
+Inject returns the trace context of ctx as a map, so that it can be stored with work which is carried out later,
+such as a message in the outbox. The trace is continued by passing the map to Extract.
+*/
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+//Extract returns a copy of ctx, which continues the trace recorded in carrier by Inject.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
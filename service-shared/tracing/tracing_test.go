@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	sharedconfig "service-shared/shared-config"
+	"testing"
+)
+
+func TestInitUnsupportedExporter(t *testing.T) {
+	_, err := Init(context.Background(), sharedconfig.Config{TraceExporter: "zipkin"}, "test-service")
+
+	assert.True(t, errors.Is(err, ErrUnsupportedExporter))
+}
+
+func TestInitWithoutExporter(t *testing.T) {
+	shutdown, err := Init(context.Background(), sharedconfig.Config{TraceExporter: NoExporter}, "test-service")
+
+	assert.Nil(t, err)
+	assert.NotNil(t, shutdown)
+	shutdown()
+}
+
+func TestExtractContinuesInjectedTrace(t *testing.T) {
+	// Setup
+	_, err := Init(context.Background(), sharedconfig.Config{TraceExporter: NoExporter}, "test-service")
+	assert.Nil(t, err)
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02, 0x03},
+		SpanID:     trace.SpanID{0x04, 0x05, 0x06},
+		TraceFlags: trace.FlagsSampled,
+	})
+	carrier := Inject(trace.ContextWithSpanContext(context.Background(), spanContext))
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), carrier))
+
+	assert.Contains(t, carrier, "traceparent")
+	assert.Equal(t, spanContext.TraceID(), extracted.TraceID())
+	assert.Equal(t, spanContext.SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+}
+
+func TestExtractWithoutTraceContext(t *testing.T) {
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), nil))
+
+	assert.False(t, extracted.IsValid())
+}
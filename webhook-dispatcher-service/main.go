@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
+	"math/rand"
+	"os/signal"
+	"service-shared/database"
+	sharedhttp "service-shared/http"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	helpers "service-shared/shared-helpers"
+	"service-shared/tracing"
+	"sync"
+	"syscall"
+	"time"
+	"webhook-dispatcher-service/repositorys"
+)
+
+/*
+main Starts the Webhook Dispatcher Service
+
+This service is a consumer service. It is responsible for retrieving
+status changed events off a queue bound to the status event exchange.
+When an application with a callback URL is completed or rejected, this
+service posts a signed webhook to the callback URL.
+
+This function spawns parallel workers.
+For implementation details, please see repositorys.mq_worker.
+*/
+const serviceName = "webhook-dispatcher-service"
+
+func main() {
+	cfg := sharedconfig.Get()
+	if len(cfg.WebhookSecret) == 0 {
+		helpers.FailOnError(errors.New("WEBHOOK_SECRET is not set"), "Cannot sign webhooks : ")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// Seed the jitter applied to webhook backoffs
+	rand.Seed(time.Now().UnixNano())
+
+	// Record the spans of each webhook, and of the requests made to deliver it
+	shutdownTracing, err := tracing.Init(ctx, cfg, serviceName)
+	helpers.FailOnError(err, "Failed to initialise tracing")
+	defer shutdownTracing()
+
+	fmt.Println("Connecting to db ... ")
+	dbClient, err := database.InitClient(ctx, cfg)
+	helpers.FailOnError(err, "Failed to initialise the db client")
+	defer dbClient.Disconnect(context.Background())
+	webhookCollection := dbClient.Database(cfg.DatabaseName).Collection(cfg.WebhookCollectionName)
+	indexCtx, cancelIndexes := context.WithTimeout(ctx, cfg.DBConnectTimeout)
+	database.InitWebhookIndexes(indexCtx, webhookCollection)
+	cancelIndexes()
+	repository := database.NewMongoRepository(database.Collections{
+		Applications: database.NewMongoCollection(dbClient.Database(cfg.DatabaseName).Collection(cfg.DBColletionName)),
+		Webhooks:     database.NewMongoCollection(webhookCollection),
+	}, database.NewMongoTransactor(dbClient), cfg)
+
+	fmt.Println("Connecting to RabbitMQ ... ")
+	// The connection, and every channel opened on it, is recovered if it is lost
+	connection := messagequeue.NewConnectionManager(cfg.RabbitMQURL, helpers.Backoff{
+		Base:   cfg.RabbitMQReconnectBase,
+		Max:    cfg.RabbitMQReconnectMax,
+		Jitter: 0.2,
+	})
+	connection.Connect()
+	defer connection.Close()
+
+	// Failed messages are published to the dead letter exchange
+	deadLetterPublisher, err := messagequeue.NewReconnectingPublisher(connection, "dead letter publisher", cfg.PublishConfirmTimeout, nil)
+	helpers.FailOnError(err, "Failed to open a dead letter channel to RabbitMQ")
+
+	// Webhooks which are not delivered are published to wait queues, to be sent again later
+	retryQueue := repositorys.NewRabbitRetryQueue(connection, cfg)
+
+	// Status changed events are copied to the webhook queue
+	err = repositorys.BindWebhookQueue(connection, cfg)
+	helpers.FailOnError(err, "Failed to bind the webhook queue to the status event exchange")
+
+	// Workers to process messages received from the queue
+	fmt.Println("Creating workers to consume from rabbit MQ")
+	in := make(chan amqp.Delivery)
+	wg := &sync.WaitGroup{}
+	// Work which is still in progress at the shutdown deadline is cancelled, and its message requeued
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	maxWorkers := cfg.WebhookServiceWorkers
+	handler := messagequeue.NewAmqpDeliveryHandler(deadLetterPublisher, cfg.DeadLetterExchangeName, cfg.WebhookQueueName, serviceName)
+	// Callback URLs are supplied by clients, so webhooks are only sent to public addresses, and redirects are not followed
+	httpClient := sharedhttp.DefaultClient{HttpClient: sharedhttp.NewPublicOnlyClient()}
+	wg.Add(maxWorkers)
+	for i := 1; i <= maxWorkers; i++ {
+		worker := repositorys.NewRabbitMQWorker(repository, wg, in, cfg, handler, httpClient, retryQueue)
+		go worker.ProcessMessages(ctx, workCtx)
+	}
+	// Consumes messages from the queue, passes to in, which is consumed by the workers
+	consumer := messagequeue.NewRabbitMQConsumer(connection, maxWorkers, in, cfg.WebhookQueueName, cfg.DeadLetterExchangeName)
+	err = consumer.Consume(ctx)
+	helpers.FailOnError(err, "Failed to consume from the webhook queue")
+
+	// On shutdown, stop consuming and wait for the workers to finish their current message.
+	// Messages which are still unacked when the connection closes are requeued by the broker.
+	<-ctx.Done()
+	fmt.Println("Webhook Dispatcher service is shutting down ...")
+	time.AfterFunc(cfg.ShutdownTimeout, cancelWork)
+	// Allow the workers a moment to requeue cancelled messages
+	if !helpers.WaitTimeout(wg, cfg.ShutdownTimeout+time.Second) {
+		log.Printf("Timed out waiting for workers to finish, unacked messages will be requeued\n")
+	}
+}
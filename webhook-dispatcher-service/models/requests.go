@@ -0,0 +1,15 @@
+//Package models represents models for HTTP data
+package models
+
+import (
+	sharedmodels "service-shared/shared-models"
+	"time"
+)
+
+//WebhookPayload is the body posted to the callback URL of an application once it has been decided
+type WebhookPayload struct {
+	ApplicationID string              `json:"application_id"`
+	Status        sharedmodels.Status `json:"status"`
+	Reason        string              `json:"reason,omitempty"`
+	DecidedAt     time.Time           `json:"decided_at"`
+}
@@ -0,0 +1,202 @@
+package repositorys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
+	"service-shared/database"
+	sharedhttp "service-shared/http"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"strconv"
+	"sync"
+	"time"
+	"webhook-dispatcher-service/models"
+)
+
+/*
+RabbitMQWorker defines a worker responsible for handling
+messages from a rabbitMQ queue. It receives Deliveries via
+inChan and will attempt to process them.
+*/
+type RabbitMQWorker struct {
+	repository      database.Repository
+	wg              *sync.WaitGroup
+	inChan          <-chan amqp.Delivery
+	cfg             sharedconfig.Config
+	deliveryHandler messagequeue.DeliveryHandler
+	httpClient      sharedhttp.Client
+	retryQueue      RetryQueue
+}
+
+func NewRabbitMQWorker(
+	repo database.Repository,
+	wg *sync.WaitGroup,
+	inChan <-chan amqp.Delivery,
+	cfg sharedconfig.Config,
+	handler messagequeue.DeliveryHandler,
+	httpClient sharedhttp.Client,
+	retryQueue RetryQueue) RabbitMQWorker {
+	return RabbitMQWorker{
+		repository:      repo,
+		wg:              wg,
+		inChan:          inChan,
+		cfg:             cfg,
+		deliveryHandler: handler,
+		httpClient:      httpClient,
+		retryQueue:      retryQueue,
+	}
+}
+
+//ProcessMessages receives deliveries from its inChan and delegates
+//processing responsibilities to processMessage(ctx, delivery amqp.Delivery).
+//It returns once ctx is cancelled, after finishing the delivery it is currently processing.
+//Deliveries are processed using workCtx. If workCtx is cancelled, the current delivery is requeued.
+func (worker RabbitMQWorker) ProcessMessages(ctx, workCtx context.Context) {
+	defer worker.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-worker.inChan:
+			worker.processMessage(workCtx, delivery)
+		}
+	}
+}
+
+/*
+processMessage will process a delivery message.
+
+If the application has been completed or rejected, and has a callback URL, a signed webhook is posted to it.
+Other status changes, and applications without a callback URL, are acked without sending anything.
+Every attempt is recorded in the database, whether or not it succeeded.
+
+A webhook which is not delivered is scheduled to be sent again after an exponential backoff. Once it has
+been attempted WebhookMaxAttempts times, the message is passed to the dead letter queue instead.
+
+Each delivery continues the trace of the poll which decided the application, as status events are relayed
+with its trace context. A webhook scheduled again by the RetryQueue starts a new trace.
+*/
+func (worker RabbitMQWorker) processMessage(ctx context.Context, delivery amqp.Delivery) {
+	ctx, span := messagequeue.StartConsumeSpan(ctx, worker.cfg.WebhookQueueName, delivery)
+	defer span.End()
+
+	var message sharedmodels.WebhookMessage
+	err := json.Unmarshal(delivery.Body, &message)
+	if messagequeue.CheckError(
+		err,
+		fmt.Sprintf("Could not unmarshal message %s to WebhookMessage - bad data on queue?\n", delivery.Body),
+		delivery,
+		worker.deliveryHandler) {
+		return
+	}
+
+	if !isDecision(message.Status) {
+		worker.deliveryHandler.Ack(false, delivery)
+		return
+	}
+
+	entry, err := worker.repository.GetApplication(ctx, message.ApplicationID)
+	if messagequeue.CheckErrorContext(ctx, err, "Failed to get the application for a webhook", delivery, worker.deliveryHandler) {
+		return
+	}
+	if len(entry.CallbackURL) == 0 {
+		worker.deliveryHandler.Ack(false, delivery)
+		return
+	}
+
+	err = worker.sendWebhook(ctx, entry.CallbackURL, message)
+	if err == nil {
+		fmt.Printf("Sent %s webhook for application %s\n", message.Status, message.ApplicationID)
+		worker.deliveryHandler.Ack(false, delivery)
+		return
+	}
+
+	if ctx.Err() != nil {
+		// The service is shutting down, so this attempt does not count against the receiver
+		fmt.Printf("Requeueing webhook after the context was cancelled : %s\n", err)
+		worker.deliveryHandler.Nack(false, true, delivery)
+		return
+	}
+
+	if message.Attempt+1 >= worker.cfg.WebhookMaxAttempts {
+		reason := fmt.Sprintf("Webhook was not delivered after %d attempts : %s", message.Attempt+1, err)
+		fmt.Println(reason)
+		worker.deliveryHandler.DeadLetter(reason, delivery)
+		return
+	}
+
+	worker.scheduleRetry(message, delivery)
+}
+
+/*
+sendWebhook posts a signed WebhookPayload for message to callbackURL, and records the attempt.
+Any response other than 2xx is an error. Failing to record the attempt is logged rather than
+returned, as the webhook has already been sent by then.
+*/
+func (worker RabbitMQWorker) sendWebhook(ctx context.Context, callbackURL string, message sharedmodels.WebhookMessage) error {
+	body, _ := json.Marshal(models.WebhookPayload{
+		ApplicationID: message.ApplicationID,
+		Status:        message.Status,
+		Reason:        message.Reason,
+		DecidedAt:     message.At,
+	})
+	now := time.Now().UTC()
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		TimestampHeader: strconv.FormatInt(now.Unix(), 10),
+		SignatureHeader: Sign([]byte(worker.cfg.WebhookSecret), now.Unix(), body),
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, worker.cfg.WebhookTimeout)
+	defer cancel()
+	resp, err := worker.httpClient.PostWithHeaders(sendCtx, callbackURL, headers, bytes.NewReader(body))
+
+	attempt := sharedmodels.WebhookAttemptEntry{
+		ApplicationID: message.ApplicationID,
+		URL:           callbackURL,
+		Status:        message.Status,
+		Attempt:       message.Attempt + 1,
+		AttemptedAt:   now,
+	}
+	if err == nil {
+		attempt.ResponseStatus = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = errors.New(fmt.Sprintf("Unexpected response code from webhook receiver : %d", resp.StatusCode))
+		}
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	attempt.Delivered = err == nil
+
+	if recordErr := worker.repository.RecordWebhookAttempt(ctx, attempt); recordErr != nil {
+		log.Printf("Failed to record webhook attempt %d for application %s : %s\n", attempt.Attempt, message.ApplicationID, recordErr)
+	}
+
+	return err
+}
+
+//scheduleRetry schedules the next attempt of a webhook which was not delivered, then acks the current delivery.
+func (worker RabbitMQWorker) scheduleRetry(message sharedmodels.WebhookMessage, delivery amqp.Delivery) {
+	message.Attempt++
+	err := worker.retryQueue.ScheduleDelivery(message)
+	if err != nil {
+		// Fall back to requeueing, so that the webhook is not lost
+		log.Printf("Failed to schedule a webhook for application %s, requeueing : %s\n", message.ApplicationID, err)
+		worker.deliveryHandler.Nack(false, true, delivery)
+		return
+	}
+
+	worker.deliveryHandler.Ack(false, delivery)
+}
+
+//isDecision returns true for the statuses which are sent to callback URLs
+func isDecision(status sharedmodels.Status) bool {
+	return status == sharedmodels.Completed || status == sharedmodels.Rejected
+}
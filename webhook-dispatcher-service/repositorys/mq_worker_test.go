@@ -0,0 +1,255 @@
+package repositorys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"service-shared/database"
+	sharedhttp "service-shared/http"
+	shareddb "service-shared/mocks/database"
+	sharedhttpmocks "service-shared/mocks/http"
+	sharedmq "service-shared/mocks/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedmodels "service-shared/shared-models"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	mocks "webhook-dispatcher-service/mocks/repositorys"
+	"webhook-dispatcher-service/models"
+)
+
+const (
+	applicationID = "62ceaefa5338ed06fe445e18"
+	webhookSecret = "secret"
+)
+
+func TestProcessMessagesReturnsWhenCancelled(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	inChan := make(chan amqp.Delivery)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := NewRabbitMQWorker(new(shareddb.Repository), wg, inChan, getConfig(), new(sharedmq.DeliveryHandler), new(sharedhttpmocks.Client), new(mocks.RetryQueue))
+
+	cancel()
+	worker.ProcessMessages(ctx, context.Background())
+
+	// Assert that the worker has marked itself as done
+	wg.Wait()
+}
+
+func TestProcessMessageSendsSignedWebhook(t *testing.T) {
+	// A stub receiver, which records the webhook it is sent
+	var received *http.Request
+	var receivedBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	decidedAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	delivery := getDeliveryWithMessage(sharedmodels.WebhookMessage{StatusChangedEvent: sharedmodels.StatusChangedEvent{
+		ApplicationID: applicationID, Status: sharedmodels.Completed, Reason: "Decided by the bank API", At: decidedAt,
+	}})
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	repository := getRepository(receiver.URL + "/loans")
+	httpClient := sharedhttp.DefaultClient{HttpClient: receiver.Client()}
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, httpClient, new(mocks.RetryQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the receiver can check the signature
+	timestamp, _ := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+	assert.Equal(t, "/loans", received.URL.Path)
+	assert.Equal(t, "application/json", received.Header.Get("Content-Type"))
+	assert.Equal(t, Sign([]byte(webhookSecret), timestamp, receivedBody), received.Header.Get(SignatureHeader))
+
+	var payload models.WebhookPayload
+	json.Unmarshal(receivedBody, &payload)
+	assert.Equal(t, models.WebhookPayload{
+		ApplicationID: applicationID, Status: sharedmodels.Completed, Reason: "Decided by the bank API", DecidedAt: decidedAt,
+	}, payload)
+
+	// Assert that the attempt is recorded, and the message is ack'd
+	repository.AssertCalled(t, "RecordWebhookAttempt", mock.Anything, mock.MatchedBy(func(attempt sharedmodels.WebhookAttemptEntry) bool {
+		return attempt.Attempt == 1 && attempt.Delivered && attempt.ResponseStatus == http.StatusNoContent && len(attempt.Error) == 0
+	}))
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageIgnoresOtherStatuses(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.TimedOut, 0)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	repository := new(shareddb.Repository)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, new(sharedhttpmocks.Client), new(mocks.RetryQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	repository.AssertNotCalled(t, "GetApplication", mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageWithoutCallbackURL(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.Rejected, 0)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	repository := getRepository("")
+	httpClient := new(sharedhttpmocks.Client)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, httpClient, new(mocks.RetryQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+	httpClient.AssertNotCalled(t, "PostWithHeaders", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMessageFailsToUnmarshalBody(t *testing.T) {
+	delivery := getDeliveryWithBody([]byte("{invalidjson,"))
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+
+	worker := NewRabbitMQWorker(new(shareddb.Repository), &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, new(sharedhttpmocks.Client), new(mocks.RetryQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageInternalDbError(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.Completed, 0)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, applicationID).Return(nil, database.InternalError)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, new(sharedhttpmocks.Client), new(mocks.RetryQueue))
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ
+	deliveryHandler.AssertCalled(t, "DeadLetter", mock.Anything, delivery)
+}
+
+func TestProcessMessageSchedulesRetry(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.Completed, 2)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Ack", false, delivery).Return(nil)
+	repository := getRepository("https://example.com/loans")
+	httpClient := new(sharedhttpmocks.Client)
+	httpClient.On("PostWithHeaders", mock.Anything, "https://example.com/loans", mock.Anything, mock.Anything).
+		Return(&sharedhttp.ClientResponse{StatusCode: http.StatusServiceUnavailable}, nil)
+	retryQueue := new(mocks.RetryQueue)
+	retryQueue.On("ScheduleDelivery", mock.Anything).Return(nil)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, httpClient, retryQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the failed attempt is recorded, and the webhook is sent again later
+	repository.AssertCalled(t, "RecordWebhookAttempt", mock.Anything, mock.MatchedBy(func(attempt sharedmodels.WebhookAttemptEntry) bool {
+		return attempt.Attempt == 3 && !attempt.Delivered && attempt.ResponseStatus == http.StatusServiceUnavailable && len(attempt.Error) > 0
+	}))
+	retryQueue.AssertCalled(t, "ScheduleDelivery", mock.MatchedBy(func(message sharedmodels.WebhookMessage) bool {
+		return message.ApplicationID == applicationID && message.Attempt == 3
+	}))
+	deliveryHandler.AssertCalled(t, "Ack", false, delivery)
+}
+
+func TestProcessMessageScheduleError(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.Completed, 0)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	repository := getRepository("https://example.com/loans")
+	httpClient := new(sharedhttpmocks.Client)
+	httpClient.On("PostWithHeaders", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("connection refused"))
+	retryQueue := new(mocks.RetryQueue)
+	retryQueue.On("ScheduleDelivery", mock.Anything).Return(errors.New(""))
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, httpClient, retryQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is requeued rather than lost
+	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+}
+
+func TestProcessMessageDeadLettersAfterMaxAttempts(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.Rejected, 4)
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("DeadLetter", mock.Anything, delivery).Return(nil)
+	repository := getRepository("https://example.com/loans")
+	httpClient := new(sharedhttpmocks.Client)
+	httpClient.On("PostWithHeaders", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("connection refused"))
+	retryQueue := new(mocks.RetryQueue)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, httpClient, retryQueue)
+	worker.processMessage(context.Background(), delivery)
+
+	// Assert that the message is sent to DLQ, rather than retried again
+	deliveryHandler.AssertCalled(t, "DeadLetter", "Webhook was not delivered after 5 attempts : connection refused", delivery)
+	retryQueue.AssertNotCalled(t, "ScheduleDelivery", mock.Anything)
+}
+
+func TestProcessMessageRequeuesWhenCancelled(t *testing.T) {
+	delivery := getDeliveryWithStatus(sharedmodels.Completed, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	// Setup
+	deliveryHandler := new(sharedmq.DeliveryHandler)
+	deliveryHandler.On("Nack", false, true, delivery).Return(nil)
+	repository := getRepository("https://example.com/loans")
+	httpClient := new(sharedhttpmocks.Client)
+	httpClient.On("PostWithHeaders", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, context.Canceled).
+		Run(func(args mock.Arguments) { cancel() })
+	retryQueue := new(mocks.RetryQueue)
+
+	worker := NewRabbitMQWorker(repository, &sync.WaitGroup{}, nil, getConfig(), deliveryHandler, httpClient, retryQueue)
+	worker.processMessage(ctx, delivery)
+
+	// Assert that the message is requeued, rather than retried or dead-lettered
+	deliveryHandler.AssertCalled(t, "Nack", false, true, delivery)
+	retryQueue.AssertNotCalled(t, "ScheduleDelivery", mock.Anything)
+}
+
+func getConfig() sharedconfig.Config {
+	return sharedconfig.Config{WebhookSecret: webhookSecret, WebhookTimeout: 5 * time.Second, WebhookMaxAttempts: 5}
+}
+
+// getRepository returns a Repository mock holding an application with the given callback URL
+func getRepository(callbackURL string) *shareddb.Repository {
+	repository := new(shareddb.Repository)
+	repository.On("GetApplication", mock.Anything, applicationID).Return(&sharedmodels.ApplicationEntry{CallbackURL: callbackURL}, nil)
+	repository.On("RecordWebhookAttempt", mock.Anything, mock.Anything).Return(nil)
+	return repository
+}
+
+func getDeliveryWithStatus(status sharedmodels.Status, attempt int) amqp.Delivery {
+	return getDeliveryWithMessage(sharedmodels.WebhookMessage{
+		StatusChangedEvent: sharedmodels.StatusChangedEvent{ApplicationID: applicationID, Status: status},
+		Attempt:            attempt,
+	})
+}
+
+func getDeliveryWithMessage(msg sharedmodels.WebhookMessage) amqp.Delivery {
+	bytes, _ := json.Marshal(msg)
+
+	return getDeliveryWithBody(bytes)
+}
+
+func getDeliveryWithBody(body []byte) amqp.Delivery {
+	return amqp.Delivery{Body: body}
+}
@@ -0,0 +1,94 @@
+package repositorys
+
+import (
+	"encoding/json"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+	"strconv"
+)
+
+//RetryQueue defines an interface for scheduling a webhook to be sent again later.
+type RetryQueue interface {
+	ScheduleDelivery(message sharedmodels.WebhookMessage) error
+}
+
+/*
+RabbitRetryQueue schedules webhooks using RabbitMQ wait queues, in the same way as the poll service
+schedules polls.
+
+There is a wait queue for each step of the backoff, named WaitQueueName(webhookQueue, step).
+Nothing consumes from the wait queues. Instead, each message is published with a TTL, and
+when it expires RabbitMQ dead-letters it back onto the webhook queue, where it is consumed as usual.
+*/
+type RabbitRetryQueue struct {
+	publisher messagequeue.Publisher
+	backoff   sharedhelpers.Backoff
+	cfg       sharedconfig.Config
+}
+
+//NewRabbitRetryQueue returns a RabbitRetryQueue. It publishes on a channel managed by connection,
+//which declares the wait queues each time it is opened.
+func NewRabbitRetryQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) *RabbitRetryQueue {
+	backoff := sharedhelpers.Backoff{Base: cfg.WebhookBackoffBase, Max: cfg.WebhookBackoffMax, Jitter: cfg.WebhookBackoffJitter}
+	declare := func(ch *amqp.Channel) error {
+		for step := 0; step <= backoff.Steps(); step++ {
+			_, err := ch.QueueDeclare(
+				WaitQueueName(cfg.WebhookQueueName, step), // name
+				true,  // durable (survive restarts)
+				false, // do not delete when unused
+				false, // exclusive
+				false, // no-wait
+				amqp.Table{
+					// Expired messages are routed back to the webhook queue via the default exchange
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": cfg.WebhookQueueName,
+				})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	publisher, err := messagequeue.NewReconnectingPublisher(connection, "retry publisher", cfg.PublishConfirmTimeout, declare)
+	sharedhelpers.FailOnError(err, "Failed to open a retry channel to RabbitMQ")
+	return &RabbitRetryQueue{publisher: publisher, backoff: backoff, cfg: cfg}
+}
+
+//WaitQueueName returns the name of the wait queue used for the given step of the backoff.
+func WaitQueueName(webhookQueueName string, step int) string {
+	return fmt.Sprintf("%s.wait.%d", webhookQueueName, step)
+}
+
+/*
+ScheduleDelivery publishes message to a wait queue, from which it is returned to the webhook queue
+after a delay determined by message.Attempt. Every attempt after the last step of the backoff
+is delayed by WebhookBackoffMax.
+
+A nil error means that the broker has confirmed the message, and it is safe to ack the current delivery.
+*/
+func (queue RabbitRetryQueue) ScheduleDelivery(message sharedmodels.WebhookMessage) error {
+	step := message.Attempt - 1
+	if step < 0 {
+		step = 0
+	}
+	if step > queue.backoff.Steps() {
+		step = queue.backoff.Steps()
+	}
+	delay := queue.backoff.Delay(step)
+
+	body, _ := json.Marshal(message)
+	return queue.publisher.Publish(
+		"",
+		WaitQueueName(queue.cfg.WebhookQueueName, step),
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			Expiration:   strconv.FormatInt(delay.Milliseconds(), 10),
+			Body:         body,
+		})
+}
@@ -0,0 +1,54 @@
+package repositorys
+
+import (
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	sharedmq "service-shared/mocks/message-queue"
+	sharedconfig "service-shared/shared-config"
+	sharedhelpers "service-shared/shared-helpers"
+	sharedmodels "service-shared/shared-models"
+	"testing"
+	"time"
+)
+
+func TestScheduleDeliveryUsesWaitQueueForAttempt(t *testing.T) {
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", "", "webhooks.wait.1", mock.Anything).Return(nil)
+	queue := getRetryQueue(publisher)
+	message := sharedmodels.WebhookMessage{
+		StatusChangedEvent: sharedmodels.StatusChangedEvent{ApplicationID: "abc", Status: sharedmodels.Completed},
+		Attempt:            2,
+	}
+
+	err := queue.ScheduleDelivery(message)
+
+	assert.Nil(t, err)
+	publishing := publisher.Calls[0].Arguments.Get(2).(amqp.Publishing)
+	assert.Equal(t, "2000", publishing.Expiration)
+	assert.Equal(t, amqp.Persistent, publishing.DeliveryMode)
+	var published sharedmodels.WebhookMessage
+	json.Unmarshal(publishing.Body, &published)
+	assert.Equal(t, message, published)
+}
+
+func TestScheduleDeliveryUsesLastWaitQueueAfterMax(t *testing.T) {
+	publisher := new(sharedmq.Publisher)
+	publisher.On("Publish", "", "webhooks.wait.3", mock.Anything).Return(nil)
+	queue := getRetryQueue(publisher)
+
+	err := queue.ScheduleDelivery(sharedmodels.WebhookMessage{Attempt: 10})
+
+	assert.Nil(t, err)
+	publishing := publisher.Calls[0].Arguments.Get(2).(amqp.Publishing)
+	assert.Equal(t, "5000", publishing.Expiration)
+}
+
+func getRetryQueue(publisher *sharedmq.Publisher) RabbitRetryQueue {
+	return RabbitRetryQueue{
+		publisher: publisher,
+		backoff:   sharedhelpers.Backoff{Base: time.Second, Max: 5 * time.Second},
+		cfg:       sharedconfig.Config{WebhookQueueName: "webhooks"},
+	}
+}
@@ -0,0 +1,28 @@
+package repositorys
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Headers sent with each webhook, so that the receiver can check that it was sent by this service
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+/*
+Sign returns the value of the SignatureHeader for a webhook body sent at timestamp, in unix seconds.
+
+The signature is "sha256=" followed by the hex encoded HMAC-SHA256, keyed with secret, of the timestamp
+and the body joined by a '.'. Including the timestamp lets a receiver reject a signed webhook which is
+replayed long after it was sent.
+*/
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,15 @@
+package repositorys
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	// Reproduce with: printf '1656633600.{}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=1cad3bca9006363a042ed338e8baea3050204b2e7bf4011ed3060e324d05ab18"
+
+	assert.Equal(t, expected, Sign([]byte("secret"), 1656633600, []byte("{}")))
+	assert.NotEqual(t, expected, Sign([]byte("other"), 1656633600, []byte("{}")))
+	assert.NotEqual(t, expected, Sign([]byte("secret"), 1656633601, []byte("{}")))
+}
@@ -0,0 +1,39 @@
+package repositorys
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	messagequeue "service-shared/message-queue"
+	sharedconfig "service-shared/shared-config"
+)
+
+/*
+BindWebhookQueue binds the webhook queue to the status event exchange, so that it receives a copy of
+every status changed event published by the poll service.
+
+Unlike the queues of the API gateway, the webhook queue is durable and shared by every dispatcher, so events
+published while the dispatchers are down are sent once they start. The binding is declared again whenever
+connection reopens the channel.
+*/
+func BindWebhookQueue(connection *messagequeue.ConnectionManager, cfg sharedconfig.Config) error {
+	return connection.OpenChannel("webhook queue binding", func(ch *amqp.Channel) error {
+		err := ch.ExchangeDeclare(
+			cfg.StatusEventExchangeName, // name
+			"fanout",                    // kind
+			true,                        // durable (survive restarts)
+			false,                       // do not delete when unused
+			false,                       // internal
+			false,                       // no-wait
+			nil)                         // args
+		if err != nil {
+			return err
+		}
+
+		// Declared in the same way as the consumer, so the arguments match
+		queue, err := messagequeue.DeclareQueue(ch, cfg.WebhookQueueName, cfg.DeadLetterExchangeName)
+		if err != nil {
+			return err
+		}
+
+		return ch.QueueBind(queue.Name, "", cfg.StatusEventExchangeName, false, nil)
+	})
+}